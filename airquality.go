@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chrissnell/weather-bar/internal/noaa"
+)
+
+const (
+	openMeteoAirQualityBaseURL = "https://air-quality-api.open-meteo.com"
+	airNowAPIBaseURL           = "https://www.airnowapi.org"
+)
+
+// By default, AirNow looks for a monitor within this many miles of us
+const defaultAirNowDistance = 25
+
+// Open-Meteo reports ozone in μg/m³.  This converts it to parts per billion at
+// 25°C and sea-level pressure.
+const ozonePPBPerMicrogram = 24.45 / 48.00
+
+// airQualityTokens are the weather-format tokens filled in from the air quality.
+// We only fetch it if the format uses one of them or an air quality class.
+var airQualityTokens = []string{
+	"%aqi%",
+	"%aqi-category%",
+	"%pm25%",
+	"%ozone%",
+	"%uv-index%",
+}
+
+// aqiCategories are the EPA's AQI categories, each with the highest AQI in it and
+// the class that it sets
+var aqiCategories = []struct {
+	max   float64
+	name  string
+	class string
+}{
+	{50, "Good", "aqi-good"},
+	{100, "Moderate", "aqi-moderate"},
+	{150, "Unhealthy for Sensitive Groups", "aqi-usg"},
+	{200, "Unhealthy", "aqi-unhealthy"},
+	{300, "Very Unhealthy", "aqi-very-unhealthy"},
+	{math.Inf(1), "Hazardous", "aqi-hazardous"},
+}
+
+// uvCategories are the WHO's UV index categories, each with the highest UV index
+// in it and the class that it sets
+var uvCategories = []struct {
+	max   float64
+	class string
+}{
+	{2, "uv-low"},
+	{5, "uv-moderate"},
+	{7, "uv-high"},
+	{10, "uv-very-high"},
+	{math.Inf(1), "uv-extreme"},
+}
+
+// aqiBreakpoint maps a range of pollutant concentrations onto a range of AQI
+type aqiBreakpoint struct {
+	cLow, cHigh float64
+	iLow, iHigh float64
+}
+
+// The EPA's AQI breakpoints for 24-hour PM2.5 in μg/m³ (as revised in 2024) and
+// 8-hour ozone in ppb.  Ozone above 200 ppb is reported from 1-hour averages,
+// with its own breakpoints, so the last ozone breakpoint is only approximate.
+var (
+	pm25Breakpoints = []aqiBreakpoint{
+		{0, 9.0, 0, 50},
+		{9.1, 35.4, 51, 100},
+		{35.5, 55.4, 101, 150},
+		{55.5, 125.4, 151, 200},
+		{125.5, 225.4, 201, 300},
+		{225.5, 325.4, 301, 500},
+	}
+	ozoneBreakpoints = []aqiBreakpoint{
+		{0, 54, 0, 50},
+		{55, 70, 51, 100},
+		{71, 85, 101, 150},
+		{86, 105, 151, 200},
+		{106, 200, 201, 300},
+		{201, 604, 301, 500},
+	}
+)
+
+// AirQuality is the air quality and UV index at our location.  The Has fields are
+// false when no source gave us that value.
+type AirQuality struct {
+	AQI        float64
+	HasAQI     bool
+	PM25       float64
+	HasPM25    bool
+	Ozone      float64
+	HasOzone   bool
+	UVIndex    float64
+	HasUVIndex bool
+}
+
+// OpenMeteoAirQuality encapsulates an Open-Meteo air quality API response
+type OpenMeteoAirQuality struct {
+	Current struct {
+		USAQI   *float64 `json:"us_aqi"`
+		PM25    *float64 `json:"pm2_5"`
+		Ozone   *float64 `json:"ozone"`
+		UVIndex *float64 `json:"uv_index"`
+	} `json:"current"`
+}
+
+// AirNowObservation is one pollutant's current AQI from the AirNow API
+type AirNowObservation struct {
+	ParameterName string  `json:"ParameterName"`
+	AQI           float64 `json:"AQI"`
+}
+
+// usesAirQuality returns true if the format shows the air quality or tests its
+// classes
+func usesAirQuality(format string) bool {
+	for _, token := range airQualityTokens {
+		if strings.Contains(format, token) {
+			return true
+		}
+	}
+	var classes []string
+	for _, c := range aqiCategories {
+		classes = append(classes, c.class)
+	}
+	for _, c := range uvCategories {
+		classes = append(classes, c.class)
+	}
+	return usesClasses(format, classes...)
+}
+
+// airQualitySource is somewhere that we get the air quality from
+type airQualitySource interface {
+	// name identifies the source in log messages
+	name() string
+
+	// fetchAirQuality returns the current air quality at the point
+	fetchAirQuality(ctx context.Context, point noaa.Point) (AirQuality, error)
+}
+
+// newAirQualitySources builds the air quality sources listed in the config file
+func newAirQualitySources(cfg AirQualityConfig, client *http.Client) ([]airQualitySource, error) {
+	names := cfg.Sources
+	if strings.TrimSpace(names) == "" {
+		names = "openmeteo"
+	}
+
+	var sources []airQualitySource
+	for _, name := range strings.Split(names, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "":
+			continue
+		case "openmeteo":
+			sources = append(sources, &openMeteoAirQualitySource{baseURL: openMeteoAirQualityBaseURL, client: client})
+		case "airnow":
+			if cfg.AirNowAPIKey == "" {
+				return nil, fmt.Errorf("the airnow source requires airnow-api-key")
+			}
+			distance := defaultAirNowDistance
+			if cfg.AirNowDistance != "" {
+				d, err := strconv.Atoi(cfg.AirNowDistance)
+				if err != nil || d < 1 {
+					return nil, fmt.Errorf("invalid value for airnow-distance: %v", cfg.AirNowDistance)
+				}
+				distance = d
+			}
+			sources = append(sources, &airNowSource{baseURL: airNowAPIBaseURL, client: client, apiKey: cfg.AirNowAPIKey, distance: distance})
+		default:
+			return nil, fmt.Errorf("unknown air quality source %q (available: airnow, openmeteo)", name)
+		}
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("invalid value for sources in [air-quality]: %v", cfg.Sources)
+	}
+	return sources, nil
+}
+
+// airQualityWatcher fetches the air quality for our location on its own
+// schedule, and again whenever we move
+func (w *WeatherBar) airQualityWatcher(ctx context.Context, moved <-chan struct{}) {
+	ticker := time.NewTicker(airQualityUpdateInterval)
+	defer ticker.Stop()
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	sources, err := newAirQualitySources(w.cfg.AirQuality, client)
+	if err != nil {
+		log.Fatalln("invalid air quality configuration:", err)
+	}
+
+	point, ok := w.waitForPoint(ctx)
+	if !ok {
+		return
+	}
+
+	for {
+		w.updateAirQuality(ctx, sources, point)
+
+		select {
+		case <-ticker.C:
+		case <-moved:
+		case <-ctx.Done():
+			log.Println("Termination request recieved.  Cancelling air quality watcher.")
+			return
+		}
+		point = w.currentSite().Point
+	}
+}
+
+// updateAirQuality fetches the air quality at the point and redraws the bar with
+// it.  Each value comes from the first source that has it.
+func (w *WeatherBar) updateAirQuality(ctx context.Context, sources []airQualitySource, point noaa.Point) {
+	var aq AirQuality
+	for _, source := range sources {
+		if *w.debug {
+			log.Printf("Fetching air quality for %.4f,%.4f from %v...\n", point.Latitude, point.Longitude, source.name())
+		}
+
+		s, err := source.fetchAirQuality(ctx, point)
+		if err != nil {
+			log.Printf("unable to fetch air quality from %v: %v\n", source.name(), err)
+			continue
+		}
+		aq = aq.fillFrom(s)
+	}
+
+	if *w.debug {
+		log.Printf("Current air quality: %+v\n", aq)
+	}
+
+	w.airQualityMutex.Lock()
+	w.airQuality = aq
+	w.airQualityMutex.Unlock()
+
+	w.redraw()
+}
+
+// currentAirQuality returns the latest air quality
+func (w *WeatherBar) currentAirQuality() AirQuality {
+	w.airQualityMutex.RLock()
+	defer w.airQualityMutex.RUnlock()
+	return w.airQuality
+}
+
+// fillFrom fills in the values that we don't have yet from another source
+func (aq AirQuality) fillFrom(s AirQuality) AirQuality {
+	if !aq.HasAQI && s.HasAQI {
+		aq.AQI, aq.HasAQI = s.AQI, true
+	}
+	if !aq.HasPM25 && s.HasPM25 {
+		aq.PM25, aq.HasPM25 = s.PM25, true
+	}
+	if !aq.HasOzone && s.HasOzone {
+		aq.Ozone, aq.HasOzone = s.Ozone, true
+	}
+	if !aq.HasUVIndex && s.HasUVIndex {
+		aq.UVIndex, aq.HasUVIndex = s.UVIndex, true
+	}
+	return aq
+}
+
+// Category returns the EPA's name for the AQI's category, e.g. "Moderate"
+func (aq AirQuality) Category() string {
+	if !aq.HasAQI {
+		return ""
+	}
+	for _, c := range aqiCategories {
+		if math.Round(aq.AQI) <= c.max {
+			return c.name
+		}
+	}
+	return ""
+}
+
+// Classes sets the classes for the AQI's category and the UV index's category
+func (aq AirQuality) Classes(classes map[string]bool) {
+	if aq.HasAQI {
+		for _, c := range aqiCategories {
+			if math.Round(aq.AQI) <= c.max {
+				classes[c.class] = true
+				break
+			}
+		}
+	}
+	if aq.HasUVIndex {
+		for _, c := range uvCategories {
+			if math.Round(aq.UVIndex) <= c.max {
+				classes[c.class] = true
+				break
+			}
+		}
+	}
+}
+
+// openMeteoAirQualitySource fetches the modelled air quality and UV index from
+// Open-Meteo, which covers the whole planet and needs no API key
+type openMeteoAirQualitySource struct {
+	baseURL string
+	client  *http.Client
+}
+
+// name identifies the source in log messages
+func (s *openMeteoAirQualitySource) name() string {
+	return "Open-Meteo"
+}
+
+// fetchAirQuality fetches the current air quality at the point
+func (s *openMeteoAirQualitySource) fetchAirQuality(ctx context.Context, point noaa.Point) (AirQuality, error) {
+	q := url.Values{}
+	q.Set("latitude", strconv.FormatFloat(point.Latitude, 'f', 4, 64))
+	q.Set("longitude", strconv.FormatFloat(point.Longitude, 'f', 4, 64))
+	q.Set("current", "us_aqi,pm2_5,ozone,uv_index")
+
+	var om OpenMeteoAirQuality
+	err := openMeteoGet(ctx, s.client, s.baseURL+"/v1/air-quality?"+q.Encode(), &om)
+	if err != nil {
+		return AirQuality{}, err
+	}
+
+	var aq AirQuality
+	cur := om.Current
+	if cur.USAQI != nil {
+		aq.AQI, aq.HasAQI = *cur.USAQI, true
+	}
+	if cur.PM25 != nil {
+		aq.PM25, aq.HasPM25 = *cur.PM25, true
+	}
+	if cur.Ozone != nil {
+		aq.Ozone, aq.HasOzone = *cur.Ozone*ozonePPBPerMicrogram, true
+	}
+	if cur.UVIndex != nil {
+		aq.UVIndex, aq.HasUVIndex = *cur.UVIndex, true
+	}
+	return aq, nil
+}
+
+// airNowSource fetches the air quality measured by the nearest monitors from the
+// EPA's AirNow API.  It covers the US, Canada and Mexico and needs an API key.
+type airNowSource struct {
+	baseURL  string
+	client   *http.Client
+	apiKey   string
+	distance int
+}
+
+// name identifies the source in log messages
+func (s *airNowSource) name() string {
+	return "AirNow"
+}
+
+// fetchAirQuality fetches the current observations near the point.  AirNow only
+// gives each pollutant's AQI, so we work the concentrations back out from them.
+func (s *airNowSource) fetchAirQuality(ctx context.Context, point noaa.Point) (AirQuality, error) {
+	q := url.Values{}
+	q.Set("format", "application/json")
+	q.Set("latitude", strconv.FormatFloat(point.Latitude, 'f', 4, 64))
+	q.Set("longitude", strconv.FormatFloat(point.Longitude, 'f', 4, 64))
+	q.Set("distance", strconv.Itoa(s.distance))
+	q.Set("API_KEY", s.apiKey)
+
+	req, err := http.NewRequest("GET", s.baseURL+"/aq/observation/latLong/current/?"+q.Encode(), nil)
+	if err != nil {
+		return AirQuality{}, err
+	}
+
+	r, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return AirQuality{}, err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return AirQuality{}, fmt.Errorf("AirNow API error: %v", r.Status)
+	}
+
+	var observations []AirNowObservation
+	err = json.NewDecoder(r.Body).Decode(&observations)
+	if err != nil {
+		return AirQuality{}, err
+	}
+	if len(observations) == 0 {
+		return AirQuality{}, fmt.Errorf("no monitors within %v miles", s.distance)
+	}
+
+	// The AQI is the worst of the pollutants' AQIs
+	var aq AirQuality
+	for _, o := range observations {
+		if o.AQI < 0 {
+			continue
+		}
+		if !aq.HasAQI || o.AQI > aq.AQI {
+			aq.AQI, aq.HasAQI = o.AQI, true
+		}
+		switch strings.ToUpper(o.ParameterName) {
+		case "PM2.5":
+			aq.PM25, aq.HasPM25 = aqiConcentration(o.AQI, pm25Breakpoints), true
+		case "O3", "OZONE":
+			aq.Ozone, aq.HasOzone = aqiConcentration(o.AQI, ozoneBreakpoints), true
+		}
+	}
+	return aq, nil
+}
+
+// aqiConcentration works out the pollutant concentration that gives an AQI,
+// undoing the EPA's linear interpolation between breakpoints
+func aqiConcentration(aqi float64, breakpoints []aqiBreakpoint) float64 {
+	for _, bp := range breakpoints {
+		if aqi <= bp.iHigh {
+			return bp.cLow + (aqi-bp.iLow)*(bp.cHigh-bp.cLow)/(bp.iHigh-bp.iLow)
+		}
+	}
+	return breakpoints[len(breakpoints)-1].cHigh
+}
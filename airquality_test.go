@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAirQualityCategory(t *testing.T) {
+	tests := []struct {
+		aqi  float64
+		want string
+	}{
+		{0, "Good"},
+		{50, "Good"},
+		// AQIs are whole numbers, so a fraction rounds into one category or the other
+		{50.4, "Good"},
+		{50.5, "Moderate"},
+		{51, "Moderate"},
+		{100, "Moderate"},
+		{101, "Unhealthy for Sensitive Groups"},
+		{150, "Unhealthy for Sensitive Groups"},
+		{151, "Unhealthy"},
+		{200, "Unhealthy"},
+		{201, "Very Unhealthy"},
+		{300, "Very Unhealthy"},
+		{301, "Hazardous"},
+		{500, "Hazardous"},
+		// Beyond the top of the scale
+		{650, "Hazardous"},
+	}
+
+	for _, tt := range tests {
+		aq := AirQuality{AQI: tt.aqi, HasAQI: true}
+		if got := aq.Category(); got != tt.want {
+			t.Errorf("AQI %v: category = %q, want %q", tt.aqi, got, tt.want)
+		}
+	}
+
+	if got := (AirQuality{}).Category(); got != "" {
+		t.Errorf("no AQI: category = %q, want blank", got)
+	}
+}
+
+func TestAQIConcentration(t *testing.T) {
+	tests := []struct {
+		name        string
+		aqi         float64
+		breakpoints []aqiBreakpoint
+		want        float64
+	}{
+		{"PM2.5", 0, pm25Breakpoints, 0},
+		{"PM2.5", 50, pm25Breakpoints, 9.0},
+		{"PM2.5", 51, pm25Breakpoints, 9.1},
+		{"PM2.5", 100, pm25Breakpoints, 35.4},
+		{"PM2.5", 101, pm25Breakpoints, 35.5},
+		{"PM2.5", 175, pm25Breakpoints, 89.74},
+		{"PM2.5", 500, pm25Breakpoints, 325.4},
+		// Beyond the top of the scale, the highest concentration is all we can say
+		{"PM2.5", 600, pm25Breakpoints, 325.4},
+		{"ozone", 50, ozoneBreakpoints, 54},
+		{"ozone", 75, ozoneBreakpoints, 62.35},
+		{"ozone", 100, ozoneBreakpoints, 70},
+		{"ozone", 101, ozoneBreakpoints, 71},
+	}
+
+	for _, tt := range tests {
+		if got := aqiConcentration(tt.aqi, tt.breakpoints); math.Abs(got-tt.want) > 0.01 {
+			t.Errorf("%v AQI %v: concentration = %v, want %v", tt.name, tt.aqi, got, tt.want)
+		}
+	}
+}
+
+func TestAirQualityFillFrom(t *testing.T) {
+	measured := AirQuality{AQI: 42, HasAQI: true, PM25: 8.2, HasPM25: true}
+	modelled := AirQuality{AQI: 61, HasAQI: true, PM25: 14, HasPM25: true, UVIndex: 0, HasUVIndex: true}
+
+	// Values that we already have stay, and the rest come from the other source,
+	// even when they're zero
+	got := measured.fillFrom(modelled)
+	want := AirQuality{AQI: 42, HasAQI: true, PM25: 8.2, HasPM25: true, UVIndex: 0, HasUVIndex: true}
+	if got != want {
+		t.Errorf("filled = %+v, want %+v", got, want)
+	}
+
+	if got := (AirQuality{}).fillFrom(AirQuality{}); got != (AirQuality{}) {
+		t.Errorf("filling from nothing = %+v, want nothing", got)
+	}
+	if got := modelled.fillFrom(AirQuality{}); got != modelled {
+		t.Errorf("filling from nothing = %+v, want %+v", got, modelled)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chrissnell/weather-bar/internal/noaa"
+)
+
+// alertTokens are the weather-format tokens filled in from active alerts.  We
+// only watch for alerts if the format uses one of them or an alert class.
+var alertTokens = []string{
+	"%alert-count%",
+	"%alert-headline%",
+	"%alert-severity%",
+	"%alert-event%",
+}
+
+// alertSeverities ranks the CAP severities, from least to most severe
+var alertSeverities = map[string]int{
+	"Unknown":  0,
+	"Minor":    1,
+	"Moderate": 2,
+	"Severe":   3,
+	"Extreme":  4,
+}
+
+// Classes set by active alerts, for use in conditional formats.  The most severe
+// alert also sets alert- followed by its severity, e.g. alert-severe.
+const classAlert = "alert"
+
+// Alert is an active watch, warning or advisory
+type Alert struct {
+	ID       string
+	Event    string
+	Headline string
+	Severity string
+	Sent     time.Time
+	Expires  time.Time
+
+	// References are the IDs of earlier alerts that this one updates or cancels
+	References []string
+
+	// cancel is true if this alert only cancels the ones that it references
+	cancel bool
+}
+
+// NWSAlertCollection encapsulates the API response for an /alerts/active request
+type NWSAlertCollection struct {
+	Features []struct {
+		Properties struct {
+			ID          string     `json:"id"`
+			Event       string     `json:"event"`
+			Headline    string     `json:"headline"`
+			Severity    string     `json:"severity"`
+			MessageType string     `json:"messageType"`
+			Sent        time.Time  `json:"sent"`
+			Expires     time.Time  `json:"expires"`
+			Ends        *time.Time `json:"ends"`
+			References  []struct {
+				Identifier string `json:"identifier"`
+			} `json:"references"`
+		} `json:"properties"`
+	} `json:"features"`
+}
+
+// usesAlerts returns true if the format shows alerts or tests an alert class
+func usesAlerts(format string) bool {
+	for _, token := range alertTokens {
+		if strings.Contains(format, token) {
+			return true
+		}
+	}
+	classes := []string{classAlert}
+	for severity := range alertSeverities {
+		classes = append(classes, alertSeverityClass(severity))
+	}
+	return usesClasses(format, classes...)
+}
+
+// alertSource is somewhere that we get alerts from
+type alertSource interface {
+	// name identifies the source in log messages
+	name() string
+
+	// fetchAlerts returns the active alerts for the point.  The point is zero if
+	// zones or geocodes were configured and we don't know where we are.
+	fetchAlerts(ctx context.Context, point noaa.Point) ([]Alert, error)
+}
+
+// newAlertSources builds the alert sources listed in the config file.  "nws" is
+// the NWS API, and anything else is the URL or path of a CAP or Atom feed.
+func newAlertSources(cfg AlertsConfig, client *http.Client, debug bool) ([]alertSource, error) {
+	feeds := cfg.Feeds
+	if strings.TrimSpace(feeds) == "" {
+		feeds = "nws"
+	}
+	zones := parseAlertZones(cfg.Zones)
+	geocodes := parseAlertZones(cfg.Geocodes)
+	language := cfg.Language
+	if language == "" {
+		language = defaultCAPLanguage
+	}
+
+	var sources []alertSource
+	for _, feed := range strings.Split(feeds, ",") {
+		feed = strings.TrimSpace(feed)
+		switch {
+		case feed == "":
+			continue
+		case strings.EqualFold(feed, "nws"):
+			sources = append(sources, &nwsAlertSource{client: client, zones: zones})
+		default:
+			sources = append(sources, newCAPAlertSource(feed, client, geocodes, language, debug))
+		}
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("invalid value for feeds in [alerts]: %v", cfg.Feeds)
+	}
+	return sources, nil
+}
+
+// alertsWatcher fetches the active alerts for our location or configured regions on
+// its own schedule, and again whenever we move or wake up
+func (w *WeatherBar) alertsWatcher(ctx context.Context, moved, woke <-chan struct{}) {
+	ticker := time.NewTicker(alertsUpdateInterval)
+	defer ticker.Stop()
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	sources, err := newAlertSources(w.cfg.Alerts, client, *w.debug)
+	if err != nil {
+		log.Fatalln("invalid alerts configuration:", err)
+	}
+
+	// With zones or geocodes configured, we don't have to know where we are
+	if len(parseAlertZones(w.cfg.Alerts.Zones)) == 0 && len(parseAlertZones(w.cfg.Alerts.Geocodes)) == 0 {
+		_, ok := w.waitForPoint(ctx)
+		if !ok {
+			return
+		}
+	}
+
+	// Each source's latest alerts, so that a source that fails doesn't make its
+	// alerts disappear
+	latest := make([][]Alert, len(sources))
+
+	for {
+		w.updateAlerts(ctx, sources, latest, w.currentSite().Point)
+
+		select {
+		case <-ticker.C:
+		case <-moved:
+		case <-woke:
+		case <-ctx.Done():
+			log.Println("Termination request recieved.  Cancelling alerts watcher.")
+			return
+		}
+	}
+}
+
+// updateAlerts fetches the active alerts from each source and redraws the bar with them
+func (w *WeatherBar) updateAlerts(ctx context.Context, sources []alertSource, latest [][]Alert, point noaa.Point) {
+	var all []Alert
+	for i, source := range sources {
+		if *w.debug {
+			log.Println("Fetching active alerts from", source.name())
+		}
+
+		alerts, err := source.fetchAlerts(ctx, point)
+		if err != nil {
+			log.Printf("unable to fetch alerts from %v: %v\n", source.name(), err)
+		} else {
+			latest[i] = alerts
+		}
+		all = append(all, latest[i]...)
+	}
+
+	alerts := dedupeAlerts(all)
+	if *w.debug {
+		log.Printf("Active alerts: %+v\n", alerts)
+	}
+
+	w.alertsMutex.Lock()
+	w.alerts = alerts
+	w.alertsMutex.Unlock()
+
+	w.redraw()
+}
+
+// nwsAlertSource fetches alerts from the NWS API
+type nwsAlertSource struct {
+	client *http.Client
+	zones  []string
+}
+
+// name identifies the source in log messages
+func (s *nwsAlertSource) name() string {
+	return "the NWS"
+}
+
+// fetchAlerts fetches the active alerts for the configured zones, or for the point
+func (s *nwsAlertSource) fetchAlerts(ctx context.Context, point noaa.Point) ([]Alert, error) {
+	q := url.Values{}
+	if len(s.zones) > 0 {
+		q.Set("zone", strings.Join(s.zones, ","))
+	} else {
+		q.Set("point", fmt.Sprintf("%.4f,%.4f", point.Latitude, point.Longitude))
+	}
+
+	var collection NWSAlertCollection
+	err := nwsGet(ctx, s.client, nwsAPIBaseURL+"/alerts/active?"+q.Encode(), &collection)
+	if err != nil {
+		return nil, err
+	}
+	return collection.toAlerts(), nil
+}
+
+// currentAlerts returns the alerts that haven't expired, most severe first
+func (w *WeatherBar) currentAlerts(now time.Time) []Alert {
+	w.alertsMutex.RLock()
+	defer w.alertsMutex.RUnlock()
+
+	var active []Alert
+	for _, a := range w.alerts {
+		if a.Expires.IsZero() || a.Expires.After(now) {
+			active = append(active, a)
+		}
+	}
+	return active
+}
+
+// parseAlertZones splits a comma-separated list of NWS zones or CAP geocodes
+func parseAlertZones(s string) []string {
+	var zones []string
+	for _, zone := range strings.Split(s, ",") {
+		zone = strings.ToUpper(strings.TrimSpace(zone))
+		if zone != "" {
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
+
+// toAlerts converts the NWS response into a list of alerts.  Cancellations are
+// kept so that they can cancel alerts from other sources.
+func (c NWSAlertCollection) toAlerts() []Alert {
+	var alerts []Alert
+	for _, f := range c.Features {
+		props := f.Properties
+		a := Alert{
+			ID:       props.ID,
+			Event:    props.Event,
+			Headline: props.Headline,
+			Severity: props.Severity,
+			Sent:     props.Sent,
+			Expires:  props.Expires,
+			cancel:   props.MessageType == "Cancel",
+		}
+		if props.Ends != nil {
+			a.Expires = *props.Ends
+		}
+		for _, r := range props.References {
+			a.References = append(a.References, r.Identifier)
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts
+}
+
+// dedupeAlerts drops repeats of the same alert, alerts that a later update
+// replaces or cancels and the cancellations themselves, then sorts the rest with
+// the most severe, most recent alert first
+func dedupeAlerts(alerts []Alert) []Alert {
+	replaced := make(map[string]bool)
+	for _, a := range alerts {
+		for _, id := range a.References {
+			replaced[id] = true
+		}
+	}
+
+	seen := make(map[string]bool)
+	var unique []Alert
+	for _, a := range alerts {
+		if seen[a.ID] || replaced[a.ID] || a.cancel {
+			continue
+		}
+		seen[a.ID] = true
+		unique = append(unique, a)
+	}
+
+	sort.SliceStable(unique, func(i, j int) bool {
+		si, sj := alertSeverities[unique[i].Severity], alertSeverities[unique[j].Severity]
+		if si != sj {
+			return si > sj
+		}
+		return unique[i].Sent.After(unique[j].Sent)
+	})
+	return unique
+}
+
+// alertSeverityClass returns the class set when the most severe alert has the
+// given severity
+func alertSeverityClass(severity string) string {
+	return classAlert + "-" + strings.ToLower(severity)
+}
+
+// alertClasses sets the alert classes for the active alerts
+func alertClasses(alerts []Alert, classes map[string]bool) {
+	if len(alerts) == 0 {
+		return
+	}
+	classes[classAlert] = true
+	if alerts[0].Severity != "" {
+		classes[alertSeverityClass(alerts[0].Severity)] = true
+	}
+}
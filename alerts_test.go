@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDedupeAlerts(t *testing.T) {
+	sent := time.Date(2024, 3, 14, 12, 0, 0, 0, time.UTC)
+	alert := func(id, severity string, minutes int, references ...string) Alert {
+		return Alert{ID: id, Severity: severity, Sent: sent.Add(time.Duration(minutes) * time.Minute), References: references}
+	}
+	cancel := func(id string, references ...string) Alert {
+		a := alert(id, "Minor", 30, references...)
+		a.cancel = true
+		return a
+	}
+
+	tests := []struct {
+		name   string
+		alerts []Alert
+		want   []string
+	}{
+		{"none", nil, nil},
+		{
+			// The same alert from two feeds, or in two languages
+			name:   "repeats",
+			alerts: []Alert{alert("a", "Moderate", 0), alert("b", "Minor", 0), alert("a", "Moderate", 0)},
+			want:   []string{"a", "b"},
+		},
+		{
+			name:   "update",
+			alerts: []Alert{alert("a", "Moderate", 0), alert("a2", "Severe", 20, "a")},
+			want:   []string{"a2"},
+		},
+		{
+			// An update that arrives before the alert that it replaces
+			name:   "update first",
+			alerts: []Alert{alert("a2", "Moderate", 20, "a"), alert("a", "Moderate", 0)},
+			want:   []string{"a2"},
+		},
+		{
+			name:   "update of an update",
+			alerts: []Alert{alert("a", "Moderate", 0), alert("a2", "Moderate", 10, "a"), alert("a3", "Moderate", 20, "a", "a2")},
+			want:   []string{"a3"},
+		},
+		{
+			name:   "cancellation",
+			alerts: []Alert{alert("a", "Severe", 0), alert("b", "Minor", 0), cancel("c", "a")},
+			want:   []string{"b"},
+		},
+		{
+			name:   "everything cancelled",
+			alerts: []Alert{alert("a", "Severe", 0), cancel("c", "a")},
+			want:   nil,
+		},
+		{
+			name: "severity, then newest",
+			alerts: []Alert{
+				alert("minor", "Minor", 0),
+				alert("severe-old", "Severe", 0),
+				alert("unknown", "", 5),
+				alert("extreme", "Extreme", 0),
+				alert("severe-new", "Severe", 10),
+			},
+			want: []string{"extreme", "severe-new", "severe-old", "minor", "unknown"},
+		},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, a := range dedupeAlerts(tt.alerts) {
+			got = append(got, a.ID)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: alerts = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chrissnell/weather-bar/internal/noaa"
+)
+
+// The APRS-IS rotation sends us to a nearby tier 2 server.  Port 14580 accepts
+// user-defined filters.
+const defaultAPRSServer = "rotate.aprs2.net:14580"
+
+// Without a station to follow, we listen for weather reports within this many
+// kilometers of our location
+const defaultAPRSRadius = 25
+
+// CWOP stations report every 5 to 15 minutes, so a report older than this means
+// that the station has gone quiet
+const aprsMaxReportAge = 30 * time.Minute
+
+// APRS-IS servers send a keepalive comment every 20 seconds.  If we hear nothing
+// for this long, the connection is dead.
+const aprsReadTimeout = 2 * time.Minute
+
+func init() {
+	registerProvider("aprs", newAPRSProvider)
+}
+
+// APRSProvider listens to an APRS-IS server for the weather reports that amateur
+// radio and CWOP stations send.  It follows one station if a callsign is given as
+// the station, and otherwise uses the closest station that's reporting.
+type APRSProvider struct {
+	server   string
+	login    string
+	passcode string
+	callsign string
+	radius   float64
+	debug    bool
+
+	connMutex sync.Mutex
+	filter    string
+	cancel    context.CancelFunc
+
+	reportsMutex sync.Mutex
+	reports      map[string]aprsReport
+	positions    map[string]noaa.Point
+	center       noaa.Point
+}
+
+// aprsReport is the latest weather report that we've heard from a station
+type aprsReport struct {
+	wx       APRSWeather
+	received time.Time
+}
+
+func newAPRSProvider(w *WeatherBar) (WeatherProvider, error) {
+	cfg := w.cfg.Weather
+	p := &APRSProvider{
+		server:    cfg.APRSServer,
+		login:     cfg.APRSLogin,
+		passcode:  cfg.APRSPasscode,
+		callsign:  strings.ToUpper(cfg.Station),
+		radius:    defaultAPRSRadius,
+		debug:     *w.debug,
+		reports:   make(map[string]aprsReport),
+		positions: make(map[string]noaa.Point),
+	}
+	if p.server == "" {
+		p.server = defaultAPRSServer
+	}
+	if p.login == "" {
+		p.login = "N0CALL"
+	}
+	if p.passcode == "" {
+		// A passcode of -1 logs us in read-only, which is all we need
+		p.passcode = "-1"
+	}
+
+	if cfg.APRSRadius != "" {
+		var err error
+		p.radius, err = strconv.ParseFloat(cfg.APRSRadius, 64)
+		if err != nil || p.radius <= 0 {
+			return nil, fmt.Errorf("invalid value for aprs-radius: %v", cfg.APRSRadius)
+		}
+	}
+
+	return p, nil
+}
+
+// Name returns the name of this provider
+func (p *APRSProvider) Name() string {
+	return "aprs"
+}
+
+// UpdateInterval returns how often we check for a new report.  Reports arrive in
+// the background, so checking often costs nothing.
+func (p *APRSProvider) UpdateInterval() time.Duration {
+	return aprsUpdateInterval
+}
+
+// UsesPoint returns true unless we're following a particular station.  Otherwise,
+// we listen for stations around our location.
+func (p *APRSProvider) UsesPoint() bool {
+	return p.callsign == ""
+}
+
+// SupportedFields returns the fields found in APRS weather reports
+func (p *APRSProvider) SupportedFields() []ObservationField {
+	return []ObservationField{
+		FieldStationID,
+		FieldTemperature,
+		FieldHumidity,
+		FieldWindDir,
+		FieldWindSpeed,
+		FieldWindGust,
+		FieldBarometer,
+		FieldRainToday,
+		FieldRain1Hour,
+	}
+}
+
+// FetchObservation returns the best weather report that we've heard for the site.
+// The first call connects to APRS-IS, staying connected until its ctx is
+// cancelled, and we reconnect with a new filter if the site moves.
+func (p *APRSProvider) FetchObservation(ctx context.Context, site WeatherSite) (CurrentObservation, error) {
+	p.listen(ctx, site)
+
+	p.reportsMutex.Lock()
+	defer p.reportsMutex.Unlock()
+
+	r, ok := p.bestReport(time.Now())
+	if !ok {
+		if p.callsign != "" {
+			return CurrentObservation{}, fmt.Errorf("no recent APRS weather reports from %v", p.callsign)
+		}
+		return CurrentObservation{}, fmt.Errorf("no recent APRS weather reports within %v km", p.radius)
+	}
+	obs := r.wx.toCurrentObservation()
+	obs.ObservedAt = r.received
+	return obs, nil
+}
+
+// listen makes sure that we're connected to APRS-IS with the right filter for the
+// site.  The connection lasts until ctx is cancelled.
+func (p *APRSProvider) listen(ctx context.Context, site WeatherSite) {
+	filter := "b/" + p.callsign
+	if p.callsign == "" {
+		filter = fmt.Sprintf("r/%.3f/%.3f/%v", site.Point.Latitude, site.Point.Longitude, p.radius)
+	}
+
+	p.connMutex.Lock()
+	defer p.connMutex.Unlock()
+
+	if filter == p.filter {
+		return
+	}
+	if p.cancel != nil {
+		p.cancel()
+	}
+
+	// Reports from around our old location are no use to us now
+	p.reportsMutex.Lock()
+	p.reports = make(map[string]aprsReport)
+	p.center = site.Point
+	p.reportsMutex.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	p.filter = filter
+	p.cancel = cancel
+	go p.run(ctx, filter)
+}
+
+// run stays connected to APRS-IS until ctx is cancelled, reconnecting as needed
+func (p *APRSProvider) run(ctx context.Context, filter string) {
+	delay := minReconnectDelay
+
+	for {
+		received, err := p.session(ctx, filter)
+		if ctx.Err() != nil {
+			return
+		}
+		if received {
+			delay = minReconnectDelay
+		}
+
+		log.Printf("APRS-IS connection to %v failed: %v (retrying in %v)\n", p.server, err, delay)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// session logs in to the server and records weather reports until the connection
+// fails.  It reports whether the server sent us anything.
+func (p *APRSProvider) session(ctx context.Context, filter string) (bool, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", p.server)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	_, err = fmt.Fprintf(conn, "user %v pass %v vers weather-bar 1.0 filter %v\r\n", p.login, p.passcode, filter)
+	if err != nil {
+		return false, err
+	}
+
+	if p.debug {
+		log.Println("Listening to", p.server, "for APRS weather reports with filter", filter)
+	}
+
+	received := false
+	scanner := bufio.NewScanner(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(aprsReadTimeout))
+		if !scanner.Scan() {
+			err = scanner.Err()
+			if err == nil {
+				err = fmt.Errorf("server closed the connection")
+			}
+			return received, err
+		}
+		received = true
+
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.HasPrefix(line, "#") {
+			// Server comments, including our login response and keepalives
+			if p.debug && strings.Contains(line, "logresp") {
+				log.Println("APRS-IS:", line)
+			}
+			continue
+		}
+
+		wx, ok, err := decodeAPRSWeather(line)
+		if err != nil {
+			if p.debug {
+				log.Println(err)
+			}
+			continue
+		}
+		if ok {
+			p.record(wx, time.Now())
+		}
+	}
+}
+
+// record saves a weather report
+func (p *APRSProvider) record(wx APRSWeather, now time.Time) {
+	if p.debug {
+		log.Printf("APRS weather report from %v: %+v\n", wx.Callsign, wx.toCurrentObservation())
+	}
+
+	p.reportsMutex.Lock()
+	defer p.reportsMutex.Unlock()
+
+	if wx.HasPosition {
+		p.positions[wx.Callsign] = wx.Position
+	}
+	p.reports[wx.Callsign] = aprsReport{wx: wx, received: now}
+}
+
+// bestReport picks the report to show: the station we're following, or else the
+// closest station that has reported recently.  The caller must hold reportsMutex.
+func (p *APRSProvider) bestReport(now time.Time) (aprsReport, bool) {
+	var best aprsReport
+	bestDistance := math.Inf(1)
+	found := false
+
+	for callsign, r := range p.reports {
+		if now.Sub(r.received) > aprsMaxReportAge {
+			delete(p.reports, callsign)
+			continue
+		}
+
+		if p.callsign != "" {
+			if strings.EqualFold(callsign, p.callsign) {
+				return r, true
+			}
+			continue
+		}
+
+		// Stations that we have no position for, because they only send
+		// positionless reports, are our last resort
+		distance := math.MaxFloat64
+		if pos, ok := p.positions[callsign]; ok {
+			distance = p.center.HaversineDistance(&pos)
+		}
+		if !found || distance < bestDistance || (distance == bestDistance && r.received.After(best.received)) {
+			best = r
+			bestDistance = distance
+			found = true
+		}
+	}
+
+	return best, found
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/chrissnell/weather-bar/internal/noaa"
+)
+
+// APRSWeather is a weather report decoded from an APRS packet.  The APRS weather
+// format uses US units throughout, which happen to be ours too.
+type APRSWeather struct {
+	Callsign    string
+	Position    noaa.Point
+	HasPosition bool
+
+	WindDir, WindSpeed, WindGust       *float64
+	Temperature, Humidity, Barometer   *float64
+	Rain1Hour, Rain24Hours, RainToday  *float64
+	Luminosity, Snow24Hours, RainCount *float64
+}
+
+// aprsWeatherFields gives the length of each weather element, which is a single
+// letter followed by a fixed number of digits
+var aprsWeatherFields = map[byte]int{
+	'c': 3, // wind direction, degrees
+	's': 3, // sustained wind speed, mph (or snowfall in inches, after the wind)
+	'g': 3, // gust, mph
+	't': 3, // temperature, °F
+	'r': 3, // rain in the last hour, hundredths of an inch
+	'p': 3, // rain in the last 24 hours, hundredths of an inch
+	'P': 3, // rain since midnight, hundredths of an inch
+	'h': 2, // humidity, %, with 00 meaning 100
+	'b': 5, // barometric pressure, tenths of a millibar
+	'L': 3, // luminosity below 1000 W/m²
+	'l': 3, // luminosity of 1000 W/m² and above, minus 1000
+	'#': 3, // raw rain counter
+}
+
+// decodeAPRSWeather decodes a line received from APRS-IS.  It returns false if the
+// line isn't a weather report.
+func decodeAPRSWeather(line string) (APRSWeather, bool, error) {
+	header := strings.SplitN(line, ":", 2)
+	if len(header) != 2 || !strings.Contains(header[0], ">") {
+		return APRSWeather{}, false, fmt.Errorf("malformed APRS packet: %q", line)
+	}
+
+	wx := APRSWeather{Callsign: header[0][:strings.Index(header[0], ">")]}
+	info := header[1]
+	if info == "" {
+		return APRSWeather{}, false, nil
+	}
+
+	switch info[0] {
+	case '}':
+		// A third-party packet carries another packet, with its own header, inside it
+		return decodeAPRSWeather(info[1:])
+
+	case '_':
+		// Positionless weather report, with an MMDDHHMM timestamp
+		if len(info) < 9 {
+			return APRSWeather{}, false, fmt.Errorf("truncated APRS weather report from %v", wx.Callsign)
+		}
+		err := wx.decodeWeather(info[9:])
+		return wx, err == nil, err
+
+	case '!', '=', '/', '@':
+		data := info[1:]
+		if info[0] == '/' || info[0] == '@' {
+			// Skip the DDHHMMz or HHMMSSh timestamp
+			if len(data) < 7 {
+				return APRSWeather{}, false, nil
+			}
+			data = data[7:]
+		}
+
+		rest, symbol, err := wx.decodePosition(data)
+		if err != nil {
+			return APRSWeather{}, false, err
+		}
+
+		// Position reports only carry weather when they use the weather station symbol
+		if symbol != '_' {
+			return APRSWeather{}, false, nil
+		}
+
+		err = wx.decodeWeather(rest)
+		return wx, err == nil, err
+	}
+
+	return APRSWeather{}, false, nil
+}
+
+// decodePosition decodes an uncompressed or compressed position and returns the
+// rest of the packet and the symbol code
+func (wx *APRSWeather) decodePosition(data string) (string, byte, error) {
+	if len(data) >= 19 && data[0] >= '0' && data[0] <= '9' {
+		// Uncompressed: DDMM.mmN/DDDMM.mmW_ where / is the symbol table and _ the symbol
+		lat, err := parseAPRSCoordinate(data[0:8], 2, 'N', 'S')
+		if err != nil {
+			return "", 0, err
+		}
+		lon, err := parseAPRSCoordinate(data[9:18], 3, 'E', 'W')
+		if err != nil {
+			return "", 0, err
+		}
+		wx.Position = noaa.Point{Latitude: lat, Longitude: lon}
+		wx.HasPosition = true
+		return data[19:], data[18], nil
+	}
+
+	if len(data) >= 13 {
+		// Compressed: a symbol table, base-91 latitude and longitude, the symbol
+		// code and then two bytes of course/speed and one of compression type
+		y := base91(data[1:5])
+		x := base91(data[5:9])
+		wx.Position = noaa.Point{
+			Latitude:  90 - float64(y)/380926,
+			Longitude: -180 + float64(x)/190463,
+		}
+		wx.HasPosition = true
+
+		// For a weather station, the course and speed are the wind, unless the
+		// compression type says that those bytes hold an altitude or a range
+		cs := data[10:12]
+		altitude := (data[12]-33)&0x18 == 0x10
+		if cs[0] >= '!' && cs[0] <= 'z' && cs[0] != '{' && !altitude {
+			dir := float64(cs[0]-33) * 4
+			speed := roundTenth((math.Pow(1.08, float64(cs[1]-33)) - 1) * mphPerKnot)
+			wx.WindDir = &dir
+			wx.WindSpeed = &speed
+		}
+		return data[13:], data[9], nil
+	}
+
+	return "", 0, fmt.Errorf("truncated APRS position from %v", wx.Callsign)
+}
+
+// decodeWeather decodes the weather elements that follow the position or
+// timestamp.  The text after the last element is the station's software and
+// equipment, which we ignore.
+func (wx *APRSWeather) decodeWeather(data string) error {
+	// Position reports give the wind as ddd/sss, like a course and speed
+	if len(data) >= 7 && data[3] == '/' {
+		wx.WindDir = parseAPRSValue(data[0:3])
+		wx.WindSpeed = parseAPRSValue(data[4:7])
+		data = data[7:]
+	}
+
+	found := wx.WindDir != nil || wx.WindSpeed != nil
+	for len(data) > 0 {
+		length, ok := aprsWeatherFields[data[0]]
+		if !ok || len(data) < 1+length {
+			break
+		}
+		key := data[0]
+		v := parseAPRSValue(data[1 : 1+length])
+		data = data[1+length:]
+
+		switch key {
+		case 'c':
+			wx.WindDir = v
+		case 's':
+			if wx.WindSpeed == nil {
+				wx.WindSpeed = v
+			} else {
+				wx.Snow24Hours = v
+			}
+		case 'g':
+			wx.WindGust = v
+		case 't':
+			wx.Temperature = v
+		case 'r':
+			wx.Rain1Hour = hundredths(v)
+		case 'p':
+			wx.Rain24Hours = hundredths(v)
+		case 'P':
+			wx.RainToday = hundredths(v)
+		case 'h':
+			if v != nil && *v == 0 {
+				*v = 100
+			}
+			wx.Humidity = v
+		case 'b':
+			if v != nil {
+				*v /= 10
+			}
+			wx.Barometer = v
+		case 'L':
+			wx.Luminosity = v
+		case 'l':
+			if v != nil {
+				*v += 1000
+			}
+			wx.Luminosity = v
+		case '#':
+			wx.RainCount = v
+		}
+		found = true
+	}
+
+	if !found {
+		return fmt.Errorf("APRS weather report from %v has no weather in it", wx.Callsign)
+	}
+	return nil
+}
+
+// toCurrentObservation maps an APRS weather report into a CurrentObservation
+func (wx APRSWeather) toCurrentObservation() CurrentObservation {
+	var obs CurrentObservation
+	obs.setText(FieldStationID, wx.Callsign)
+
+	obs.setIfPresent(FieldWindDir, wx.WindDir)
+	obs.setIfPresent(FieldWindSpeed, wx.WindSpeed)
+	obs.setIfPresent(FieldWindGust, wx.WindGust)
+	obs.setIfPresent(FieldTemperature, wx.Temperature)
+	obs.setIfPresent(FieldHumidity, wx.Humidity)
+	obs.setIfPresent(FieldBarometer, wx.Barometer)
+	obs.setIfPresent(FieldRain1Hour, wx.Rain1Hour)
+	obs.setIfPresent(FieldRainToday, wx.RainToday)
+
+	return obs
+}
+
+// parseAPRSValue parses a weather element.  Stations fill elements that they
+// can't measure with dots or spaces.
+func parseAPRSValue(s string) *float64 {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return nil
+	}
+	f := float64(v)
+	return &f
+}
+
+// parseAPRSCoordinate parses a latitude (DDMM.mm) or longitude (DDDMM.mm) followed
+// by its hemisphere.  Stations that hide their exact position replace the last
+// digits with spaces.
+func parseAPRSCoordinate(s string, degreeDigits int, positive, negative byte) (float64, error) {
+	hemisphere := s[len(s)-1]
+	s = strings.Replace(s[:len(s)-1], " ", "0", -1)
+
+	deg, err := strconv.Atoi(s[:degreeDigits])
+	if err != nil {
+		return 0, fmt.Errorf("invalid APRS coordinate: %q", s)
+	}
+	min, err := strconv.ParseFloat(s[degreeDigits:], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid APRS coordinate: %q", s)
+	}
+
+	v := float64(deg) + min/60
+	switch hemisphere {
+	case positive:
+		return v, nil
+	case negative:
+		return -v, nil
+	}
+	return 0, fmt.Errorf("invalid APRS hemisphere: %q", hemisphere)
+}
+
+// base91 decodes the base-91 numbers used in compressed positions
+func base91(s string) int {
+	v := 0
+	for i := 0; i < len(s); i++ {
+		v = v*91 + int(s[i]) - 33
+	}
+	return v
+}
+
+// hundredths converts rainfall in hundredths of an inch into inches
+func hundredths(v *float64) *float64 {
+	if v == nil {
+		return nil
+	}
+	inches := *v / 100
+	return &inches
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// aprsValues lists the weather elements that a report has
+func aprsValues(wx APRSWeather) map[string]float64 {
+	values := make(map[string]float64)
+	for name, v := range map[string]*float64{
+		"wind-dir":   wx.WindDir,
+		"wind-speed": wx.WindSpeed,
+		"wind-gust":  wx.WindGust,
+		"temp":       wx.Temperature,
+		"humidity":   wx.Humidity,
+		"barometer":  wx.Barometer,
+		"rain-1h":    wx.Rain1Hour,
+		"rain-24h":   wx.Rain24Hours,
+		"rain-today": wx.RainToday,
+		"luminosity": wx.Luminosity,
+		"snow-24h":   wx.Snow24Hours,
+		"rain-count": wx.RainCount,
+	} {
+		if v != nil {
+			values[name] = math.Round(*v*1000) / 1000
+		}
+	}
+	return values
+}
+
+func TestDecodeAPRSWeather(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		callsign string
+		lat, lon float64
+		values   map[string]float64
+		ok       bool
+		err      bool
+	}{
+		{
+			name:     "positionless",
+			line:     "CW1234>APRS,TCPXX*,qAX,CWOP-2:_10090556c220s004g005t077r000p000P000h50b09900wRSW",
+			callsign: "CW1234",
+			values: map[string]float64{"wind-dir": 220, "wind-speed": 4, "wind-gust": 5, "temp": 77,
+				"rain-1h": 0, "rain-24h": 0, "rain-today": 0, "humidity": 50, "barometer": 990},
+			ok: true,
+		},
+		{
+			name:     "uncompressed with timestamp",
+			line:     "FW0690>APRS,TCPXX*,qAX,CWOP-5:@141853z3911.01N/09634.30W_220/004g012t-05r001p010P002h00b10132l012.DsVP",
+			callsign: "FW0690",
+			lat:      39.18350,
+			lon:      -96.57167,
+			values: map[string]float64{"wind-dir": 220, "wind-speed": 4, "wind-gust": 12, "temp": -5,
+				"rain-1h": 0.01, "rain-24h": 0.1, "rain-today": 0.02, "humidity": 100, "barometer": 1013.2, "luminosity": 1012},
+			ok: true,
+		},
+		{
+			name:     "uncompressed without timestamp, missing sensors and hidden position",
+			line:     "EW9876>APRS,TCPXX*,qAX,CWOP-1:!3911.  N/09634.  W_.../...g...t068r...p...P...h..b.....#123s002",
+			callsign: "EW9876",
+			lat:      39.18333,
+			lon:      -96.56667,
+			values:   map[string]float64{"temp": 68, "rain-count": 123, "wind-speed": 2},
+			ok:       true,
+		},
+		{
+			name:     "compressed",
+			line:     "K0ABC-13>APRS,WIDE2-1,qAR,K0XYZ:=/5L!!<*e7_7P[g050t077r000p000P000h50b09900wRSW",
+			callsign: "K0ABC-13",
+			lat:      49.5,
+			lon:      -72.75,
+			values: map[string]float64{"wind-dir": 88, "wind-speed": 41.7, "wind-gust": 50, "temp": 77,
+				"rain-1h": 0, "rain-24h": 0, "rain-today": 0, "humidity": 50, "barometer": 990},
+			ok: true,
+		},
+		{
+			name:     "compressed with an altitude instead of wind",
+			line:     "K0ABC-13>APRS,qAR,K0XYZ:!/5L!!<*e7_S]Sc050t077",
+			callsign: "K0ABC-13",
+			lat:      49.5,
+			lon:      -72.75,
+			values:   map[string]float64{"wind-dir": 50, "temp": 77},
+			ok:       true,
+		},
+		{
+			name:     "third party",
+			line:     "KB0ABC>APRS,TCPIP*,qAC,T2TEST:}CW1234>APRS,TCPXX*,qAX,CWOP-2:_10090556c220s004g005t077",
+			callsign: "CW1234",
+			values:   map[string]float64{"wind-dir": 220, "wind-speed": 4, "wind-gust": 5, "temp": 77},
+			ok:       true,
+		},
+		{
+			name: "position without the weather symbol",
+			line: "N0CALL-9>APRS,WIDE1-1,qAR,K0XYZ:!3911.01N/09634.30W>090/035/A=001234",
+		},
+		{
+			name: "message",
+			line: "N0CALL>APRS,TCPIP*,qAC,T2TEST::KB0ABC   :hello{1",
+		},
+		{
+			name: "no header",
+			line: "this isn't a packet",
+			err:  true,
+		},
+		{
+			name: "truncated positionless report",
+			line: "CW1234>APRS,TCPXX*:_1009",
+			err:  true,
+		},
+		{
+			name: "weather station symbol with no weather",
+			line: "CW1234>APRS,TCPXX*:!3911.01N/09634.30W_Davis Vantage Pro",
+			err:  true,
+		},
+		{
+			name: "bad hemisphere",
+			line: "CW1234>APRS,TCPXX*:!3911.01X/09634.30W_220/004t077",
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		wx, ok, err := decodeAPRSWeather(tt.line)
+		if (err != nil) != tt.err {
+			t.Errorf("%v: error = %v, want error %v", tt.name, err, tt.err)
+			continue
+		}
+		if ok != tt.ok {
+			t.Errorf("%v: decoded weather = %v, want %v", tt.name, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		if wx.Callsign != tt.callsign {
+			t.Errorf("%v: callsign = %q, want %q", tt.name, wx.Callsign, tt.callsign)
+		}
+		hasPosition := tt.lat != 0 || tt.lon != 0
+		if wx.HasPosition != hasPosition || math.Abs(wx.Position.Latitude-tt.lat) > 0.00001 || math.Abs(wx.Position.Longitude-tt.lon) > 0.00001 {
+			t.Errorf("%v: position = %v, %v (decoded %v), want %v, %v", tt.name,
+				wx.Position.Latitude, wx.Position.Longitude, wx.HasPosition, tt.lat, tt.lon)
+		}
+		if got := aprsValues(wx); !reflect.DeepEqual(got, tt.values) {
+			t.Errorf("%v: weather = %v, want %v", tt.name, got, tt.values)
+		}
+	}
+}
+
+func TestAPRSToCurrentObservation(t *testing.T) {
+	wx, _, err := decodeAPRSWeather("CW1234>APRS,TCPXX*,qAX,CWOP-2:_10090556c220s...g...t032r...p...P000h..b09900")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obs := wx.toCurrentObservation()
+
+	for f, v := range map[ObservationField]float64{FieldWindDir: 220, FieldTemperature: 32, FieldRainToday: 0, FieldBarometer: 990} {
+		if got := *numericFields[f](&obs); !obs.Has(f) || got != v {
+			t.Errorf("%v = %v (filled %v), want %v", f, got, obs.Has(f), v)
+		}
+	}
+	for _, f := range []ObservationField{FieldWindSpeed, FieldWindGust, FieldHumidity, FieldRain1Hour} {
+		if obs.Has(f) {
+			t.Errorf("%v was filled in from a sensor the station doesn't have", f)
+		}
+	}
+}
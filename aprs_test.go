@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/chrissnell/weather-bar/internal/noaa"
+)
+
+func TestAPRSSession(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The stand-in server sends each connection a login response, a keepalive, a
+	// packet that isn't weather and a weather report, and passes on the login line
+	// that it received
+	logins := make(chan string, 2)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				fmt.Fprint(conn, "# aprsc 2.1.14-g5e22b37\r\n")
+				login, err := bufio.NewReader(conn).ReadString('\n')
+				if err != nil {
+					return
+				}
+				logins <- login
+				fmt.Fprint(conn, "# logresp N0CALL unverified, server T2TEST\r\n")
+				fmt.Fprint(conn, "# aprsc 2.1.14-g5e22b37 14 Mar 2024 18:53:00 GMT T2TEST 127.0.0.1:14580\r\n")
+				fmt.Fprint(conn, "N0CALL-9>APRS,WIDE1-1,qAR,K0XYZ:!3911.01N/09634.30W>090/035\r\n")
+				fmt.Fprint(conn, "FW0690>APRS,TCPXX*,qAX,CWOP-5:@141853z3911.01N/09634.30W_220/004g012t077h50b10132\r\n")
+
+				// Hold the connection open until the client hangs up
+				conn.Read(make([]byte, 1))
+			}(conn)
+		}
+	}()
+
+	p := &APRSProvider{
+		server:    ln.Addr().String(),
+		login:     "N0CALL",
+		passcode:  "-1",
+		radius:    defaultAPRSRadius,
+		reports:   make(map[string]aprsReport),
+		positions: make(map[string]noaa.Point),
+	}
+	defer func() {
+		p.connMutex.Lock()
+		p.cancel()
+		p.connMutex.Unlock()
+	}()
+
+	// fetch waits for the report to arrive after connecting
+	fetch := func(site WeatherSite) CurrentObservation {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			obs, err := p.FetchObservation(context.Background(), site)
+			if err == nil {
+				return obs
+			}
+			if time.Now().After(deadline) {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	login := func() string {
+		select {
+		case l := <-logins:
+			return l
+		case <-time.After(5 * time.Second):
+			t.Fatal("no login received")
+			return ""
+		}
+	}
+
+	site := WeatherSite{Point: noaa.Point{Latitude: 39.18361, Longitude: -96.57167}}
+	obs := fetch(site)
+	if got, want := login(), "user N0CALL pass -1 vers weather-bar 1.0 filter r/39.184/-96.572/25\r\n"; got != want {
+		t.Errorf("login = %q, want %q", got, want)
+	}
+	if obs.StationID != "FW0690" || obs.Temperature != 77 || obs.WindGust != 12 || obs.Barometer != 1013.2 {
+		t.Errorf("station, temperature, gust, barometer = %v, %v, %v, %v, want FW0690, 77, 12, 1013.2",
+			obs.StationID, obs.Temperature, obs.WindGust, obs.Barometer)
+	}
+
+	// Moving reconnects with a filter for the new location
+	site.Point = noaa.Point{Latitude: 39.05, Longitude: -95.68}
+	fetch(site)
+	if got, want := login(), "user N0CALL pass -1 vers weather-bar 1.0 filter r/39.050/-95.680/25\r\n"; got != want {
+		t.Errorf("login after moving = %q, want %q", got, want)
+	}
+}
+
+func TestAPRSBestReport(t *testing.T) {
+	now := time.Now()
+	p := &APRSProvider{
+		center:    noaa.Point{Latitude: 39.18, Longitude: -96.57},
+		reports:   make(map[string]aprsReport),
+		positions: make(map[string]noaa.Point),
+	}
+
+	report := func(callsign string, age time.Duration, pos *noaa.Point) {
+		wx := APRSWeather{Callsign: callsign}
+		if pos != nil {
+			wx.Position = *pos
+			wx.HasPosition = true
+		}
+		p.record(wx, now.Add(-age))
+	}
+	report("FAR", time.Minute, &noaa.Point{Latitude: 39.05, Longitude: -95.68})
+	report("NEAR", 2*time.Minute, &noaa.Point{Latitude: 39.19, Longitude: -96.58})
+	report("NOWHERE", 0, nil)
+	report("STALE", aprsMaxReportAge+time.Minute, &noaa.Point{Latitude: 39.18, Longitude: -96.57})
+
+	if r, ok := p.bestReport(now); !ok || r.wx.Callsign != "NEAR" {
+		t.Errorf("best report is from %v, want NEAR", r.wx.Callsign)
+	}
+	if _, ok := p.reports["STALE"]; ok {
+		t.Error("stale report was kept")
+	}
+
+	// A station that we follow wins no matter how far away it is
+	p.callsign = "far"
+	if r, ok := p.bestReport(now); !ok || r.wx.Callsign != "FAR" {
+		t.Errorf("best report is from %v, want FAR", r.wx.Callsign)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/chrissnell/weather-bar/internal/noaa"
+)
+
+// astronomyTokens are the weather-format tokens filled in from the sun and moon.
+// They're computed locally, but we only bother if the format uses one of them.
+var astronomyTokens = []string{
+	"%sunrise%",
+	"%sunset%",
+	"%daylight-remaining%",
+	"%civil-dusk%",
+	"%golden-hour%",
+	"%moon-phase%",
+	"%moon-illumination%",
+}
+
+// Times are shown like this unless time-format is set in the config file
+const defaultTimeFormat = "15:04"
+
+// The altitudes of the sun's center, in degrees, at the events we show.  Sunrise
+// and sunset allow for refraction and the size of the sun's disk.
+const (
+	sunriseAltitude    = -0.833
+	civilDuskAltitude  = -6
+	goldenHourAltitude = 6
+)
+
+// The Julian date of the J2000.0 epoch
+const julianDateJ2000 = 2451545.0
+
+// The moon's phases, starting from new moon, each with its glyph
+var moonPhases = []struct {
+	name  string
+	glyph string
+}{
+	{"New Moon", "🌑"},
+	{"Waxing Crescent", "🌒"},
+	{"First Quarter", "🌓"},
+	{"Waxing Gibbous", "🌔"},
+	{"Full Moon", "🌕"},
+	{"Waning Gibbous", "🌖"},
+	{"Last Quarter", "🌗"},
+	{"Waning Crescent", "🌘"},
+}
+
+// SunTimes holds the times of the sun's events for a day.  A time is zero if the
+// sun doesn't get that high or low that day, as happens near the poles.
+type SunTimes struct {
+	Sunrise    time.Time
+	Sunset     time.Time
+	CivilDusk  time.Time
+	GoldenHour time.Time
+}
+
+// MoonPhase describes the moon at a moment
+type MoonPhase struct {
+	Name         string
+	Glyph        string
+	Illumination float64
+}
+
+// usesAstronomy returns true if the format has any sun or moon tokens in it
+func usesAstronomy(format string) bool {
+	for _, token := range astronomyTokens {
+		if strings.Contains(format, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// astronomyWatcher works out the sun's times for today at our location.  It does
+// so again at midnight and whenever we move, and redraws the bar every minute so
+// that the daylight remaining keeps up.
+func (w *WeatherBar) astronomyWatcher(ctx context.Context, moved <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	point, ok := w.waitForPoint(ctx)
+	if !ok {
+		return
+	}
+
+	for {
+		loc := w.timezone()
+		now := time.Now().In(loc)
+		w.updateSunTimes(point, now)
+
+		midnight := time.NewTimer(time.Until(nextMidnight(now)))
+	wait:
+		for {
+			select {
+			case <-midnight.C:
+				break wait
+			case <-moved:
+				midnight.Stop()
+				break wait
+			case <-ticker.C:
+				w.redraw()
+			case <-ctx.Done():
+				midnight.Stop()
+				log.Println("Termination request recieved.  Cancelling astronomy watcher.")
+				return
+			}
+		}
+		point = w.currentSite().Point
+	}
+}
+
+// updateSunTimes works out the sun's times for the day at the point and redraws
+// the bar with them
+func (w *WeatherBar) updateSunTimes(point noaa.Point, day time.Time) {
+	sun := sunTimes(point, day)
+	if *w.debug {
+		log.Printf("Sun times for %.4f/%.4f: %+v\n", point.Latitude, point.Longitude, sun)
+	}
+
+	w.sunMutex.Lock()
+	w.sun = sun
+	w.sunMutex.Unlock()
+
+	w.redraw()
+}
+
+// currentSunTimes returns the sun's times for today
+func (w *WeatherBar) currentSunTimes() SunTimes {
+	w.sunMutex.RLock()
+	defer w.sunMutex.RUnlock()
+	return w.sun
+}
+
+// timezone returns the time zone of our location, if geolocation told us what it
+// is, or else the computer's own time zone
+func (w *WeatherBar) timezone() *time.Location {
+	w.locMutex.RLock()
+	name := w.loc.Timezone
+	w.locMutex.RUnlock()
+
+	if name == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("unknown time zone %v; using local time: %v\n", name, err)
+		return time.Local
+	}
+	return loc
+}
+
+// nextMidnight returns the start of the day after t, in t's time zone
+func nextMidnight(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+}
+
+// sunTimes works out when the sun rises and sets, when civil twilight ends and when
+// the evening golden hour begins, on day's date at the point.  It uses the NOAA
+// sunrise equation, which is good to a minute or so away from the poles.  Times
+// are in day's time zone.
+func sunTimes(point noaa.Point, day time.Time) SunTimes {
+	y, m, d := day.Date()
+	noon := time.Date(y, m, d, 12, 0, 0, 0, time.UTC)
+
+	// Days from J2000.0 to mean solar noon at our longitude
+	n := julianDate(noon) - julianDateJ2000 - point.Longitude/360
+
+	anomaly := normalizeDegrees(357.5291 + 0.98560028*n)
+	center := 1.9148*sinDeg(anomaly) + 0.0200*sinDeg(2*anomaly) + 0.0003*sinDeg(3*anomaly)
+	longitude := normalizeDegrees(anomaly + center + 180 + 102.9372)
+	transit := julianDateJ2000 + n + 0.0053*sinDeg(anomaly) - 0.0069*sinDeg(2*longitude)
+	declination := math.Asin(sinDeg(longitude) * sinDeg(23.4397))
+
+	// hourAngle returns how long, as a fraction of a day, the sun is above the
+	// altitude on either side of solar noon
+	hourAngle := func(altitude float64) (float64, bool) {
+		lat := point.Latitude * math.Pi / 180
+		cos := (sinDeg(altitude) - math.Sin(lat)*math.Sin(declination)) / (math.Cos(lat) * math.Cos(declination))
+		if cos < -1 || cos > 1 {
+			return 0, false
+		}
+		return math.Acos(cos) * 180 / math.Pi / 360, true
+	}
+
+	var s SunTimes
+	loc := day.Location()
+	if h, ok := hourAngle(sunriseAltitude); ok {
+		s.Sunrise = timeFromJulianDate(transit - h).In(loc)
+		s.Sunset = timeFromJulianDate(transit + h).In(loc)
+	}
+	if h, ok := hourAngle(civilDuskAltitude); ok {
+		s.CivilDusk = timeFromJulianDate(transit + h).In(loc)
+	}
+	if h, ok := hourAngle(goldenHourAltitude); ok {
+		s.GoldenHour = timeFromJulianDate(transit + h).In(loc)
+	}
+	return s
+}
+
+// DaylightRemaining returns how much daylight is left after now, e.g. "2h 05m"
+func (s SunTimes) DaylightRemaining(now time.Time) string {
+	if s.Sunset.IsZero() {
+		return ""
+	}
+
+	start := now
+	if s.Sunrise.After(now) {
+		start = s.Sunrise
+	}
+	remaining := s.Sunset.Sub(start)
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	minutes := int(remaining.Minutes())
+	return fmt.Sprintf("%dh %02dm", minutes/60, minutes%60)
+}
+
+// formatTime formats a time of day, leaving it blank if it's zero
+func formatTime(t time.Time, layout string) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(layout)
+}
+
+// moonPhase works out the moon's phase and how much of it is lit at t, using the
+// low-precision method from chapter 48 of Meeus's Astronomical Algorithms
+func moonPhase(t time.Time) MoonPhase {
+	c := (julianDate(t) - julianDateJ2000) / 36525
+
+	// The moon's mean elongation from the sun and the sun's and moon's mean anomalies
+	d := normalizeDegrees(297.8501921 + 445267.1114034*c)
+	m := normalizeDegrees(357.5291092 + 35999.0502909*c)
+	mm := normalizeDegrees(134.9633964 + 477198.8675055*c)
+
+	// The phase angle, which is 180° at new moon and 0° at full moon
+	i := 180 - d -
+		6.289*sinDeg(mm) +
+		2.100*sinDeg(m) -
+		1.274*sinDeg(2*d-mm) -
+		0.658*sinDeg(2*d) -
+		0.214*sinDeg(2*mm) -
+		0.110*sinDeg(d)
+
+	// The elongation, which grows from 0° at new moon to 360° at the next one
+	elongation := normalizeDegrees(180 - i)
+	phase := moonPhases[int((elongation+22.5)/45)%len(moonPhases)]
+
+	return MoonPhase{
+		Name:         phase.name,
+		Glyph:        phase.glyph,
+		Illumination: (1 + math.Cos(i*math.Pi/180)) / 2 * 100,
+	}
+}
+
+// julianDate returns the Julian date of t
+func julianDate(t time.Time) float64 {
+	return float64(t.UnixNano())/float64(24*time.Hour) + 2440587.5
+}
+
+// timeFromJulianDate returns the time of a Julian date
+func timeFromJulianDate(jd float64) time.Time {
+	return time.Unix(0, int64((jd-2440587.5)*float64(24*time.Hour)))
+}
+
+// sinDeg returns the sine of an angle in degrees
+func sinDeg(degrees float64) float64 {
+	return math.Sin(degrees * math.Pi / 180)
+}
+
+// normalizeDegrees brings an angle into the range [0, 360)
+func normalizeDegrees(degrees float64) float64 {
+	degrees = math.Mod(degrees, 360)
+	if degrees < 0 {
+		degrees += 360
+	}
+	return degrees
+}
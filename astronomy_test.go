@@ -0,0 +1,111 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/chrissnell/weather-bar/internal/noaa"
+)
+
+func TestSunTimes(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	anchorage, err := time.LoadLocation("America/Anchorage")
+	if err != nil {
+		t.Skip(err)
+	}
+	nyc := noaa.Point{Latitude: 40.7128, Longitude: -74.0060}
+	utqiagvik := noaa.Point{Latitude: 71.2906, Longitude: -156.7886}
+
+	// clock returns a time of day on a date
+	clock := func(loc *time.Location, y int, m time.Month, d int, hhmm string) time.Time {
+		hm, err := time.Parse("15:04", hhmm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return time.Date(y, m, d, hm.Hour(), hm.Minute(), 0, 0, loc)
+	}
+
+	// The sunrise, sunset and end of civil twilight from the US Naval Observatory,
+	// rounded to the minute
+	tests := []struct {
+		name                       string
+		m                          time.Month
+		d                          int
+		sunrise, sunset, civilDusk string
+	}{
+		{"summer solstice", time.June, 20, "05:25", "20:31", "21:04"},
+		{"winter solstice", time.December, 21, "07:17", "16:32", "17:03"},
+	}
+
+	for _, tt := range tests {
+		s := sunTimes(nyc, time.Date(2024, tt.m, tt.d, 0, 0, 0, 0, newYork))
+		for _, e := range []struct {
+			event string
+			got   time.Time
+			want  string
+		}{
+			{"sunrise", s.Sunrise, tt.sunrise},
+			{"sunset", s.Sunset, tt.sunset},
+			{"civil dusk", s.CivilDusk, tt.civilDusk},
+		} {
+			want := clock(newYork, 2024, tt.m, tt.d, e.want)
+			if absDuration(e.got.Sub(want)) > 2*time.Minute {
+				t.Errorf("New York %v: %v = %v, want %v", tt.name, e.event, formatTime(e.got, "15:04"), e.want)
+			}
+		}
+		if s.GoldenHour.IsZero() {
+			t.Errorf("New York %v: no golden hour", tt.name)
+		}
+	}
+
+	// In the polar night, the sun doesn't rise, but it comes within 6° of the
+	// horizon at noon
+	s := sunTimes(utqiagvik, time.Date(2024, time.December, 21, 0, 0, 0, 0, anchorage))
+	if !s.Sunrise.IsZero() || !s.Sunset.IsZero() || s.CivilDusk.IsZero() || !s.GoldenHour.IsZero() {
+		t.Errorf("polar night: sunrise %q, sunset %q, civil dusk %q, golden hour %q, want only civil dusk",
+			formatTime(s.Sunrise, "15:04"), formatTime(s.Sunset, "15:04"), formatTime(s.CivilDusk, "15:04"), formatTime(s.GoldenHour, "15:04"))
+	}
+
+	// In the polar day, the sun doesn't set, but it gets low enough for a golden
+	// hour around midnight
+	s = sunTimes(utqiagvik, time.Date(2024, time.June, 21, 0, 0, 0, 0, anchorage))
+	if !s.Sunrise.IsZero() || !s.Sunset.IsZero() || !s.CivilDusk.IsZero() || s.GoldenHour.IsZero() {
+		t.Errorf("polar day: sunrise %q, sunset %q, civil dusk %q, golden hour %q, want only a golden hour",
+			formatTime(s.Sunrise, "15:04"), formatTime(s.Sunset, "15:04"), formatTime(s.CivilDusk, "15:04"), formatTime(s.GoldenHour, "15:04"))
+	}
+	if got := s.DaylightRemaining(time.Date(2024, time.June, 21, 12, 0, 0, 0, anchorage)); got != "" {
+		t.Errorf("polar day: daylight remaining = %q, want blank", got)
+	}
+}
+
+func TestMoonPhase(t *testing.T) {
+	// Phases from the US Naval Observatory
+	tests := []struct {
+		t            time.Time
+		name         string
+		illumination float64
+	}{
+		// The new moon of the total solar eclipse
+		{time.Date(2024, 4, 8, 18, 21, 0, 0, time.UTC), "New Moon", 0},
+		{time.Date(2024, 4, 15, 19, 13, 0, 0, time.UTC), "First Quarter", 50},
+		{time.Date(2024, 4, 23, 23, 49, 0, 0, time.UTC), "Full Moon", 100},
+		{time.Date(2024, 5, 1, 11, 27, 0, 0, time.UTC), "Last Quarter", 50},
+		// Between the principal phases
+		{time.Date(2024, 4, 19, 21, 31, 0, 0, time.UTC), "Waxing Gibbous", -1},
+		{time.Date(2024, 4, 4, 23, 0, 0, 0, time.UTC), "Waning Crescent", -1},
+	}
+
+	for _, tt := range tests {
+		got := moonPhase(tt.t)
+		if got.Name != tt.name {
+			t.Errorf("%v: phase = %v, want %v", tt.t, got.Name, tt.name)
+		}
+		if tt.illumination >= 0 && math.Abs(got.Illumination-tt.illumination) > 1 {
+			t.Errorf("%v: illumination = %.1f%%, want %v%%", tt.t, got.Illumination, tt.illumination)
+		}
+	}
+}
@@ -0,0 +1,542 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chrissnell/weather-bar/internal/noaa"
+)
+
+// Alerts often come in several languages.  Unless the config file says
+// otherwise, we show the English one.
+const defaultCAPLanguage = "en"
+
+// An Atom feed can link to a CAP document for every alert in a country, so we
+// only follow this many links per feed
+const maxCAPLinks = 200
+
+// We won't read CAP documents or feeds bigger than this
+const maxCAPDocumentSize = 10 << 20
+
+// CAPAlert is a Common Alerting Protocol 1.2 alert message.  Field names follow
+// the CAP specification.  Element names are matched without their namespace, so
+// these also decode CAP elements embedded in Atom feeds.
+type CAPAlert struct {
+	Identifier string    `xml:"identifier"`
+	Sender     string    `xml:"sender"`
+	Sent       string    `xml:"sent"`
+	Status     string    `xml:"status"`
+	MsgType    string    `xml:"msgType"`
+	References string    `xml:"references"`
+	Info       []CAPInfo `xml:"info"`
+}
+
+// CAPInfo describes an alert in one language
+type CAPInfo struct {
+	Language string    `xml:"language"`
+	Event    string    `xml:"event"`
+	Severity string    `xml:"severity"`
+	Expires  string    `xml:"expires"`
+	Headline string    `xml:"headline"`
+	Area     []CAPArea `xml:"area"`
+}
+
+// CAPArea is an area that an alert applies to.  Polygons are lists of
+// "latitude,longitude" pairs, and circles are a "latitude,longitude" pair and a
+// radius in kilometers.
+type CAPArea struct {
+	AreaDesc string       `xml:"areaDesc"`
+	Polygon  []string     `xml:"polygon"`
+	Circle   []string     `xml:"circle"`
+	Geocode  []CAPGeocode `xml:"geocode"`
+}
+
+// CAPGeocode is a region code, such as a FIPS code or an EMMA_ID
+type CAPGeocode struct {
+	ValueName string `xml:"valueName"`
+	Value     string `xml:"value"`
+}
+
+// atomFeed is an Atom feed of alerts.  Entries either link to a CAP document or
+// carry the CAP elements themselves, like MeteoAlarm's feeds do.
+type atomFeed struct {
+	Entries []atomEntry `xml:"entry"`
+}
+
+// atomEntry is an entry in an Atom feed of alerts
+type atomEntry struct {
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"link"`
+	Content struct {
+		Alert *CAPAlert `xml:"alert"`
+	} `xml:"content"`
+
+	// The CAP elements that some feeds put directly in their entries
+	CAPAlert
+	Event    string       `xml:"event"`
+	Severity string       `xml:"severity"`
+	Expires  string       `xml:"expires"`
+	Headline string       `xml:"headline"`
+	AreaDesc string       `xml:"areaDesc"`
+	Polygon  []string     `xml:"polygon"`
+	Circle   []string     `xml:"circle"`
+	Geocode  []CAPGeocode `xml:"geocode"`
+}
+
+// capAlertSource fetches alerts from a CAP document or an Atom feed of them, at a
+// URL or in a local file
+type capAlertSource struct {
+	location string
+	client   *http.Client
+	geocodes map[string]bool
+	language string
+	debug    bool
+
+	// CAP documents never change once they're published, so we keep the ones
+	// that the feed links to for as long as it does
+	cache map[string]CAPAlert
+}
+
+func newCAPAlertSource(location string, client *http.Client, geocodes []string, language string, debug bool) *capAlertSource {
+	s := &capAlertSource{
+		location: location,
+		client:   client,
+		geocodes: make(map[string]bool),
+		language: strings.ToLower(language),
+		debug:    debug,
+		cache:    make(map[string]CAPAlert),
+	}
+	for _, code := range geocodes {
+		s.geocodes[code] = true
+	}
+	return s
+}
+
+// name identifies the source in log messages
+func (s *capAlertSource) name() string {
+	return s.location
+}
+
+// fetchAlerts reads the feed, and the CAP documents that it links to, and returns
+// the alerts whose areas contain the point
+func (s *capAlertSource) fetchAlerts(ctx context.Context, point noaa.Point) ([]Alert, error) {
+	body, err := s.read(ctx, s.location)
+	if err != nil {
+		return nil, err
+	}
+
+	caps, links, err := parseCAPDocument(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(links) > maxCAPLinks {
+		log.Printf("%v links to %v alerts; only reading the first %v\n", s.location, len(links), maxCAPLinks)
+		links = links[:maxCAPLinks]
+	}
+
+	linked := make(map[string]CAPAlert)
+	for _, link := range links {
+		link = resolveCAPLink(s.location, link)
+		a, ok := s.cache[link]
+		if !ok {
+			a, err = s.readLinkedAlert(ctx, link)
+			if err != nil {
+				// One bad document shouldn't cost us the rest of the feed
+				if s.debug {
+					log.Println(err)
+				}
+				continue
+			}
+		}
+		linked[link] = a
+		caps = append(caps, a)
+	}
+	s.cache = linked
+
+	var alerts []Alert
+	for _, c := range caps {
+		if a, ok := c.toAlert(point, s.geocodes, s.language); ok {
+			alerts = append(alerts, a)
+		}
+	}
+	return alerts, nil
+}
+
+// readLinkedAlert reads a CAP document that a feed links to
+func (s *capAlertSource) readLinkedAlert(ctx context.Context, link string) (CAPAlert, error) {
+	body, err := s.read(ctx, link)
+	if err != nil {
+		return CAPAlert{}, err
+	}
+	caps, _, err := parseCAPDocument(body)
+	if err != nil {
+		return CAPAlert{}, fmt.Errorf("invalid CAP document %v: %v", link, err)
+	}
+	if len(caps) != 1 {
+		return CAPAlert{}, fmt.Errorf("%v is not a CAP alert", link)
+	}
+	return caps[0], nil
+}
+
+// read reads a document from a URL or a local file
+func (s *capAlertSource) read(ctx context.Context, location string) ([]byte, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		f, err := os.Open(strings.TrimPrefix(location, "file://"))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return io.ReadAll(io.LimitReader(f, maxCAPDocumentSize))
+	}
+
+	req, err := http.NewRequest("GET", location, nil)
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching %v: %v", location, r.Status)
+	}
+	return io.ReadAll(io.LimitReader(r.Body, maxCAPDocumentSize))
+}
+
+// resolveCAPLink resolves a link in a feed relative to the feed's location
+func resolveCAPLink(base, link string) string {
+	if strings.HasPrefix(base, "http://") || strings.HasPrefix(base, "https://") {
+		b, err := url.Parse(base)
+		if err != nil {
+			return link
+		}
+		l, err := url.Parse(link)
+		if err != nil {
+			return link
+		}
+		return b.ResolveReference(l).String()
+	}
+
+	if strings.Contains(link, "://") || filepath.IsAbs(link) {
+		return link
+	}
+	return filepath.Join(filepath.Dir(strings.TrimPrefix(base, "file://")), link)
+}
+
+// parseCAPDocument parses a CAP alert or an Atom feed.  It returns the alerts in
+// the document and the links to CAP documents that it has.
+func parseCAPDocument(body []byte) ([]CAPAlert, []string, error) {
+	root, err := xmlRootElement(body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch root {
+	case "alert":
+		var a CAPAlert
+		err = newCAPDecoder(body).Decode(&a)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []CAPAlert{a}, nil, nil
+
+	case "feed":
+		var feed atomFeed
+		err = newCAPDecoder(body).Decode(&feed)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var caps []CAPAlert
+		var links []string
+		for _, e := range feed.Entries {
+			switch {
+			case e.Content.Alert != nil:
+				caps = append(caps, *e.Content.Alert)
+			case e.Identifier != "":
+				caps = append(caps, e.toCAPAlert())
+			default:
+				for _, l := range e.Links {
+					if strings.Contains(l.Type, "cap") || strings.HasSuffix(l.Href, ".cap") {
+						links = append(links, l.Href)
+						break
+					}
+				}
+			}
+		}
+		return caps, links, nil
+	}
+
+	return nil, nil, fmt.Errorf("expected a CAP alert or an Atom feed, not <%v>", root)
+}
+
+// toCAPAlert collects the CAP elements that a feed entry carries into a CAPAlert
+func (e atomEntry) toCAPAlert() CAPAlert {
+	a := e.CAPAlert
+	a.Info = []CAPInfo{{
+		Event:    e.Event,
+		Severity: e.Severity,
+		Expires:  e.Expires,
+		Headline: e.Headline,
+		Area: []CAPArea{{
+			AreaDesc: e.AreaDesc,
+			Polygon:  e.Polygon,
+			Circle:   e.Circle,
+			Geocode:  e.Geocode,
+		}},
+	}}
+	return a
+}
+
+// xmlRootElement returns the name of the document's root element
+func xmlRootElement(body []byte) (string, error) {
+	d := newCAPDecoder(body)
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return "", err
+		}
+		if start, ok := t.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+// newCAPDecoder returns an XML decoder that also understands the Latin-1
+// documents that some agencies publish
+func newCAPDecoder(body []byte) *xml.Decoder {
+	d := xml.NewDecoder(bytes.NewReader(body))
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		switch strings.ToLower(charset) {
+		case "utf-8", "us-ascii":
+			return input, nil
+		case "iso-8859-1", "latin1", "windows-1252":
+			b, err := io.ReadAll(input)
+			if err != nil {
+				return nil, err
+			}
+			runes := make([]rune, len(b))
+			for i, c := range b {
+				runes[i] = rune(c)
+			}
+			return strings.NewReader(string(runes)), nil
+		}
+		return nil, fmt.Errorf("unsupported character set %v", charset)
+	}
+	return d
+}
+
+// toAlert converts a CAP alert into an Alert, if it applies to the point or to
+// one of our geocodes.  Exercises, tests and the like are ignored.
+func (c CAPAlert) toAlert(point noaa.Point, geocodes map[string]bool, language string) (Alert, bool) {
+	if c.Status != "" && !strings.EqualFold(c.Status, "Actual") {
+		return Alert{}, false
+	}
+
+	a := Alert{
+		ID:         c.Identifier,
+		References: capReferences(c.References),
+		cancel:     strings.EqualFold(c.MsgType, "Cancel"),
+	}
+	a.Sent, _ = time.Parse(time.RFC3339, c.Sent)
+
+	// A cancellation cancels its alerts wherever they were
+	if a.cancel {
+		return a, true
+	}
+
+	info, ok := c.info(language)
+	if !ok || !info.applies(point, geocodes) {
+		return Alert{}, false
+	}
+
+	a.Event = info.Event
+	a.Severity = info.Severity
+	a.Headline = info.Headline
+	if a.Headline == "" {
+		a.Headline = info.Event
+	}
+	a.Expires, _ = time.Parse(time.RFC3339, info.Expires)
+
+	return a, true
+}
+
+// info returns the alert's info in our language, or else its first info
+func (c CAPAlert) info(language string) (CAPInfo, bool) {
+	if len(c.Info) == 0 {
+		return CAPInfo{}, false
+	}
+	for _, info := range c.Info {
+		if strings.HasPrefix(strings.ToLower(info.Language), language) {
+			return info, true
+		}
+	}
+	return c.Info[0], true
+}
+
+// applies returns true if any of the alert's areas contain the point.  Areas that
+// don't give a polygon or circle apply if one of their geocodes is one of ours.
+func (info CAPInfo) applies(point noaa.Point, geocodes map[string]bool) bool {
+	known := point.Latitude != 0 || point.Longitude != 0
+
+	for _, area := range info.Area {
+		if len(area.Polygon) == 0 && len(area.Circle) == 0 {
+			for _, g := range area.Geocode {
+				if geocodes[strings.ToUpper(strings.TrimSpace(g.Value))] {
+					return true
+				}
+			}
+			continue
+		}
+		if !known {
+			continue
+		}
+
+		for _, p := range area.Polygon {
+			polygon, err := parseCAPPolygon(p)
+			if err == nil && pointInPolygon(point, polygon) {
+				return true
+			}
+		}
+		for _, c := range area.Circle {
+			center, radius, err := parseCAPCircle(c)
+			if err == nil && center.HaversineDistance(&point) <= radius {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// capReferences returns the identifiers of the alerts in a CAP references
+// element, which lists them as sender,identifier,sent separated by spaces
+func capReferences(s string) []string {
+	var ids []string
+	for _, ref := range strings.Fields(s) {
+		parts := strings.Split(ref, ",")
+		if len(parts) == 3 {
+			ids = append(ids, parts[1])
+		}
+	}
+	return ids
+}
+
+// parseCAPPoint parses a "latitude,longitude" pair
+func parseCAPPoint(s string) (noaa.Point, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return noaa.Point{}, fmt.Errorf("invalid CAP point: %q", s)
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return noaa.Point{}, fmt.Errorf("invalid CAP point: %q", s)
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return noaa.Point{}, fmt.Errorf("invalid CAP point: %q", s)
+	}
+	return noaa.Point{Latitude: lat, Longitude: lon}, nil
+}
+
+// parseCAPPolygon parses a CAP polygon, which must have at least four points with
+// the first and last the same
+func parseCAPPolygon(s string) ([]noaa.Point, error) {
+	var polygon []noaa.Point
+	for _, pair := range strings.Fields(s) {
+		p, err := parseCAPPoint(pair)
+		if err != nil {
+			return nil, err
+		}
+		polygon = append(polygon, p)
+	}
+	if len(polygon) < 4 {
+		return nil, fmt.Errorf("CAP polygon has too few points: %q", s)
+	}
+	return polygon, nil
+}
+
+// parseCAPCircle parses a CAP circle into its center and radius in kilometers
+func parseCAPCircle(s string) (noaa.Point, float64, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return noaa.Point{}, 0, fmt.Errorf("invalid CAP circle: %q", s)
+	}
+	center, err := parseCAPPoint(fields[0])
+	if err != nil {
+		return noaa.Point{}, 0, err
+	}
+	radius, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || radius < 0 {
+		return noaa.Point{}, 0, fmt.Errorf("invalid CAP circle: %q", s)
+	}
+	return center, radius, nil
+}
+
+// pointInPolygon returns true if the point is inside the polygon or on its edge.
+// It casts a ray from the point and counts the edges that it crosses.  Polygons
+// that cross the 180th meridian are unwrapped so that no edge is more than 180°
+// of longitude long, and the point is moved to within 180° of the polygon.
+func pointInPolygon(p noaa.Point, polygon []noaa.Point) bool {
+	const epsilon = 1e-9
+
+	xs := make([]float64, len(polygon))
+	west, east := math.Inf(1), math.Inf(-1)
+	for i, v := range polygon {
+		xs[i] = v.Longitude
+		if i > 0 {
+			for xs[i]-xs[i-1] > 180 {
+				xs[i] -= 360
+			}
+			for xs[i]-xs[i-1] < -180 {
+				xs[i] += 360
+			}
+		}
+		west = math.Min(west, xs[i])
+		east = math.Max(east, xs[i])
+	}
+
+	x, y := p.Longitude, p.Latitude
+	middle := (west + east) / 2
+	for x-middle > 180 {
+		x -= 360
+	}
+	for x-middle < -180 {
+		x += 360
+	}
+
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		xi, yi := xs[i], polygon[i].Latitude
+		xj, yj := xs[j], polygon[j].Latitude
+
+		// A point on an edge is in the polygon
+		cross := (xj-xi)*(y-yi) - (yj-yi)*(x-xi)
+		if cross > -epsilon && cross < epsilon &&
+			x >= math.Min(xi, xj)-epsilon && x <= math.Max(xi, xj)+epsilon &&
+			y >= math.Min(yi, yj)-epsilon && y <= math.Max(yi, yj)+epsilon {
+			return true
+		}
+
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
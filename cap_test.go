@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/chrissnell/weather-bar/internal/noaa"
+)
+
+func TestPointInPolygon(t *testing.T) {
+	square := "39,-97 39,-96 40,-96 40,-97 39,-97"
+	// A U open to the north, with its notch between -96.7 and -96.3
+	concave := "39,-97 39,-96 40,-96 40,-96.3 39.5,-96.3 39.5,-96.7 40,-96.7 40,-97 39,-97"
+	// A box from 179°E to 179°W
+	meridian := "10,179 10,-179 20,-179 20,179 10,179"
+	meridianFromEast := "10,-179 20,-179 20,179 10,179 10,-179"
+
+	tests := []struct {
+		polygon string
+		lat     float64
+		lon     float64
+		want    bool
+	}{
+		{square, 39.5, -96.5, true},
+		{square, 41, -96.5, false},
+		{square, 39.5, -95.5, false},
+		{square, 39.5, -96, true},
+		{square, 40, -96.5, true},
+		{square, 39, -97, true},
+		{square, 40, -96, true},
+		{square, 40.0001, -96, false},
+		{concave, 39.25, -96.5, true},
+		{concave, 39.75, -96.5, false},
+		{concave, 39.75, -96.85, true},
+		{concave, 39.75, -96.15, true},
+		{concave, 39.5, -96.5, true},
+		{meridian, 15, 179.5, true},
+		{meridian, 15, -179.5, true},
+		{meridian, 15, 180, true},
+		{meridian, 15, 178.5, false},
+		{meridian, 15, -178.5, false},
+		{meridian, 15, 0, false},
+		{meridianFromEast, 15, 179.5, true},
+		{meridianFromEast, 15, -179.5, true},
+		{meridianFromEast, 15, 0, false},
+	}
+
+	for _, tt := range tests {
+		polygon, err := parseCAPPolygon(tt.polygon)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := pointInPolygon(noaa.Point{Latitude: tt.lat, Longitude: tt.lon}, polygon); got != tt.want {
+			t.Errorf("%v,%v in %q = %v, want %v", tt.lat, tt.lon, tt.polygon, got, tt.want)
+		}
+	}
+}
+
+func TestCAPInfoApplies(t *testing.T) {
+	geocodes := map[string]bool{"KSZ035": true}
+	home := noaa.Point{Latitude: 40, Longitude: -96}
+
+	tests := []struct {
+		name  string
+		area  CAPArea
+		point noaa.Point
+		want  bool
+	}{
+		{
+			name:  "inside polygon",
+			area:  CAPArea{Polygon: []string{"39,-97 39,-95 41,-95 41,-97 39,-97"}},
+			point: home,
+			want:  true,
+		},
+		{
+			name:  "outside polygon",
+			area:  CAPArea{Polygon: []string{"30,-90 30,-89 31,-89 31,-90 30,-90"}},
+			point: home,
+		},
+		{
+			// One degree of latitude is 111.195 km
+			name:  "just inside circle",
+			area:  CAPArea{Circle: []string{"39,-96 111.2"}},
+			point: home,
+			want:  true,
+		},
+		{
+			name:  "just outside circle",
+			area:  CAPArea{Circle: []string{"39,-96 111.19"}},
+			point: home,
+		},
+		{
+			name:  "invalid polygon",
+			area:  CAPArea{Polygon: []string{"39,-97 39,-95 41,-95"}},
+			point: home,
+		},
+		{
+			name:  "one of our geocodes",
+			area:  CAPArea{Geocode: []CAPGeocode{{ValueName: "UGC", Value: " ksz035 "}}},
+			point: home,
+			want:  true,
+		},
+		{
+			name: "geocode elsewhere",
+			area: CAPArea{Geocode: []CAPGeocode{{ValueName: "UGC", Value: "KSZ036"}}},
+		},
+		{
+			// An area with a polygon is matched on the polygon alone
+			name: "polygon outranks geocode",
+			area: CAPArea{
+				Polygon: []string{"30,-90 30,-89 31,-89 31,-90 30,-90"},
+				Geocode: []CAPGeocode{{ValueName: "UGC", Value: "KSZ035"}},
+			},
+			point: home,
+		},
+		{
+			name: "polygon without a location",
+			area: CAPArea{Polygon: []string{"-1,-1 -1,1 1,1 1,-1 -1,-1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		info := CAPInfo{Area: []CAPArea{tt.area}}
+		if got := info.applies(tt.point, geocodes); got != tt.want {
+			t.Errorf("%v: applies = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
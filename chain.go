@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Unless provider-timeout says otherwise, a provider in a chain gets this long to
+// answer before we move on to the next one
+const defaultProviderTimeout = 30 * time.Second
+
+// Unless provider-max-age says otherwise, conditions older than this are stale and
+// we move on to the next provider in the chain
+const defaultProviderMaxAge = 2 * time.Hour
+
+// A provider that fails is left alone for this long before we try it again.  The
+// cool-down doubles with each consecutive failure, up to the maximum.
+const (
+	minProviderCooldown = 1 * time.Minute
+	maxProviderCooldown = 30 * time.Minute
+)
+
+// ProviderChain tries a list of providers in order and returns conditions from the
+// first one that answers in time with fresh data.  In merge mode, it instead asks
+// all of them and composes the conditions field by field.  Providers that fail are
+// given a cool-down before we try them again.
+type ProviderChain struct {
+	members []*chainMember
+	timeout time.Duration
+	maxAge  time.Duration
+	debug   bool
+
+	merge       bool
+	precedence  map[ObservationField][]*chainMember
+	fieldMaxAge map[ObservationField]time.Duration
+
+	startPush   sync.Once
+	healthMutex sync.Mutex
+}
+
+// chainMember is a provider in a chain and how it has been doing
+type chainMember struct {
+	provider WeatherProvider
+	fields   map[ObservationField]bool
+	health   ProviderHealth
+	push     chan CurrentObservation
+}
+
+// ProviderHealth tracks the recent successes and failures of a provider in a chain
+type ProviderHealth struct {
+	ConsecutiveFailures int
+	LastSuccess         time.Time
+	LastError           error
+	RetryAt             time.Time
+}
+
+// newProviderChain builds each of the named providers and chains them together
+func newProviderChain(w *WeatherBar, names []string) (WeatherProvider, error) {
+	cfg := w.cfg.Weather
+	c := &ProviderChain{
+		timeout: defaultProviderTimeout,
+		maxAge:  defaultProviderMaxAge,
+		debug:   *w.debug,
+	}
+
+	var err error
+	if cfg.ProviderTimeout != "" {
+		c.timeout, err = time.ParseDuration(cfg.ProviderTimeout)
+		if err != nil || c.timeout <= 0 {
+			return nil, fmt.Errorf("invalid value for provider-timeout: %v", cfg.ProviderTimeout)
+		}
+	}
+	if cfg.ProviderMaxAge != "" {
+		c.maxAge, err = time.ParseDuration(cfg.ProviderMaxAge)
+		if err != nil || c.maxAge <= 0 {
+			return nil, fmt.Errorf("invalid value for provider-max-age: %v", cfg.ProviderMaxAge)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			return nil, fmt.Errorf("weather provider %q is listed twice", name)
+		}
+		seen[name] = true
+
+		p, err := newWeatherProvider(w, name)
+		if err != nil {
+			return nil, err
+		}
+		m := &chainMember{provider: p, fields: make(map[ObservationField]bool)}
+		for _, f := range p.SupportedFields() {
+			m.fields[f] = true
+		}
+		c.members = append(c.members, m)
+	}
+
+	switch strings.ToLower(cfg.ProviderMode) {
+	case "", "failover":
+	case "merge":
+		err = c.configureMerge(w.cfg.MergePrecedence, w.cfg.MergeMaxAge)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid value for provider-mode: %v", cfg.ProviderMode)
+	}
+
+	return c, nil
+}
+
+// Name returns the names of the chained providers, in order
+func (c *ProviderChain) Name() string {
+	names := make([]string, len(c.members))
+	for i, m := range c.members {
+		names[i] = m.provider.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+// UpdateInterval returns the update interval of the first provider, which is the
+// one that we expect to be answering most of the time
+func (c *ProviderChain) UpdateInterval() time.Duration {
+	return c.members[0].provider.UpdateInterval()
+}
+
+// UsesPoint returns true if none of the chained providers needs a station.  Push
+// providers don't care where we are, so they don't count.
+func (c *ProviderChain) UsesPoint() bool {
+	for _, m := range c.members {
+		if needsSite(m.provider) && needsStation(m.provider) {
+			return false
+		}
+	}
+	return true
+}
+
+// NeedsSite returns true if any of the chained providers needs a station or location
+func (c *ProviderChain) NeedsSite() bool {
+	for _, m := range c.members {
+		if needsSite(m.provider) {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportedFields returns every field that any of the chained providers populates
+func (c *ProviderChain) SupportedFields() []ObservationField {
+	var fields []ObservationField
+	seen := make(map[ObservationField]bool)
+	for _, m := range c.members {
+		for _, f := range m.provider.SupportedFields() {
+			if !seen[f] {
+				seen[f] = true
+				fields = append(fields, f)
+			}
+		}
+	}
+	return fields
+}
+
+// FetchObservation returns conditions from the first healthy provider that has
+// them, or merges the conditions from all of them in merge mode.  If every healthy
+// provider fails, the ones that are cooling down get another try before we give up.
+// The first call starts the chain's push providers, which run until its ctx is
+// cancelled.
+func (c *ProviderChain) FetchObservation(ctx context.Context, site WeatherSite) (CurrentObservation, error) {
+	c.startPush.Do(func() { c.startPushProviders(ctx) })
+
+	if c.merge {
+		return c.fetchMerged(ctx, site)
+	}
+
+	var errs []string
+	var cooling []*chainMember
+	for _, m := range c.members {
+		if c.coolingDown(m, time.Now()) {
+			cooling = append(cooling, m)
+			continue
+		}
+		obs, err := c.fetch(ctx, m, site, c.maxAge)
+		if err == nil {
+			return obs, nil
+		}
+		errs = append(errs, err.Error())
+	}
+
+	for _, m := range cooling {
+		obs, err := c.fetch(ctx, m, site, c.maxAge)
+		if err == nil {
+			return obs, nil
+		}
+		errs = append(errs, err.Error())
+	}
+
+	return CurrentObservation{}, fmt.Errorf("no weather provider has current conditions: %v", strings.Join(errs, "; "))
+}
+
+// fetch asks one provider for conditions and records how it went.  Conditions
+// older than maxAge count as a failure.
+func (c *ProviderChain) fetch(ctx context.Context, m *chainMember, site WeatherSite, maxAge time.Duration) (CurrentObservation, error) {
+	name := m.provider.Name()
+
+	obs, err := c.fetchWithTimeout(ctx, m, site)
+	if err == nil && !obs.ObservedAt.IsZero() && time.Since(obs.ObservedAt) > maxAge {
+		err = fmt.Errorf("conditions are stale (observed %v ago)", time.Since(obs.ObservedAt).Round(time.Minute))
+	}
+	if err != nil {
+		err = fmt.Errorf("%v: %v", name, err)
+		c.recordFailure(m, err, time.Now())
+		return CurrentObservation{}, err
+	}
+
+	c.recordSuccess(m, time.Now())
+	obs.Provider = name
+	return obs, nil
+}
+
+// fetchWithTimeout fetches conditions from a provider, giving up after our
+// timeout.  The provider gets the caller's context rather than one that ends with
+// the fetch, since some providers, like APRS, stay connected between fetches.
+// They all put their own limits on how long a fetch takes.
+func (c *ProviderChain) fetchWithTimeout(ctx context.Context, m *chainMember, site WeatherSite) (CurrentObservation, error) {
+	if needsSite(m.provider) {
+		if needsStation(m.provider) && site.StationID == "" {
+			return CurrentObservation{}, fmt.Errorf("no weather station is known yet")
+		}
+		if !needsStation(m.provider) && site.Point.Latitude == 0 && site.Point.Longitude == 0 {
+			return CurrentObservation{}, fmt.Errorf("no location is known yet")
+		}
+	}
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+
+	type result struct {
+		obs CurrentObservation
+		err error
+	}
+	// Buffered, so that a provider that answers after we've given up on it
+	// doesn't block forever
+	done := make(chan result, 1)
+	go func() {
+		obs, err := m.provider.FetchObservation(ctx, site)
+		done <- result{obs, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.obs, r.err
+	case <-timer.C:
+		return CurrentObservation{}, fmt.Errorf("timed out after %v", c.timeout)
+	case <-ctx.Done():
+		return CurrentObservation{}, ctx.Err()
+	}
+}
+
+// startPushProviders starts any push providers in the chain, running them until
+// ctx is cancelled.  We read their latest conditions with FetchObservation like
+// any other provider, so the observations that they send are thrown away.
+func (c *ProviderChain) startPushProviders(ctx context.Context) {
+	for _, m := range c.members {
+		pp, ok := m.provider.(PushProvider)
+		if !ok {
+			continue
+		}
+
+		m.push = make(chan CurrentObservation, 1)
+		go func(m *chainMember) {
+			for range m.push {
+			}
+		}(m)
+		go func(m *chainMember, pp PushProvider) {
+			err := pp.Run(ctx, m.push)
+			close(m.push)
+			if err != nil && ctx.Err() == nil {
+				log.Println("Error running", pp.Name(), "weather provider:", err)
+				// Run has returned, so this provider won't have anything new for us
+				c.recordFailure(m, err, time.Now())
+			}
+		}(m, pp)
+	}
+}
+
+// coolingDown returns true if we should leave a failed provider alone for now
+func (c *ProviderChain) coolingDown(m *chainMember, now time.Time) bool {
+	c.healthMutex.Lock()
+	defer c.healthMutex.Unlock()
+	return now.Before(m.health.RetryAt)
+}
+
+// recordSuccess notes that a provider gave us conditions
+func (c *ProviderChain) recordSuccess(m *chainMember, now time.Time) {
+	c.healthMutex.Lock()
+	defer c.healthMutex.Unlock()
+
+	if m.health.ConsecutiveFailures > 0 && c.debug {
+		log.Printf("Weather provider %v recovered after %v failures\n", m.provider.Name(), m.health.ConsecutiveFailures)
+	}
+	m.health.ConsecutiveFailures = 0
+	m.health.LastSuccess = now
+	m.health.LastError = nil
+	m.health.RetryAt = time.Time{}
+}
+
+// recordFailure notes that a provider failed and starts its cool-down
+func (c *ProviderChain) recordFailure(m *chainMember, err error, now time.Time) {
+	c.healthMutex.Lock()
+	defer c.healthMutex.Unlock()
+
+	m.health.ConsecutiveFailures++
+	m.health.LastError = err
+
+	cooldown := maxProviderCooldown
+	if m.health.ConsecutiveFailures <= 10 {
+		cooldown = minProviderCooldown << uint(m.health.ConsecutiveFailures-1)
+		if cooldown > maxProviderCooldown {
+			cooldown = maxProviderCooldown
+		}
+	}
+	m.health.RetryAt = now.Add(cooldown)
+
+	if c.debug {
+		lastSuccess := "never"
+		if !m.health.LastSuccess.IsZero() {
+			lastSuccess = m.health.LastSuccess.Format(time.RFC3339)
+		}
+		log.Printf("Weather provider failed %v times in a row (last success: %v), cooling down for %v: %v\n",
+			m.health.ConsecutiveFailures, lastSuccess, cooldown, err)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubChain chains stub providers that report a temperature
+func stubChain(providers ...*stubProvider) *ProviderChain {
+	c := &ProviderChain{timeout: time.Second, maxAge: time.Hour}
+	for _, p := range providers {
+		if p.obs.ObservedAt.IsZero() {
+			p.obs.set(FieldTemperature, 50)
+			p.obs.ObservedAt = time.Now()
+		}
+		c.members = append(c.members, &chainMember{provider: p, fields: map[ObservationField]bool{FieldTemperature: true}})
+	}
+	return c
+}
+
+var stubSite = WeatherSite{StationID: "KMHK"}
+
+func TestChainFailover(t *testing.T) {
+	var stale CurrentObservation
+	stale.set(FieldTemperature, 50)
+	stale.ObservedAt = time.Now().Add(-3 * time.Hour)
+
+	tests := []struct {
+		name    string
+		first   *stubProvider
+		timeout time.Duration
+		err     string
+	}{
+		{
+			name:  "failure",
+			first: &stubProvider{name: "first", err: errors.New("503 Service Unavailable")},
+			err:   "first: 503 Service Unavailable",
+		},
+		{
+			name:    "hanging",
+			first:   &stubProvider{name: "first", delay: 2 * time.Second},
+			timeout: 50 * time.Millisecond,
+			err:     "first: timed out after 50ms",
+		},
+		{
+			name:  "stale",
+			first: &stubProvider{name: "first", obs: stale},
+			err:   "first: conditions are stale (observed 3h0m0s ago)",
+		},
+	}
+
+	for _, tt := range tests {
+		second := &stubProvider{name: "second"}
+		c := stubChain(tt.first, second)
+		if tt.timeout != 0 {
+			c.timeout = tt.timeout
+		}
+
+		start := time.Now()
+		obs, err := c.FetchObservation(context.Background(), stubSite)
+		if err != nil {
+			t.Errorf("%v: %v", tt.name, err)
+			continue
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%v: fetching took %v", tt.name, elapsed)
+		}
+		if obs.Provider != "second" {
+			t.Errorf("%v: conditions came from %q, want second", tt.name, obs.Provider)
+		}
+
+		health := c.members[0].health
+		if health.ConsecutiveFailures != 1 || health.LastError == nil || health.LastError.Error() != tt.err {
+			t.Errorf("%v: first provider failed %v times with %v, want once with %v", tt.name, health.ConsecutiveFailures, health.LastError, tt.err)
+		}
+		if cooldown := time.Until(health.RetryAt); cooldown < 59*time.Second || cooldown > minProviderCooldown {
+			t.Errorf("%v: first provider is cooling down for %v, want %v", tt.name, cooldown, minProviderCooldown)
+		}
+
+		// The first provider is left alone while it cools down
+		calls := tt.first.calls
+		c.FetchObservation(context.Background(), stubSite)
+		if tt.first.calls != calls {
+			t.Errorf("%v: first provider was asked again while cooling down", tt.name)
+		}
+	}
+}
+
+func TestChainCooldown(t *testing.T) {
+	c := stubChain(&stubProvider{name: "first"})
+	m := c.members[0]
+	now := time.Date(2024, 3, 14, 12, 0, 0, 0, time.UTC)
+
+	want := []time.Duration{1, 2, 4, 8, 16, 30, 30, 30, 30, 30, 30, 30}
+	for i, minutes := range want {
+		c.recordFailure(m, errors.New("failed"), now)
+		if cooldown := m.health.RetryAt.Sub(now); cooldown != minutes*time.Minute {
+			t.Errorf("after %v failures, cooling down for %v, want %v", i+1, cooldown, minutes*time.Minute)
+		}
+	}
+
+	c.recordSuccess(m, now)
+	if m.health.ConsecutiveFailures != 0 || c.coolingDown(m, now) {
+		t.Errorf("after a success, %v failures and cooling down %v, want none", m.health.ConsecutiveFailures, c.coolingDown(m, now))
+	}
+	c.recordFailure(m, errors.New("failed"), now)
+	if cooldown := m.health.RetryAt.Sub(now); cooldown != minProviderCooldown {
+		t.Errorf("after recovering and failing again, cooling down for %v, want %v", cooldown, minProviderCooldown)
+	}
+}
+
+func TestChainRetriesCoolingProviders(t *testing.T) {
+	first := &stubProvider{name: "first", err: errors.New("connection refused")}
+	second := &stubProvider{name: "second"}
+	c := stubChain(first, second)
+
+	obs, err := c.FetchObservation(context.Background(), stubSite)
+	if err != nil || obs.Provider != "second" {
+		t.Fatalf("conditions came from %q (error %v), want second", obs.Provider, err)
+	}
+
+	// With the first provider cooling down and the second one failing, the first
+	// one gets another try
+	first.err = nil
+	second.err = errors.New("404 Not Found")
+	obs, err = c.FetchObservation(context.Background(), stubSite)
+	if err != nil || obs.Provider != "first" {
+		t.Errorf("conditions came from %q (error %v), want first", obs.Provider, err)
+	}
+	if c.members[0].health.ConsecutiveFailures != 0 || c.members[1].health.ConsecutiveFailures != 1 {
+		t.Errorf("consecutive failures = %v, %v, want 0, 1", c.members[0].health.ConsecutiveFailures, c.members[1].health.ConsecutiveFailures)
+	}
+
+	// When every provider fails, we hear from all of them
+	first.err = errors.New("connection refused")
+	c.members[1].health.RetryAt = time.Time{}
+	_, err = c.FetchObservation(context.Background(), stubSite)
+	want := "no weather provider has current conditions: first: connection refused; second: 404 Not Found"
+	if err == nil || err.Error() != want {
+		t.Errorf("error = %v, want %v", err, want)
+	}
+	if !strings.Contains(c.members[0].health.LastError.Error(), "connection refused") {
+		t.Errorf("first provider's last error = %v", c.members[0].health.LastError)
+	}
+}
+
+// stubPushProvider is a stub provider that runs until its context is cancelled
+type stubPushProvider struct {
+	stubProvider
+	stopped chan struct{}
+}
+
+func (p *stubPushProvider) Run(ctx context.Context, obsChan chan<- CurrentObservation) error {
+	<-ctx.Done()
+	close(p.stopped)
+	return ctx.Err()
+}
+
+func TestChainStopsPushProviders(t *testing.T) {
+	p := &stubPushProvider{stubProvider: stubProvider{name: "push"}, stopped: make(chan struct{})}
+	p.obs.set(FieldTemperature, 50)
+	p.obs.ObservedAt = time.Now()
+	c := stubChain(&stubProvider{name: "first"})
+	c.members = append(c.members, &chainMember{provider: p})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := c.FetchObservation(ctx, stubSite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	select {
+	case <-p.stopped:
+	case <-time.After(time.Second):
+		t.Error("push provider still running after the context was cancelled")
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// conditionalRegexp matches a conditional section of the weather format.
+// %if:class%...%endif% is only shown when the class is set, and
+// %if:!class%...%endif% only when it isn't.
+var conditionalRegexp = regexp.MustCompile(`(?s)%if:(!?)([a-z0-9-]+)%(.*?)%endif%`)
+
+// applyConditionals keeps or drops each conditional section of the format,
+// depending on which classes are set
+func applyConditionals(format string, classes map[string]bool) string {
+	return conditionalRegexp.ReplaceAllStringFunc(format, func(section string) string {
+		m := conditionalRegexp.FindStringSubmatch(section)
+		negate, class, body := m[1] == "!", m[2], m[3]
+		if classes[class] != negate {
+			return body
+		}
+		return ""
+	})
+}
+
+// classList returns the classes that are set, sorted and separated by spaces, for
+// the %classes% token
+func classList(classes map[string]bool) string {
+	var set []string
+	for class, ok := range classes {
+		if ok {
+			set = append(set, class)
+		}
+	}
+	sort.Strings(set)
+	return strings.Join(set, " ")
+}
+
+// usesClasses returns true if the format tests any of the classes or lists them all
+func usesClasses(format string, classes ...string) bool {
+	if strings.Contains(format, "%classes%") {
+		return true
+	}
+	for _, m := range conditionalRegexp.FindAllStringSubmatch(format, -1) {
+		for _, class := range classes {
+			if m[2] == class {
+				return true
+			}
+		}
+	}
+	return false
+}
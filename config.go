@@ -10,6 +10,18 @@ import (
 type Config struct {
 	Weather WeatherConfig
 	Format  FormatConfig
+	Alerts  AlertsConfig
+
+	AirQuality AirQualityConfig
+	Tides      TidesConfig
+
+	// MQTTFields maps observation fields to the keys of MQTT messages
+	MQTTFields map[string]string
+
+	// MergePrecedence and MergeMaxAge map observation fields to the providers
+	// to take them from and how old they may get, when merging providers
+	MergePrecedence map[string]string
+	MergeMaxAge     map[string]string
 }
 
 // WeatherConfig holds configuration related to our local weather station
@@ -17,12 +29,70 @@ type WeatherConfig struct {
 	Latitude  string `ini:"latitude"`
 	Longitude string `ini:"longitude"`
 	Station   string `ini:"station"`
+	Provider  string `ini:"provider"`
 	WUAPIKey  string `ini:"weather-underground-api-key"`
+	Elevation string `ini:"elevation"`
+
+	HistoryFile string `ini:"history-file"`
+
+	ProviderMode    string `ini:"provider-mode"`
+	ProviderTimeout string `ini:"provider-timeout"`
+	ProviderMaxAge  string `ini:"provider-max-age"`
+
+	PushListenAddress string `ini:"push-listen-address"`
+	PushPasskey       string `ini:"push-passkey"`
+
+	WeatherLinkHost     string `ini:"weatherlink-host"`
+	WeatherLinkRealTime string `ini:"weatherlink-realtime"`
+
+	MQTTBroker    string `ini:"mqtt-broker"`
+	MQTTTopics    string `ini:"mqtt-topics"`
+	MQTTUsername  string `ini:"mqtt-username"`
+	MQTTPassword  string `ini:"mqtt-password"`
+	MQTTMatch     string `ini:"mqtt-match"`
+	MQTTMinFields string `ini:"mqtt-min-fields"`
+	MQTTMaxAge    string `ini:"mqtt-max-age"`
+
+	ExecCommand  string `ini:"exec-command"`
+	ExecInterval string `ini:"exec-interval"`
+	ExecTimeout  string `ini:"exec-timeout"`
+	ExecLocation string `ini:"exec-location"`
+
+	WeeWXDatabase string `ini:"weewx-database"`
+	WeeWXLoopFile string `ini:"weewx-loop-file"`
+
+	APRSServer   string `ini:"aprs-server"`
+	APRSLogin    string `ini:"aprs-login"`
+	APRSPasscode string `ini:"aprs-passcode"`
+	APRSRadius   string `ini:"aprs-radius"`
+
+	NDBCStation string `ini:"ndbc-station"`
+}
+
+// AlertsConfig holds the configuration for watches, warnings and advisories
+type AlertsConfig struct {
+	Feeds    string `ini:"feeds"`
+	Zones    string `ini:"zones"`
+	Geocodes string `ini:"geocodes"`
+	Language string `ini:"language"`
+}
+
+// AirQualityConfig holds the configuration for the air quality tokens
+type AirQualityConfig struct {
+	Sources        string `ini:"sources"`
+	AirNowAPIKey   string `ini:"airnow-api-key"`
+	AirNowDistance string `ini:"airnow-distance"`
+}
+
+// TidesConfig holds the configuration for the tide tokens
+type TidesConfig struct {
+	Station string `ini:"station"`
 }
 
 // FormatConfig holds our output formatting configuration
 type FormatConfig struct {
-	WxFormat string `ini:"weather-format"`
+	WxFormat   string `ini:"weather-format"`
+	TimeFormat string `ini:"time-format"`
 }
 
 // NewConfig creates an new config object from the given filename.
@@ -46,6 +116,21 @@ func NewConfig(filename string) (*Config, error) {
 	if err != nil {
 		return &Config{}, err
 	}
+	err = cfg.Section("alerts").MapTo(&c.Alerts)
+	if err != nil {
+		return &Config{}, err
+	}
+	err = cfg.Section("air-quality").MapTo(&c.AirQuality)
+	if err != nil {
+		return &Config{}, err
+	}
+	err = cfg.Section("tides").MapTo(&c.Tides)
+	if err != nil {
+		return &Config{}, err
+	}
+	c.MQTTFields = cfg.Section("mqtt-fields").KeysHash()
+	c.MergePrecedence = cfg.Section("merge-precedence").KeysHash()
+	c.MergeMaxAge = cfg.Section("merge-max-age").KeysHash()
 
 	return c, nil
 }
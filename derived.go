@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// derivedFieldRules lists the fields that we can work out for ourselves and the
+// fields that each one needs
+var derivedFieldRules = []struct {
+	field  ObservationField
+	inputs []ObservationField
+}{
+	{FieldDewpoint, []ObservationField{FieldTemperature, FieldHumidity}},
+	{FieldHumidity, []ObservationField{FieldTemperature, FieldDewpoint}},
+	{FieldWindChill, []ObservationField{FieldTemperature, FieldWindSpeed}},
+	{FieldHeatIndex, []ObservationField{FieldTemperature, FieldHumidity}},
+	{FieldFeelsLike, []ObservationField{FieldTemperature, FieldHumidity, FieldWindSpeed}},
+	{FieldHumidex, []ObservationField{FieldTemperature, FieldDewpoint}},
+	{FieldWetBulb, []ObservationField{FieldTemperature, FieldHumidity}},
+	{FieldBarometer, []ObservationField{FieldStationPressure}},
+}
+
+// addDerivableFields adds the fields that we can derive from the supported fields
+// to them
+func addDerivableFields(supported map[ObservationField]bool) {
+	// A derived field can be the input to another, so keep going until we stop
+	// finding new ones
+	for added := true; added; {
+		added = false
+		for _, rule := range derivedFieldRules {
+			if supported[rule.field] {
+				continue
+			}
+			derivable := true
+			for _, f := range rule.inputs {
+				derivable = derivable && supported[f]
+			}
+			if derivable {
+				supported[rule.field] = true
+				added = true
+			}
+		}
+	}
+}
+
+// parseElevation parses the station's elevation in feet from the config file.  It
+// defaults to sea level.
+func parseElevation(cfg *Config) (float64, error) {
+	if cfg.Weather.Elevation == "" {
+		return 0, nil
+	}
+	elevation, err := strconv.ParseFloat(cfg.Weather.Elevation, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for elevation: %v", err)
+	}
+	return elevation, nil
+}
+
+// deriveObservation fills in the fields that the provider left empty with values
+// worked out from the fields that it did report.  elevation is the station's
+// elevation in feet, for reducing its pressure to sea level.
+func deriveObservation(obs CurrentObservation, elevation float64) CurrentObservation {
+	t := fahrenheitToCelsius(obs.Temperature)
+
+	// The Magnus formula has no answer for bone-dry air
+	if canDerive(obs, FieldDewpoint) && obs.Humidity > 0 {
+		obs.set(FieldDewpoint, roundTenth(celsiusToFahrenheit(dewpoint(t, obs.Humidity))))
+	}
+	if canDerive(obs, FieldHumidity) {
+		obs.set(FieldHumidity, math.Round(relativeHumidity(t, fahrenheitToCelsius(obs.Dewpoint))))
+	}
+
+	if canDerive(obs, FieldWindChill) {
+		obs.set(FieldWindChill, roundTenth(windChill(obs.Temperature, obs.WindSpeed)))
+	}
+	if canDerive(obs, FieldHeatIndex) {
+		obs.set(FieldHeatIndex, roundTenth(heatIndex(obs.Temperature, obs.Humidity)))
+	}
+	if canDerive(obs, FieldFeelsLike) {
+		obs.set(FieldFeelsLike, roundTenth(celsiusToFahrenheit(apparentTemperature(t, obs.Humidity, obs.WindSpeed*metersPerSecondPerMph))))
+	}
+	if canDerive(obs, FieldHumidex) {
+		obs.set(FieldHumidex, roundTenth(humidex(t, fahrenheitToCelsius(obs.Dewpoint))))
+	}
+	if canDerive(obs, FieldWetBulb) {
+		obs.set(FieldWetBulb, roundTenth(celsiusToFahrenheit(wetBulb(t, obs.Humidity))))
+	}
+
+	if canDerive(obs, FieldBarometer) {
+		// Without a temperature, the standard atmosphere's 15°C is close enough
+		if !obs.Has(FieldTemperature) {
+			t = 15
+		}
+		obs.set(FieldBarometer, roundTenth(seaLevelPressure(obs.StationPressure, t, elevation*metersPerFoot)))
+	}
+
+	return obs
+}
+
+// canDerive returns true if the provider left a field empty but filled in every
+// field that we need to work it out
+func canDerive(obs CurrentObservation, f ObservationField) bool {
+	if obs.Has(f) {
+		return false
+	}
+	for _, rule := range derivedFieldRules {
+		if rule.field != f {
+			continue
+		}
+		for _, input := range rule.inputs {
+			if !obs.Has(input) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// The Magnus formula constants recommended by Alduchov and Eskridge (1996)
+const (
+	magnusA = 17.625
+	magnusB = 243.04
+)
+
+// dewpoint returns the dewpoint in °C for a temperature in °C and a relative
+// humidity in %
+func dewpoint(t, rh float64) float64 {
+	gamma := math.Log(rh/100) + magnusA*t/(magnusB+t)
+	return magnusB * gamma / (magnusA - gamma)
+}
+
+// relativeHumidity returns the relative humidity in % for a temperature and
+// dewpoint in °C
+func relativeHumidity(t, td float64) float64 {
+	rh := 100 * math.Exp(magnusA*td/(magnusB+td)-magnusA*t/(magnusB+t))
+	return math.Min(rh, 100)
+}
+
+// windChill returns the NWS wind chill in °F for a temperature in °F and a wind
+// speed in miles/hour.  The formula only applies at 50°F and below with a wind of
+// more than 3 mph, so otherwise it's just the temperature.
+func windChill(t, v float64) float64 {
+	if t > 50 || v <= 3 {
+		return t
+	}
+	pv := math.Pow(v, 0.16)
+	return 35.74 + 0.6215*t - 35.75*pv + 0.4275*t*pv
+}
+
+// heatIndex returns the NWS heat index in °F for a temperature in °F and a
+// relative humidity in %, using Rothfusz's regression and the NWS adjustments.
+// Below 80°F the heat index means nothing, so it's just the temperature.
+func heatIndex(t, rh float64) float64 {
+	if t < 80 {
+		return t
+	}
+
+	// The NWS uses Steadman's simpler formula when it gives a heat index below 80°F
+	hi := 0.5 * (t + 61 + (t-68)*1.2 + rh*0.094)
+	if (hi+t)/2 < 80 {
+		return hi
+	}
+
+	hi = -42.379 + 2.04901523*t + 10.14333127*rh -
+		0.22475541*t*rh - 0.00683783*t*t - 0.05481717*rh*rh +
+		0.00122874*t*t*rh + 0.00085282*t*rh*rh - 0.00000199*t*t*rh*rh
+
+	switch {
+	case rh < 13 && t >= 80 && t <= 112:
+		hi -= (13 - rh) / 4 * math.Sqrt((17-math.Abs(t-95))/17)
+	case rh > 85 && t >= 80 && t <= 87:
+		hi += (rh - 85) / 10 * (87 - t) / 5
+	}
+	return hi
+}
+
+// vaporPressure returns the water vapor pressure in hPa for a temperature in °C
+// and a relative humidity in %
+func vaporPressure(t, rh float64) float64 {
+	return rh / 100 * 6.105 * math.Exp(17.27*t/(237.7+t))
+}
+
+// apparentTemperature returns Steadman's apparent temperature in °C, as used by
+// the Australian Bureau of Meteorology, for a temperature in °C, a relative
+// humidity in % and a wind speed in meters/second
+func apparentTemperature(t, rh, ws float64) float64 {
+	return t + 0.33*vaporPressure(t, rh) - 0.70*ws - 4.00
+}
+
+// humidex returns the Canadian humidex for a temperature and dewpoint in °C
+func humidex(t, td float64) float64 {
+	e := 6.11 * math.Exp(5417.7530*(1/273.16-1/(273.15+td)))
+	return t + 0.5555*(e-10)
+}
+
+// wetBulb returns the wet-bulb temperature in °C for a temperature in °C and a
+// relative humidity in %, using Stull's (2011) empirical formula.  It's good to
+// about 1°C at everyday pressures.
+func wetBulb(t, rh float64) float64 {
+	return t*math.Atan(0.151977*math.Sqrt(rh+8.313659)) +
+		math.Atan(t+rh) - math.Atan(rh-1.676331) +
+		0.00391838*math.Pow(rh, 1.5)*math.Atan(0.023101*rh) -
+		4.686035
+}
+
+// seaLevelPressure reduces a station pressure in millibars to sea level, given
+// the temperature in °C and the station's elevation in meters
+func seaLevelPressure(p, t, h float64) float64 {
+	return p * math.Pow(1-0.0065*h/(t+0.0065*h+273.15), -5.257)
+}
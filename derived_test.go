@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// observation builds an observation with the given numeric fields filled in
+func observation(values map[ObservationField]float64) CurrentObservation {
+	var obs CurrentObservation
+	for f, v := range values {
+		obs.set(f, v)
+	}
+	return obs
+}
+
+func TestDeriveObservation(t *testing.T) {
+	tests := []struct {
+		name      string
+		obs       CurrentObservation
+		elevation float64
+		want      map[ObservationField]float64
+		missing   []ObservationField
+	}{
+		{
+			name: "wind chill",
+			obs:  observation(map[ObservationField]float64{FieldTemperature: 0, FieldWindSpeed: 15}),
+			want: map[ObservationField]float64{FieldWindChill: -19.4},
+		},
+		{
+			name: "heat index and dewpoint",
+			obs:  observation(map[ObservationField]float64{FieldTemperature: 90, FieldHumidity: 70}),
+			want: map[ObservationField]float64{FieldHeatIndex: 105.9, FieldDewpoint: 78.9},
+		},
+		{
+			name: "humidity from dewpoint",
+			obs:  observation(map[ObservationField]float64{FieldTemperature: 68, FieldDewpoint: 48.7}),
+			want: map[ObservationField]float64{FieldHumidity: 50},
+		},
+		{
+			name:    "no temperature",
+			obs:     observation(map[ObservationField]float64{FieldWindSpeed: 20, FieldHumidity: 60}),
+			missing: []ObservationField{FieldWindChill, FieldHeatIndex, FieldFeelsLike, FieldHumidex, FieldWetBulb, FieldDewpoint},
+		},
+		{
+			name: "real zero dewpoint",
+			obs:  observation(map[ObservationField]float64{FieldTemperature: 10, FieldHumidity: 60, FieldDewpoint: 0}),
+			want: map[ObservationField]float64{FieldDewpoint: 0},
+		},
+		{
+			name: "sea-level pressure without temperature",
+			obs:  observation(map[ObservationField]float64{FieldStationPressure: 1000}),
+			want: map[ObservationField]float64{FieldBarometer: 1000},
+		},
+		{
+			// The standard atmosphere at 1000 feet, which is 1013.25 mb at sea level
+			name:      "sea-level pressure at elevation",
+			obs:       observation(map[ObservationField]float64{FieldStationPressure: 977.2, FieldTemperature: 55.4}),
+			elevation: 1000,
+			want:      map[ObservationField]float64{FieldBarometer: 1013.25},
+		},
+		{
+			// Environment Canada's humidex table gives 34 for 30°C with a 15°C dewpoint
+			name: "humidex",
+			obs:  observation(map[ObservationField]float64{FieldTemperature: 86, FieldDewpoint: 59}),
+			want: map[ObservationField]float64{FieldHumidex: 34},
+		},
+		{
+			// Stull's (2011) worked example: 20°C at 50% humidity is 13.7°C wet-bulb
+			name: "wet-bulb",
+			obs:  observation(map[ObservationField]float64{FieldTemperature: 68, FieldHumidity: 50}),
+			want: map[ObservationField]float64{FieldWetBulb: 56.7},
+		},
+	}
+
+	for _, tt := range tests {
+		obs := deriveObservation(tt.obs, tt.elevation)
+		for f, want := range tt.want {
+			got := *numericFields[f](&obs)
+			if !obs.Has(f) || math.Abs(got-want) > 0.1 {
+				t.Errorf("%v: %v = %v (filled %v), want %v", tt.name, f, got, obs.Has(f), want)
+			}
+		}
+		for _, f := range tt.missing {
+			if obs.Has(f) {
+				t.Errorf("%v: %v = %v, want it left empty", tt.name, f, *numericFields[f](&obs))
+			}
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Commands that run longer than this are killed, unless exec-timeout says otherwise
+const defaultExecTimeout = 30 * time.Second
+
+func init() {
+	registerProvider("exec", newExecProvider)
+}
+
+// ExecProvider runs a user-supplied command and reads the current conditions from
+// the JSON object that it prints.  This lets a small script bring in conditions
+// from a source that weather-bar doesn't know about.
+type ExecProvider struct {
+	command   string
+	interval  time.Duration
+	timeout   time.Duration
+	stationID string
+	location  bool
+	debug     bool
+}
+
+// ExecObservation is the JSON object that the command prints.  The keys are the
+// same field names used everywhere else in weather-bar, and the values use the
+// units of CurrentObservation.  Every key is optional.
+type ExecObservation struct {
+	StationID         string     `json:"station-id"`
+	Weather           string     `json:"weather"`
+	Temperature       *float64   `json:"temperature"`
+	Humidity          *float64   `json:"humidity"`
+	Dewpoint          *float64   `json:"dewpoint"`
+	WindChill         *float64   `json:"wind-chill"`
+	HeatIndex         *float64   `json:"heat-index"`
+	FeelsLike         *float64   `json:"feels-like"`
+	WindDir           *float64   `json:"wind-direction"`
+	WindSpeed         *float64   `json:"wind-speed"`
+	WindGust          *float64   `json:"wind-gust"`
+	Barometer         *float64   `json:"barometer"`
+	RainToday         *float64   `json:"rain-today"`
+	Rain1Hour         *float64   `json:"rain-last-hour"`
+	RainRate          *float64   `json:"rain-rate"`
+	IndoorTemperature *float64   `json:"indoor-temperature"`
+	IndoorHumidity    *float64   `json:"indoor-humidity"`
+	Visibility        *float64   `json:"visibility"`
+	Ceiling           *float64   `json:"ceiling"`
+	PresentWeather    string     `json:"present-weather"`
+	ObservedAt        *time.Time `json:"observed-at"`
+}
+
+func newExecProvider(w *WeatherBar) (WeatherProvider, error) {
+	cfg := w.cfg.Weather
+	if cfg.ExecCommand == "" {
+		return nil, fmt.Errorf("the exec provider requires exec-command to be set")
+	}
+
+	p := &ExecProvider{
+		command:   cfg.ExecCommand,
+		interval:  execUpdateInterval,
+		timeout:   defaultExecTimeout,
+		stationID: cfg.Station,
+		debug:     *w.debug,
+	}
+
+	// We only have to know where we are if the command wants our location
+	p.location = strings.Contains(p.command, "WEATHER_BAR_LATITUDE") || strings.Contains(p.command, "WEATHER_BAR_LONGITUDE")
+
+	var err error
+	if cfg.ExecInterval != "" {
+		p.interval, err = time.ParseDuration(cfg.ExecInterval)
+		if err != nil || p.interval <= 0 {
+			return nil, fmt.Errorf("invalid value for exec-interval: %v", cfg.ExecInterval)
+		}
+	}
+	if cfg.ExecTimeout != "" {
+		p.timeout, err = time.ParseDuration(cfg.ExecTimeout)
+		if err != nil || p.timeout <= 0 {
+			return nil, fmt.Errorf("invalid value for exec-timeout: %v", cfg.ExecTimeout)
+		}
+	}
+	if cfg.ExecLocation != "" {
+		p.location, err = strconv.ParseBool(cfg.ExecLocation)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for exec-location: %v", cfg.ExecLocation)
+		}
+	}
+
+	return p, nil
+}
+
+// Name returns the name of this provider
+func (p *ExecProvider) Name() string {
+	return "exec"
+}
+
+// UpdateInterval returns how often we run the command
+func (p *ExecProvider) UpdateInterval() time.Duration {
+	return p.interval
+}
+
+// UsesPoint returns true because we hand the command our latitude/longitude
+// rather than a station
+func (p *ExecProvider) UsesPoint() bool {
+	return true
+}
+
+// NeedsSite returns true if the command uses our latitude/longitude.  Otherwise,
+// it can run before we know where we are, or without ever finding out.
+func (p *ExecProvider) NeedsSite() bool {
+	return p.location
+}
+
+// SupportedFields returns every field that the command may print.  We can't know
+// which ones it actually does.
+func (p *ExecProvider) SupportedFields() []ObservationField {
+	return []ObservationField{
+		FieldStationID,
+		FieldWeather,
+		FieldTemperature,
+		FieldHumidity,
+		FieldDewpoint,
+		FieldWindChill,
+		FieldHeatIndex,
+		FieldFeelsLike,
+		FieldWindDir,
+		FieldWindSpeed,
+		FieldWindGust,
+		FieldBarometer,
+		FieldRainToday,
+		FieldRain1Hour,
+		FieldRainRate,
+		FieldIndoorTemperature,
+		FieldIndoorHumidity,
+		FieldVisibility,
+		FieldCeiling,
+		FieldPresentWeather,
+	}
+}
+
+// FetchObservation runs the command through the shell and parses what it prints.
+// The site's location is passed in the environment.
+func (p *ExecProvider) FetchObservation(ctx context.Context, site WeatherSite) (CurrentObservation, error) {
+	if p.debug {
+		log.Println("Running", p.command, "for current conditions...")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", p.command)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("WEATHER_BAR_LATITUDE=%.4f", site.Point.Latitude),
+		fmt.Sprintf("WEATHER_BAR_LONGITUDE=%.4f", site.Point.Longitude),
+	)
+
+	// If the command leaves a child behind holding its output open, don't wait
+	// around for the child to finish too
+	cmd.WaitDelay = time.Second
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return CurrentObservation{}, fmt.Errorf("exec provider command timed out after %v", p.timeout)
+	}
+	if err != nil {
+		return CurrentObservation{}, fmt.Errorf("exec provider command failed: %v%v", err, execErrorDetail(stderr.String()))
+	}
+
+	obs, err := p.parseOutput(stdout.Bytes())
+	if err != nil {
+		return CurrentObservation{}, fmt.Errorf("exec provider command printed an invalid observation: %v", err)
+	}
+	return obs, nil
+}
+
+// parseOutput decodes the command's output.  Unknown keys are rejected so that a
+// misspelled field doesn't go silently missing from the bar.
+func (p *ExecProvider) parseOutput(out []byte) (CurrentObservation, error) {
+	dec := json.NewDecoder(bytes.NewReader(out))
+	dec.DisallowUnknownFields()
+
+	var eo ExecObservation
+	err := dec.Decode(&eo)
+	if err != nil {
+		return CurrentObservation{}, err
+	}
+
+	obs := eo.toCurrentObservation()
+	if obs.ObservedAt.IsZero() {
+		obs.ObservedAt = time.Now()
+	}
+	if obs.StationID == "" {
+		obs.setText(FieldStationID, p.stationID)
+	}
+	if obs.StationID == "" {
+		obs.setText(FieldStationID, "exec")
+	}
+	return obs, nil
+}
+
+// execErrorDetail returns the last line that a failed command wrote to stderr,
+// which is usually the one that says what went wrong
+func execErrorDetail(stderr string) string {
+	stderr = strings.TrimSpace(stderr)
+	if stderr == "" {
+		return ""
+	}
+	lines := strings.Split(stderr, "\n")
+	return fmt.Sprintf(" (%v)", strings.TrimSpace(lines[len(lines)-1]))
+}
+
+// toCurrentObservation maps the command's output into a CurrentObservation
+func (o ExecObservation) toCurrentObservation() CurrentObservation {
+	var obs CurrentObservation
+	obs.setText(FieldStationID, o.StationID)
+	obs.setText(FieldWeather, o.Weather)
+	obs.setText(FieldPresentWeather, o.PresentWeather)
+
+	obs.setIfPresent(FieldTemperature, o.Temperature)
+	obs.setIfPresent(FieldHumidity, o.Humidity)
+	obs.setIfPresent(FieldDewpoint, o.Dewpoint)
+	obs.setIfPresent(FieldWindChill, o.WindChill)
+	obs.setIfPresent(FieldHeatIndex, o.HeatIndex)
+	obs.setIfPresent(FieldFeelsLike, o.FeelsLike)
+	obs.setIfPresent(FieldWindDir, o.WindDir)
+	obs.setIfPresent(FieldWindSpeed, o.WindSpeed)
+	obs.setIfPresent(FieldWindGust, o.WindGust)
+	obs.setIfPresent(FieldBarometer, o.Barometer)
+	obs.setIfPresent(FieldRainToday, o.RainToday)
+	obs.setIfPresent(FieldRain1Hour, o.Rain1Hour)
+	obs.setIfPresent(FieldRainRate, o.RainRate)
+	obs.setIfPresent(FieldIndoorTemperature, o.IndoorTemperature)
+	obs.setIfPresent(FieldIndoorHumidity, o.IndoorHumidity)
+	obs.setIfPresent(FieldVisibility, o.Visibility)
+	if o.Ceiling != nil {
+		obs.Ceiling = *o.Ceiling
+		obs.HasCeiling = true
+		obs.Filled.Add(FieldCeiling)
+	}
+	if o.ObservedAt != nil {
+		obs.ObservedAt = *o.ObservedAt
+	}
+
+	return obs
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chrissnell/weather-bar/internal/noaa"
+)
+
+func TestExecFetchObservation(t *testing.T) {
+	site := WeatherSite{Point: noaa.Point{Latitude: 39.1836, Longitude: -96.5717}}
+
+	tests := []struct {
+		name    string
+		command string
+		check   func(obs CurrentObservation) bool
+		err     string
+	}{
+		{
+			name:    "observation",
+			command: `echo '{"temperature": 71.5, "humidity": 40, "observed-at": "2024-03-14T18:45:00Z"}'`,
+			check: func(obs CurrentObservation) bool {
+				return obs.Temperature == 71.5 && obs.Humidity == 40 && !obs.Has(FieldDewpoint) &&
+					obs.StationID == "shed" && obs.ObservedAt.Equal(time.Date(2024, 3, 14, 18, 45, 0, 0, time.UTC))
+			},
+		},
+		{
+			name:    "location in the environment",
+			command: `echo "{\"station-id\": \"$WEATHER_BAR_LATITUDE,$WEATHER_BAR_LONGITUDE\"}"`,
+			check: func(obs CurrentObservation) bool {
+				return obs.StationID == "39.1836,-96.5717" && !obs.ObservedAt.IsZero()
+			},
+		},
+		{
+			name:    "unknown key",
+			command: `echo '{"temprature": 71.5}'`,
+			err:     `exec provider command printed an invalid observation: json: unknown field "temprature"`,
+		},
+		{
+			name:    "not JSON",
+			command: `echo 'temperature 71.5'`,
+			err:     "exec provider command printed an invalid observation: invalid character 'e' in literal true (expecting 'r')",
+		},
+		{
+			name:    "failure",
+			command: `echo reading sensor >&2; echo 'no sensor on /dev/ttyUSB0' >&2; exit 3`,
+			err:     "exec provider command failed: exit status 3 (no sensor on /dev/ttyUSB0)",
+		},
+		{
+			name:    "silent failure",
+			command: `exit 1`,
+			err:     "exec provider command failed: exit status 1",
+		},
+	}
+
+	for _, tt := range tests {
+		p := &ExecProvider{command: tt.command, timeout: 10 * time.Second, stationID: "shed"}
+		obs, err := p.FetchObservation(context.Background(), site)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%v: error = %v, want %v", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tt.name, err)
+			continue
+		}
+		if !tt.check(obs) {
+			t.Errorf("%v: wrong observation %+v", tt.name, obs)
+		}
+	}
+}
+
+func TestExecTimeout(t *testing.T) {
+	// The background sleep keeps the command's output open after the shell is
+	// killed, so we also find out whether we wait for it
+	p := &ExecProvider{command: "sleep 30 & wait", timeout: 100 * time.Millisecond}
+
+	start := time.Now()
+	_, err := p.FetchObservation(context.Background(), WeatherSite{})
+	if err == nil || err.Error() != "exec provider command timed out after 100ms" {
+		t.Errorf("error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("timing out took %v", elapsed)
+	}
+}
+
+func TestExecNeedsSite(t *testing.T) {
+	debug := false
+
+	tests := []struct {
+		command  string
+		location string
+		want     bool
+	}{
+		{"read-serial-logger --json", "", false},
+		{"forecast-script --lat $WEATHER_BAR_LATITUDE --lon $WEATHER_BAR_LONGITUDE", "", true},
+		{"forecast-script", "true", true},
+		{"forecast-script $WEATHER_BAR_LATITUDE", "false", false},
+	}
+
+	for _, tt := range tests {
+		w := &WeatherBar{cfg: &Config{Weather: WeatherConfig{ExecCommand: tt.command, ExecLocation: tt.location}}, debug: &debug}
+		p, err := newExecProvider(w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := needsSite(p); got != tt.want {
+			t.Errorf("%q with exec-location %q: needs site = %v, want %v", tt.command, tt.location, got, tt.want)
+		}
+	}
+
+	w := &WeatherBar{cfg: &Config{Weather: WeatherConfig{ExecCommand: "true", ExecLocation: "sometimes"}}, debug: &debug}
+	_, err := newExecProvider(w)
+	if err == nil || !strings.Contains(err.Error(), "exec-location") {
+		t.Errorf("invalid exec-location gave error %v", err)
+	}
+}
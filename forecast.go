@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/chrissnell/weather-bar/internal/noaa"
+)
+
+// forecastTokens are the weather-format tokens filled in from the forecast.  We
+// only fetch the forecast if the format uses one of them.
+var forecastTokens = []string{
+	"%today-high%",
+	"%today-low%",
+	"%tonight-pop%",
+	"%tomorrow-summary%",
+}
+
+// ForecastSummary holds the parts of the forecast that we display.  The Has
+// fields are false when the forecast doesn't cover that part of the day.
+type ForecastSummary struct {
+	TodayHigh       float64
+	HasTodayHigh    bool
+	TodayLow        float64
+	HasTodayLow     bool
+	TonightPOP      float64
+	HasTonightPOP   bool
+	TomorrowSummary string
+
+	// highDate is the date that TodayHigh is for, in the forecast's time zone
+	highDate string
+}
+
+// usesForecast returns true if the format has any forecast tokens in it
+func usesForecast(format string) bool {
+	for _, token := range forecastTokens {
+		if strings.Contains(format, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// forecastWatcher fetches NOAA's forecast for our location on its own schedule,
+// and again whenever we move
+func (w *WeatherBar) forecastWatcher(ctx context.Context, moved <-chan struct{}) {
+	ticker := time.NewTicker(forecastUpdateInterval)
+	defer ticker.Stop()
+
+	point, ok := w.waitForPoint(ctx)
+	if !ok {
+		return
+	}
+
+	for {
+		err := w.updateForecast(point)
+		if err != nil {
+			log.Println(err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-moved:
+		case <-ctx.Done():
+			log.Println("Termination request recieved.  Cancelling forecast watcher.")
+			return
+		}
+		point = w.currentSite().Point
+	}
+}
+
+// updateForecast fetches the forecast for the point and redraws the bar with it
+func (w *WeatherBar) updateForecast(point noaa.Point) error {
+	if *w.debug {
+		log.Printf("Fetching forecast for %.4f/%.4f from NOAA...\n", point.Latitude, point.Longitude)
+	}
+
+	f := point.Forecast(2)
+	if f.Length == 0 {
+		return fmt.Errorf("unable to fetch forecast for %.4f/%.4f", point.Latitude, point.Longitude)
+	}
+
+	w.forecastMutex.Lock()
+	w.forecast = summarizeForecast(f, time.Now(), w.forecast)
+	if *w.debug {
+		log.Printf("Current forecast: %+v\n", w.forecast)
+	}
+	w.forecastMutex.Unlock()
+
+	w.redraw()
+	return nil
+}
+
+// currentForecast returns the latest forecast summary
+func (w *WeatherBar) currentForecast() ForecastSummary {
+	w.forecastMutex.RLock()
+	defer w.forecastMutex.RUnlock()
+	return w.forecast
+}
+
+// summarizeForecast picks out the values we display from a forecast.  Once
+// evening comes, NOAA's forecast starts with tonight and today's high drops out
+// of it, so we keep showing the high from the previous forecast if it was for today.
+func summarizeForecast(f *noaa.Forecast, now time.Time, prev ForecastSummary) ForecastSummary {
+	var s ForecastSummary
+	if len(f.ForecastDays) == 0 {
+		return s
+	}
+
+	first := f.ForecastDays[0]
+	loc := time.Local
+	if !first.StartTime.IsZero() {
+		loc = first.StartTime.Location()
+	}
+	today := now.In(loc).Format("2006-01-02")
+
+	// The first night in the forecast is always tonight
+	s.TodayLow = first.MinTemperature
+	s.HasTodayLow = true
+	s.TonightPOP = first.PrecipitationChanceNight
+	s.HasTonightPOP = true
+
+	for _, d := range f.ForecastDays {
+		if d.StartTime.IsZero() {
+			continue
+		}
+		date := d.StartTime.Format("2006-01-02")
+		switch {
+		case date == today:
+			s.TodayHigh = d.MaxTemperature
+			s.HasTodayHigh = true
+			s.highDate = date
+		case date > today && s.TomorrowSummary == "":
+			s.TomorrowSummary = d.SummaryDay["summary"]
+		}
+	}
+
+	if !s.HasTodayHigh && prev.HasTodayHigh && prev.highDate == today {
+		s.TodayHigh = prev.TodayHigh
+		s.HasTodayHigh = true
+		s.highDate = prev.highDate
+	}
+
+	return s
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chrissnell/weather-bar/internal/noaa"
+)
+
+func TestSummarizeForecast(t *testing.T) {
+	// NOAA gives the periods' times with a fixed UTC offset
+	cdt := time.FixedZone("", -5*60*60)
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 6, 0, 0, 0, cdt) }
+	forecastDay := func(start time.Time, high, low, pop float64, summary string) noaa.ForecastDay {
+		return noaa.ForecastDay{
+			StartTime:                start,
+			MaxTemperature:           high,
+			MinTemperature:           low,
+			PrecipitationChanceNight: pop,
+			SummaryDay:               map[string]string{"summary": summary},
+		}
+	}
+
+	morning := &noaa.Forecast{ForecastDays: []noaa.ForecastDay{
+		forecastDay(day(14), 72, 50, 30, "Sunny"),
+		forecastDay(day(15), 75, 48, 20, "Partly Sunny"),
+	}}
+	// In the evening, the forecast starts with tonight and the first day is
+	// tomorrow
+	evening := &noaa.Forecast{ForecastDays: []noaa.ForecastDay{
+		forecastDay(day(15), 75, 48, 20, "Partly Sunny"),
+		forecastDay(day(16), 68, 45, 60, "Chance Showers"),
+	}}
+
+	thisMorning := time.Date(2024, 3, 14, 8, 0, 0, 0, cdt)
+	thisEvening := time.Date(2024, 3, 14, 19, 0, 0, 0, cdt)
+	tomorrowEvening := time.Date(2024, 3, 15, 19, 0, 0, 0, cdt)
+	fromThisMorning := summarizeForecast(morning, thisMorning, ForecastSummary{})
+
+	tests := []struct {
+		name     string
+		forecast *noaa.Forecast
+		now      time.Time
+		prev     ForecastSummary
+		want     ForecastSummary
+	}{
+		{
+			name:     "morning",
+			forecast: morning,
+			now:      thisMorning,
+			want:     ForecastSummary{TodayHigh: 72, HasTodayHigh: true, TodayLow: 50, HasTodayLow: true, TonightPOP: 30, HasTonightPOP: true, TomorrowSummary: "Partly Sunny"},
+		},
+		{
+			name:     "evening keeps today's high",
+			forecast: evening,
+			now:      thisEvening,
+			prev:     fromThisMorning,
+			want:     ForecastSummary{TodayHigh: 72, HasTodayHigh: true, TodayLow: 48, HasTodayLow: true, TonightPOP: 20, HasTonightPOP: true, TomorrowSummary: "Partly Sunny"},
+		},
+		{
+			name:     "evening after a restart",
+			forecast: evening,
+			now:      thisEvening,
+			want:     ForecastSummary{TodayLow: 48, HasTodayLow: true, TonightPOP: 20, HasTonightPOP: true, TomorrowSummary: "Partly Sunny"},
+		},
+		{
+			// Yesterday's high is no use today
+			name:     "stale high",
+			forecast: &noaa.Forecast{ForecastDays: []noaa.ForecastDay{forecastDay(day(16), 68, 45, 60, "Chance Showers")}},
+			now:      tomorrowEvening,
+			prev:     fromThisMorning,
+			want:     ForecastSummary{TodayLow: 45, HasTodayLow: true, TonightPOP: 60, HasTonightPOP: true, TomorrowSummary: "Chance Showers"},
+		},
+		{
+			name: "days without times",
+			forecast: &noaa.Forecast{ForecastDays: []noaa.ForecastDay{
+				forecastDay(time.Time{}, 72, 50, 30, "Sunny"),
+				forecastDay(time.Time{}, 75, 48, 20, "Partly Sunny"),
+			}},
+			now:  thisMorning,
+			want: ForecastSummary{TodayLow: 50, HasTodayLow: true, TonightPOP: 30, HasTonightPOP: true},
+		},
+		{
+			name:     "empty",
+			forecast: &noaa.Forecast{},
+			now:      thisMorning,
+			prev:     fromThisMorning,
+		},
+	}
+
+	for _, tt := range tests {
+		got := summarizeForecast(tt.forecast, tt.now, tt.prev)
+		// highDate is bookkeeping that the cases leave out
+		got.highDate = ""
+		if got != tt.want {
+			t.Errorf("%v: summary = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
+
+	"github.com/chrissnell/weather-bar/internal/noaa"
 )
 
 func (w *WeatherBar) getLocationFromFreeGEOIP() (err error) {
@@ -32,31 +36,46 @@ func (w *WeatherBar) getLocationFromFreeGEOIP() (err error) {
 	return nil
 }
 
-// Not currently used.  We're using our NOAA database and Haversine distance to
-// find the nearest station instead.
-func (w *WeatherBar) findNearestICAOStationFromWU() (icao string, err error) {
-	var geo GeoLookup
+// getLocationFromConfig sets our point from the latitude and longitude in the config
+// file.  It returns false if the user didn't hard-code a location.
+func (w *WeatherBar) getLocationFromConfig() (bool, error) {
+	if w.cfg.Weather.Latitude == "" || w.cfg.Weather.Longitude == "" {
+		return false, nil
+	}
 
-	w.pointMutex.RLock()
-	lat := strconv.FormatFloat(w.point.Latitude, 'f', 6, 64)
-	lon := strconv.FormatFloat(w.point.Longitude, 'f', 6, 64)
-	w.pointMutex.RUnlock()
+	lat, err := strconv.ParseFloat(w.cfg.Weather.Latitude, 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return false, fmt.Errorf("latitude must be between -90 and 90: %v", w.cfg.Weather.Latitude)
+	}
+	lon, err := strconv.ParseFloat(w.cfg.Weather.Longitude, 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return false, fmt.Errorf("longitude must be between -180 and 180: %v", w.cfg.Weather.Longitude)
+	}
 
-	wuURL := wgAPIBaseURL + w.cfg.Weather.WUAPIKey + "/geolookup/q/" + lat + "," + lon + ".json"
+	w.pointMutex.Lock()
+	w.point.Latitude = lat
+	w.point.Longitude = lon
+	w.pointMutex.Unlock()
 
-	var c = &http.Client{Timeout: 10 * time.Second}
-	r, err := c.Get(wuURL)
-	if err != nil {
-		return "", err
-	}
-	defer r.Body.Close()
+	w.locMutex.Lock()
+	w.loc.Latitude = lat
+	w.loc.Longitude = lon
+	w.locMutex.Unlock()
 
-	// w.locMutex.Lock()
-	err = json.NewDecoder(r.Body).Decode(&geo)
-	// w.locMutex.Unlock()
-	if err != nil {
-		return "", err
-	}
+	return true, nil
+}
 
-	return geo.Location.NearbyStations.Airport.Station[0].ICAO, nil
+// waitForPoint waits for geolocation, or the config file, to give us a point.  It
+// returns false if ctx is cancelled first.
+func (w *WeatherBar) waitForPoint(ctx context.Context) (noaa.Point, bool) {
+	point := w.currentSite().Point
+	for point.Latitude == 0 && point.Longitude == 0 {
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return point, false
+		}
+		point = w.currentSite().Point
+	}
+	return point, true
 }
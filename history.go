@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// We keep this many entries of history, which at one a minute is enough for the
+// three-hour pressure tendency with room to spare
+const historyCapacity = 360
+
+// Observations that arrive within this long of the latest entry are folded into
+// it, so that providers that report every few seconds don't crowd out the history
+const historyResolution = time.Minute
+
+// The pressure has to change by at least this many millibars over three hours to
+// count as rising or falling
+const pressureSteadyThreshold = 1.0
+
+// How far back each history token looks, and how far from that time the entry
+// that it compares against may be
+const (
+	pressureTrendPeriod        = 3 * time.Hour
+	pressureTrendTolerance     = 30 * time.Minute
+	temperatureChangePeriod    = 1 * time.Hour
+	temperatureChangeTolerance = 10 * time.Minute
+	peakGustPeriod             = 10 * time.Minute
+)
+
+// historyTokens are the weather-format tokens filled in from the history.  We only
+// keep a history if the format uses one of them.
+var historyTokens = []string{
+	"%pressure-trend%",
+	"%pressure-change%",
+	"%temperature-change-fahrenheit%",
+	"%temperature-change-celcius%",
+	"%peak-gust-mph%",
+	"%peak-gust-kph%",
+}
+
+// historyEntry is what we remember of an observation.  WindGust is the strongest
+// gust of the observations folded into the entry.  The Has flags say which values
+// the observations actually reported.  We only ever compare entries from the same
+// station, since two stations' barometers rarely agree.
+type historyEntry struct {
+	Time           time.Time `json:"time"`
+	StationID      string    `json:"station"`
+	Temperature    float64   `json:"temperature"`
+	Barometer      float64   `json:"barometer"`
+	WindGust       float64   `json:"wind-gust"`
+	HasTemperature bool      `json:"has-temperature"`
+	HasBarometer   bool      `json:"has-barometer"`
+	HasGust        bool      `json:"has-wind-gust"`
+}
+
+// ObservationHistory is a ring buffer of recent observations, saved to disk so
+// that it survives restarts.  A history with no path is only kept in memory.
+// It's only used by the reporter, so it isn't safe for concurrent use.
+type ObservationHistory struct {
+	path    string
+	entries []historyEntry
+	start   int
+	count   int
+}
+
+// defaultHistoryFile returns where we keep the history unless the config file
+// says otherwise
+func defaultHistoryFile() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "weather-bar", "history.json"), nil
+}
+
+// usesHistory returns true if the format has any history tokens in it
+func usesHistory(format string) bool {
+	for _, token := range historyTokens {
+		if strings.Contains(format, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// newObservationHistory returns a history saved to the given file, loading
+// whatever the file already has in it
+func newObservationHistory(path string) (*ObservationHistory, error) {
+	h := &ObservationHistory{
+		path:    path,
+		entries: make([]historyEntry, historyCapacity),
+	}
+	if path == "" {
+		return h, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return h, err
+	}
+
+	var saved []historyEntry
+	err = json.Unmarshal(b, &saved)
+	if err != nil {
+		return h, fmt.Errorf("invalid history file %v: %v", path, err)
+	}
+	for _, e := range saved {
+		h.push(e)
+	}
+	return h, nil
+}
+
+// at returns the ith entry, oldest first
+func (h *ObservationHistory) at(i int) historyEntry {
+	return h.entries[(h.start+i)%len(h.entries)]
+}
+
+// push appends an entry, dropping the oldest one if the buffer is full
+func (h *ObservationHistory) push(e historyEntry) {
+	if h.count < len(h.entries) {
+		h.entries[(h.start+h.count)%len(h.entries)] = e
+		h.count++
+		return
+	}
+	h.entries[h.start] = e
+	h.start = (h.start + 1) % len(h.entries)
+}
+
+// latest returns the newest entry
+func (h *ObservationHistory) latest() (historyEntry, bool) {
+	if h.count == 0 {
+		return historyEntry{}, false
+	}
+	return h.at(h.count - 1), true
+}
+
+// add records an observation and saves the history.  The observation's time is
+// when it was observed, if the provider told us, or now.
+func (h *ObservationHistory) add(obs CurrentObservation, now time.Time) error {
+	t := obs.ObservedAt
+	if t.IsZero() {
+		t = now
+	}
+
+	last, ok := h.latest()
+	switch {
+	case ok && !t.After(last.Time):
+		// Providers that update slowly hand us the same observation again
+		return nil
+	case ok && t.Sub(last.Time) < historyResolution && obs.StationID == last.StationID:
+		// The entry keeps its own time, so that a provider that reports often still
+		// starts a new entry every historyResolution.  Values that this observation
+		// is missing keep what the entry already had.
+		if obs.Has(FieldTemperature) {
+			last.Temperature = obs.Temperature
+			last.HasTemperature = true
+		}
+		if obs.Has(FieldBarometer) {
+			last.Barometer = obs.Barometer
+			last.HasBarometer = true
+		}
+		if obs.Has(FieldWindGust) {
+			last.WindGust = math.Max(last.WindGust, obs.WindGust)
+			last.HasGust = true
+		}
+		h.entries[(h.start+h.count-1)%len(h.entries)] = last
+		return h.save()
+	}
+
+	h.push(historyEntry{
+		Time:           t,
+		StationID:      obs.StationID,
+		Temperature:    obs.Temperature,
+		Barometer:      obs.Barometer,
+		WindGust:       obs.WindGust,
+		HasTemperature: obs.Has(FieldTemperature),
+		HasBarometer:   obs.Has(FieldBarometer),
+		HasGust:        obs.Has(FieldWindGust),
+	})
+	return h.save()
+}
+
+// save writes the history to its file, oldest entry first.  We write to a
+// temporary file first so that a crash can't leave half a history behind.
+func (h *ObservationHistory) save() error {
+	if h.path == "" {
+		return nil
+	}
+
+	entries := make([]historyEntry, h.count)
+	for i := range entries {
+		entries[i] = h.at(i)
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(h.path), 0755)
+	if err != nil {
+		return err
+	}
+	tmp := h.path + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.path)
+}
+
+// before returns the entry nearest to period before the latest entry, if there's
+// one from the same station within tolerance of that time that has the value we
+// want
+func (h *ObservationHistory) before(period, tolerance time.Duration, has func(historyEntry) bool) (historyEntry, bool) {
+	last, ok := h.latest()
+	if !ok {
+		return historyEntry{}, false
+	}
+	target := last.Time.Add(-period)
+
+	var nearest historyEntry
+	found := false
+	for i := 0; i < h.count-1; i++ {
+		e := h.at(i)
+		if e.StationID != last.StationID || !has(e) {
+			continue
+		}
+		d := absDuration(e.Time.Sub(target))
+		if d <= tolerance && (!found || d < absDuration(nearest.Time.Sub(target))) {
+			nearest = e
+			found = true
+		}
+	}
+	return nearest, found
+}
+
+// PressureChange returns how much the barometer has changed over the last three
+// hours, in millibars
+func (h *ObservationHistory) PressureChange() (float64, bool) {
+	hasBarometer := func(e historyEntry) bool { return e.HasBarometer }
+
+	last, ok := h.latest()
+	if !ok || !hasBarometer(last) {
+		return 0, false
+	}
+	past, ok := h.before(pressureTrendPeriod, pressureTrendTolerance, hasBarometer)
+	if !ok {
+		return 0, false
+	}
+	return last.Barometer - past.Barometer, true
+}
+
+// PressureTrend describes the three-hour pressure tendency, e.g. "Rising ↑".  It's
+// blank until we have three hours of history.
+func (h *ObservationHistory) PressureTrend() string {
+	change, ok := h.PressureChange()
+	switch {
+	case !ok:
+		return ""
+	case change >= pressureSteadyThreshold:
+		return "Rising ↑"
+	case change <= -pressureSteadyThreshold:
+		return "Falling ↓"
+	}
+	return "Steady →"
+}
+
+// TemperatureChange returns how much the temperature has changed over the last
+// hour, in degrees Fahrenheit
+func (h *ObservationHistory) TemperatureChange() (float64, bool) {
+	hasTemperature := func(e historyEntry) bool { return e.HasTemperature }
+
+	last, ok := h.latest()
+	if !ok || !hasTemperature(last) {
+		return 0, false
+	}
+	past, ok := h.before(temperatureChangePeriod, temperatureChangeTolerance, hasTemperature)
+	if !ok {
+		return 0, false
+	}
+	return last.Temperature - past.Temperature, true
+}
+
+// PeakGust returns the strongest gust that the latest observation's station has
+// reported in the ten minutes up to it, in miles/hour
+func (h *ObservationHistory) PeakGust() (float64, bool) {
+	last, ok := h.latest()
+	if !ok {
+		return 0, false
+	}
+
+	peak := 0.0
+	found := false
+	for i := h.count - 1; i >= 0; i-- {
+		e := h.at(i)
+		if last.Time.Sub(e.Time) > peakGustPeriod {
+			break
+		}
+		if e.StationID != last.StationID || !e.HasGust {
+			continue
+		}
+		peak = math.Max(peak, e.WindGust)
+		found = true
+	}
+	return peak, found
+}
+
+// absDuration returns the absolute value of a duration
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// historyObservation is an observation from a station at a time
+func historyObservation(station string, t time.Time, temp, barometer, gust float64) CurrentObservation {
+	obs := observation(map[ObservationField]float64{FieldTemperature: temp, FieldBarometer: barometer, FieldWindGust: gust})
+	obs.setText(FieldStationID, station)
+	obs.ObservedAt = t
+	return obs
+}
+
+func TestObservationHistoryStations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+	h, err := newObservationHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Three hours of a falling barometer at KMHK, with another station's
+	// reports mixed in
+	start := time.Date(2024, 3, 14, 12, 0, 0, 0, time.UTC)
+	for m := 0; m <= 180; m += 10 {
+		now := start.Add(time.Duration(m) * time.Minute)
+		h.add(historyObservation("KMHK", now, 50+float64(m)/30, 1015-float64(m)/60, 10), now)
+		h.add(historyObservation("KFRI", now.Add(30*time.Second), 40, 1030, 40), now.Add(30*time.Second))
+	}
+
+	// The latest entry is KFRI's, which has had a steady barometer
+	if got := h.PressureTrend(); got != "Steady →" {
+		t.Errorf("KFRI pressure trend = %q, want Steady →", got)
+	}
+	if got, ok := h.PeakGust(); !ok || got != 40 {
+		t.Errorf("KFRI peak gust = %v, %v, want 40", got, ok)
+	}
+
+	end := start.Add(181 * time.Minute)
+	err = h.add(historyObservation("KMHK", end, 56, 1012, 15), end)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopening the file gives us the same history back
+	h, err = newObservationHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if change, ok := h.PressureChange(); !ok || change != -3 {
+		t.Errorf("KMHK pressure change = %v, %v, want -3", change, ok)
+	}
+	if got := h.PressureTrend(); got != "Falling ↓" {
+		t.Errorf("KMHK pressure trend = %q, want Falling ↓", got)
+	}
+	if change, ok := h.TemperatureChange(); !ok || change != 2 {
+		t.Errorf("KMHK temperature change = %v, %v, want 2", change, ok)
+	}
+	if got, ok := h.PeakGust(); !ok || got != 15 {
+		t.Errorf("KMHK peak gust = %v, %v, want 15 without KFRI's gusts", got, ok)
+	}
+
+	// A gust folded into the latest entry is saved too
+	err = h.add(historyObservation("KMHK", end.Add(20*time.Second), 56, 1012, 25), end.Add(20*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err = newObservationHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := h.PeakGust(); !ok || got != 25 {
+		t.Errorf("KMHK peak gust after reopening = %v, %v, want 25", got, ok)
+	}
+
+	// A station with no history of its own has no trends yet
+	h.add(historyObservation("KTOP", end.Add(time.Minute), 60, 1010, 5), end.Add(time.Minute))
+	if change, ok := h.PressureChange(); ok {
+		t.Errorf("KTOP pressure change = %v, want none", change)
+	}
+}
+
+func TestObservationHistoryFolding(t *testing.T) {
+	h, err := newObservationHistory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 3, 14, 12, 0, 0, 0, time.UTC)
+	for s := 0; s < 150; s += 5 {
+		now := start.Add(time.Duration(s) * time.Second)
+		h.add(historyObservation("KMHK", now, 50, 1015, float64(s%60)), now)
+	}
+
+	// Reports every five seconds fold into one entry a minute, each keeping its
+	// strongest gust
+	if h.count != 3 {
+		t.Fatalf("history has %v entries, want 3", h.count)
+	}
+	for i, want := range []float64{55, 55, 25} {
+		if e := h.at(i); e.WindGust != want || !e.Time.Equal(start.Add(time.Duration(i)*time.Minute)) {
+			t.Errorf("entry %v: gust %v at %v, want %v at %v", i, e.WindGust, e.Time, want, start.Add(time.Duration(i)*time.Minute))
+		}
+	}
+}
+
+func TestObservationHistoryMissingValues(t *testing.T) {
+	h, err := newObservationHistory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An hour of a station that reports a temperature, which is sometimes zero,
+	// but no barometer or gusts
+	start := time.Date(2024, 1, 14, 12, 0, 0, 0, time.UTC)
+	for m := 0; m <= 60; m += 10 {
+		now := start.Add(time.Duration(m) * time.Minute)
+		obs := observation(map[ObservationField]float64{FieldTemperature: float64(m) / 10})
+		obs.setText(FieldStationID, "shed")
+		obs.ObservedAt = now
+		h.add(obs, now)
+	}
+
+	if change, ok := h.TemperatureChange(); !ok || change != 6 {
+		t.Errorf("temperature change = %v, %v, want 6 since 0°F", change, ok)
+	}
+	if change, ok := h.PressureChange(); ok {
+		t.Errorf("pressure change = %v without a barometer, want none", change)
+	}
+	if gust, ok := h.PeakGust(); ok {
+		t.Errorf("peak gust = %v without gusts, want none", gust)
+	}
+
+	// A report without a temperature folds in without losing the one we have
+	end := start.Add(60*time.Minute + 30*time.Second)
+	obs := observation(map[ObservationField]float64{FieldWindGust: 12})
+	obs.setText(FieldStationID, "shed")
+	obs.ObservedAt = end
+	h.add(obs, end)
+
+	if h.count != 7 {
+		t.Fatalf("history has %v entries, want 7", h.count)
+	}
+	if change, ok := h.TemperatureChange(); !ok || change != 6 {
+		t.Errorf("after folding, temperature change = %v, %v, want 6", change, ok)
+	}
+	if gust, ok := h.PeakGust(); !ok || gust != 12 {
+		t.Errorf("after folding, peak gust = %v, %v, want 12", gust, ok)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chrissnell/weather-bar/internal/noaa"
+)
+
+// We fetch this many hours of hourly forecast, which is as far ahead as the
+// hourly tokens can look
+const hourlyForecastHours = 48
+
+// sparkBlocks are the characters of a sparkline, from lowest to highest
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// hourlyTokenRegexp matches the hourly forecast tokens, e.g. %temp-sparkline-12h%
+// or %pop-max-6h%
+var hourlyTokenRegexp = regexp.MustCompile(`%(temp|pop)-(sparkline|max)-([0-9]+)h%`)
+
+// HourlyForecast is the hourly temperature and chance of precipitation for the
+// next couple of days.  Missing values are nil.
+type HourlyForecast struct {
+	Times       []time.Time
+	Temperature []*float64
+	POP         []*float64
+}
+
+// OpenMeteoHourly encapsulates an Open-Meteo forecast API response with hourly data
+type OpenMeteoHourly struct {
+	Timezone  string `json:"timezone"`
+	UTCOffset int    `json:"utc_offset_seconds"`
+	Hourly    struct {
+		Time                     []string   `json:"time"`
+		Temperature              []*float64 `json:"temperature_2m"`
+		PrecipitationProbability []*float64 `json:"precipitation_probability"`
+	} `json:"hourly"`
+}
+
+// usesHourlyForecast returns true if the format has any hourly forecast tokens in it
+func usesHourlyForecast(format string) bool {
+	return hourlyTokenRegexp.MatchString(format)
+}
+
+// hourlyForecastWatcher fetches the hourly forecast for our location on its own
+// schedule, and again whenever we move
+func (w *WeatherBar) hourlyForecastWatcher(ctx context.Context, moved <-chan struct{}) {
+	ticker := time.NewTicker(hourlyForecastUpdateInterval)
+	defer ticker.Stop()
+
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	point, ok := w.waitForPoint(ctx)
+	if !ok {
+		return
+	}
+
+	for {
+		err := w.updateHourlyForecast(ctx, client, point)
+		if err != nil {
+			log.Println(err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-moved:
+		case <-ctx.Done():
+			log.Println("Termination request recieved.  Cancelling hourly forecast watcher.")
+			return
+		}
+		point = w.currentSite().Point
+	}
+}
+
+// updateHourlyForecast fetches the hourly forecast for the point and redraws the bar with it
+func (w *WeatherBar) updateHourlyForecast(ctx context.Context, client *http.Client, point noaa.Point) error {
+	if *w.debug {
+		log.Printf("Fetching hourly forecast for %.4f,%.4f from Open-Meteo...\n", point.Latitude, point.Longitude)
+	}
+
+	h, err := fetchOpenMeteoHourly(ctx, client, openMeteoAPIBaseURL, point)
+	if err != nil {
+		return fmt.Errorf("unable to fetch hourly forecast: %v", err)
+	}
+
+	w.hourlyMutex.Lock()
+	w.hourly = h
+	w.hourlyMutex.Unlock()
+
+	w.redraw()
+	return nil
+}
+
+// currentHourlyForecast returns the latest hourly forecast
+func (w *WeatherBar) currentHourlyForecast() HourlyForecast {
+	w.hourlyMutex.RLock()
+	defer w.hourlyMutex.RUnlock()
+	return w.hourly
+}
+
+// fetchOpenMeteoHourly fetches the hourly forecast for a point from Open-Meteo
+func fetchOpenMeteoHourly(ctx context.Context, client *http.Client, baseURL string, point noaa.Point) (HourlyForecast, error) {
+	q := url.Values{}
+	q.Set("latitude", strconv.FormatFloat(point.Latitude, 'f', 4, 64))
+	q.Set("longitude", strconv.FormatFloat(point.Longitude, 'f', 4, 64))
+	q.Set("hourly", "temperature_2m,precipitation_probability")
+	q.Set("forecast_hours", strconv.Itoa(hourlyForecastHours))
+	q.Set("temperature_unit", "fahrenheit")
+	q.Set("timezone", "auto")
+
+	var om OpenMeteoHourly
+	err := openMeteoGet(ctx, client, baseURL+"/v1/forecast?"+q.Encode(), &om)
+	if err != nil {
+		return HourlyForecast{}, err
+	}
+
+	return om.toHourlyForecast(), nil
+}
+
+// toHourlyForecast maps the Open-Meteo response into an HourlyForecast
+func (om OpenMeteoHourly) toHourlyForecast() HourlyForecast {
+	var h HourlyForecast
+
+	// Times are local to the grid point
+	zone := openMeteoLocation(om.Timezone, om.UTCOffset)
+	for i, ts := range om.Hourly.Time {
+		t, err := time.ParseInLocation(openMeteoTimeFormat, ts, zone)
+		if err != nil {
+			continue
+		}
+		h.Times = append(h.Times, t)
+		h.Temperature = append(h.Temperature, hourlyValue(om.Hourly.Temperature, i))
+		h.POP = append(h.POP, hourlyValue(om.Hourly.PrecipitationProbability, i))
+	}
+
+	return h
+}
+
+// hourlyValue returns the ith value of an hourly series, or nil if it's missing
+func hourlyValue(values []*float64, i int) *float64 {
+	if i >= len(values) {
+		return nil
+	}
+	return values[i]
+}
+
+// next returns the values for the given number of hours, starting with the
+// current hour.  The forecast may not reach that far.
+func (h HourlyForecast) next(series []*float64, hours int, now time.Time) []*float64 {
+	thisHour := now.Truncate(time.Hour)
+
+	var values []*float64
+	for i, t := range h.Times {
+		if t.Before(thisHour) {
+			continue
+		}
+		if len(values) == hours {
+			break
+		}
+		values = append(values, hourlyValue(series, i))
+	}
+	return values
+}
+
+// token returns the text for an hourly forecast token
+func (h HourlyForecast) token(token string, now time.Time) string {
+	m := hourlyTokenRegexp.FindStringSubmatch(token)
+	if m == nil {
+		return ""
+	}
+	hours, err := strconv.Atoi(m[3])
+	if err != nil || hours < 1 {
+		return ""
+	}
+
+	series := h.Temperature
+	if m[1] == "pop" {
+		series = h.POP
+	}
+	values := h.next(series, hours, now)
+
+	if m[2] == "max" {
+		max, ok := maxValue(values)
+		return formatOptional("%.0f", max, ok)
+	}
+	if m[1] == "pop" {
+		// Chances of precipitation always use the same scale, so that a
+		// sparkline of 10% chances doesn't look like a sure thing
+		return sparkline(values, 0, 100)
+	}
+	min, _ := minValue(values)
+	max, _ := maxValue(values)
+	return sparkline(values, min, max)
+}
+
+// sparkline draws the values as block characters scaled between low and high.
+// Missing values are drawn as spaces.
+func sparkline(values []*float64, low, high float64) string {
+	var b strings.Builder
+	for _, v := range values {
+		if v == nil {
+			b.WriteRune(' ')
+			continue
+		}
+
+		level := len(sparkBlocks) / 2
+		if high > low {
+			level = int(math.Round((*v - low) / (high - low) * float64(len(sparkBlocks)-1)))
+		}
+		if level < 0 {
+			level = 0
+		}
+		if level >= len(sparkBlocks) {
+			level = len(sparkBlocks) - 1
+		}
+		b.WriteRune(sparkBlocks[level])
+	}
+	return b.String()
+}
+
+// maxValue returns the largest of the values that we have
+func maxValue(values []*float64) (float64, bool) {
+	max, ok := 0.0, false
+	for _, v := range values {
+		if v != nil && (!ok || *v > max) {
+			max, ok = *v, true
+		}
+	}
+	return max, ok
+}
+
+// minValue returns the smallest of the values that we have
+func minValue(values []*float64) (float64, bool) {
+	min, ok := 0.0, false
+	for _, v := range values {
+		if v != nil && (!ok || *v < min) {
+			min, ok = *v, true
+		}
+	}
+	return min, ok
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// hourlySeries builds an hourly series, with NaN standing in for a missing value
+func hourlySeries(values ...float64) []*float64 {
+	series := make([]*float64, len(values))
+	for i := range values {
+		if !math.IsNaN(values[i]) {
+			series[i] = &values[i]
+		}
+	}
+	return series
+}
+
+func TestSparkline(t *testing.T) {
+	nan := math.NaN()
+
+	tests := []struct {
+		name      string
+		values    []*float64
+		low, high float64
+		want      string
+	}{
+		{"empty", nil, 0, 100, ""},
+		{"every level", hourlySeries(0, 1, 2, 3, 4, 5, 6, 7), 0, 7, "▁▂▃▄▅▆▇█"},
+		{"missing values", hourlySeries(nan, 50, nan), 0, 100, " ▅ "},
+		{"flat", hourlySeries(60, 60, 60), 60, 60, "▅▅▅"},
+		{"out of range", hourlySeries(-10, 110), 0, 100, "▁█"},
+	}
+
+	for _, tt := range tests {
+		if got := sparkline(tt.values, tt.low, tt.high); got != tt.want {
+			t.Errorf("%v: sparkline = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHourlyForecastToken(t *testing.T) {
+	nan := math.NaN()
+	start := time.Date(2024, 3, 14, 10, 0, 0, 0, time.UTC)
+	h := HourlyForecast{
+		Temperature: hourlySeries(50, 52, 55, nan, 60, 58),
+		POP:         hourlySeries(0, 10, nan, 40, 100, 20),
+	}
+	for i := range h.Temperature {
+		h.Times = append(h.Times, start.Add(time.Duration(i)*time.Hour))
+	}
+	now := start.Add(90 * time.Minute)
+
+	tests := []struct {
+		token string
+		now   time.Time
+		want  string
+	}{
+		// The current hour is the first one, and the hour before is left out
+		{"%temp-max-3h%", now, "55"},
+		{"%temp-sparkline-4h%", now, "▁▄ █"},
+		// Chances of precipitation are always drawn from 0 to 100%
+		{"%pop-sparkline-3h%", now, "▂ ▄"},
+		// The forecast doesn't reach 48 hours ahead
+		{"%pop-max-48h%", now, "100"},
+		{"%temp-sparkline-48h%", now, "▁▄ █▆"},
+		// Nothing is left of the forecast
+		{"%temp-max-3h%", start.Add(6 * time.Hour), ""},
+		{"%pop-sparkline-3h%", start.Add(6 * time.Hour), ""},
+		{"%temp-max-0h%", now, ""},
+		{"%temp-min-3h%", now, ""},
+	}
+
+	for _, tt := range tests {
+		if got := h.token(tt.token, tt.now); got != tt.want {
+			t.Errorf("%v at %v = %q, want %q", tt.token, tt.now.Format("15:04"), got, tt.want)
+		}
+	}
+
+	// A forecast without chances of precipitation leaves them blank
+	h.POP = nil
+	if got := h.token("%pop-sparkline-3h%", now); got != "   " {
+		t.Errorf("%%pop-sparkline-3h%% without chances of precipitation = %q, want blanks", got)
+	}
+	if got := h.token("%pop-max-3h%", now); got != "" {
+		t.Errorf("%%pop-max-3h%% without chances of precipitation = %q, want blank", got)
+	}
+}
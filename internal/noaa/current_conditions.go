@@ -2,7 +2,6 @@ package noaa
 
 import (
 	"encoding/xml"
-	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
@@ -21,19 +20,45 @@ const (
 type CurrentCondition struct {
 	XMLName xml.Name `xml:"current_observation"`
 
+	Location              string  `xml:"location"`
 	StationId             string  `xml:"station_id"`
 	Latitude              float64 `xml:"latitude"`
 	Longitude             float64 `xml:"longitude"`
 	StringObservationTime string  `xml:"observation_time_rfc822"`
 	ObservationTime       time.Time
+	Weather               string  `xml:"weather"`
+	TemperatureString     string  `xml:"temperature_string"`
 	TemperatureF          float64 `xml:"temp_f"`
 	TemperatureC          float64 `xml:"temp_c"`
+	RelativeHumidity      float64 `xml:"relative_humidity"`
+	WindString            string  `xml:"wind_string"`
 	WindDirection         string  `xml:"wind_dir"`
 	WindDegrees           float64 `xml:"wind_degrees"`
 	WindMph               float64 `xml:"wind_mph"`
-	WindGustMph           float64 `xml:"wind_guest_mph"`
+	WindGustMph           float64 `xml:"wind_gust_mph"`
 	WindKt                float64 `xml:"wind_kt"`
+	WindGustKt            float64 `xml:"wind_gust_kt"`
+	PressureString        string  `xml:"pressure_string"`
 	PressureMB            float64 `xml:"pressure_mb"`
+	PressureIn            float64 `xml:"pressure_in"`
+	DewpointString        string  `xml:"dewpoint_string"`
+	DewpointF             float64 `xml:"dewpoint_f"`
+	DewpointC             float64 `xml:"dewpoint_c"`
+	HeatIndexString       string  `xml:"heat_index_string"`
+	HeatIndexF            float64 `xml:"heat_index_f"`
+	HeatIndexC            float64 `xml:"heat_index_c"`
+	WindchillString       string  `xml:"windchill_string"`
+	WindchillF            float64 `xml:"windchill_f"`
+	WindchillC            float64 `xml:"windchill_c"`
+	VisibilityMi          float64 `xml:"visibility_mi"`
+	Precip1hrString       string  `xml:"precip_1hr_string"`
+	Precip1hrIn           float64 `xml:"precip_1hr_in"`
+	PrecipTodayString     string  `xml:"precip_today_string"`
+	PrecipTodayIn         float64 `xml:"precip_today_in"`
+	IconURLBase           string  `xml:"icon_url_base"`
+	IconURLName           string  `xml:"icon_url_name"`
+	TwoDayHistoryURL      string  `xml:"two_day_history_url"`
+	ObURL                 string  `xml:"ob_url"`
 }
 
 // Retrieve the Current Conditions for a Station.
@@ -58,7 +83,6 @@ func (station *Station) CurrentConditions() *CurrentCondition {
 	// Manually parse the time
 	// encoding/xml doesn't properly encode to a time.Time type
 	c.ObservationTime, _ = time.Parse(conditionTime, c.StringObservationTime)
-	fmt.Println(c.ObservationTime)
 
 	return c
 }
@@ -69,15 +69,21 @@ type Condition struct {
 	Additive    string
 }
 
-// Xml Result Collections
-var forecastPoint = &XmlResultPoint{}
-var maxTemps = &XmlResultTemperature{}
-var minTemps = &XmlResultTemperature{}
-var precipitationChances = &XmlResultPrecipitationChance{}
-var weatherDetails = &XmlResultWeatherDetails{}
-var conditionIcons = &XmlResultConditionIcons{}
-var periodDay = &XmlResultPeriod{}
-var periodFull = &XmlResultPeriod{}
+// Xml Result Collections.  Each forecast gets its own, so that forecasts can be
+// fetched concurrently.
+type forecastResults struct {
+	forecastPoint        XmlResultPoint
+	maxTemps             XmlResultTemperature
+	minTemps             XmlResultTemperature
+	precipitationChances XmlResultPrecipitationChance
+	weatherDetails       XmlResultWeatherDetails
+	conditionIcons       XmlResultConditionIcons
+	periodDay            XmlResultPeriod
+	periodFull           XmlResultPeriod
+}
+
+// Forecasts are fetched with a timeout so that a stalled server can't hang the caller
+var forecastClient = &http.Client{Timeout: 30 * time.Second}
 
 // Returns a Forecast closest to a given Point
 func (p *Point) Forecast(daysRequested int) *Forecast {
@@ -93,9 +99,7 @@ func (p *Point) Forecast(daysRequested int) *Forecast {
 		url += "&" + option + "=" + option
 	}
 
-	fmt.Println("Fetching: " + url)
-
-	resp, err := http.Get(url)
+	resp, err := forecastClient.Get(url)
 
 	if err != nil {
 		// return an empty forecast
@@ -108,6 +112,9 @@ func (p *Point) Forecast(daysRequested int) *Forecast {
 	// The only con is that we have to covert this back to a Reader for Xml.NewDecode
 	body, err := ioutil.ReadAll(resp.Body)
 	resp.Body.Close()
+	if err != nil {
+		return &Forecast{}
+	}
 
 	// Forecast Struct
 	forecast := &Forecast{Length: daysRequested, ForecastDays: make([]ForecastDay, daysRequested)}
@@ -120,7 +127,15 @@ func (p *Point) Forecast(daysRequested int) *Forecast {
 // the given data and then assigns into ForecastDays and creates a Forecast
 func (f *Forecast) assignForecastData(body []byte) {
 	// Unmarshal the forecast xml file
-	unmarshalForecast(body)
+	r := unmarshalForecast(body)
+	forecastPoint := &r.forecastPoint
+	maxTemps := &r.maxTemps
+	minTemps := &r.minTemps
+	precipitationChances := &r.precipitationChances
+	weatherDetails := &r.weatherDetails
+	conditionIcons := &r.conditionIcons
+	periodDay := &r.periodDay
+	periodFull := &r.periodFull
 
 	// Point of actual forecast
 	f.Point.Latitude = forecastPoint.Latitude
@@ -129,6 +144,11 @@ func (f *Forecast) assignForecastData(body []byte) {
 	// Forecasts are in 12 hour periods
 	f.Length = len(periodDay.StartTimes)
 
+	// A response without the full set of periods isn't a forecast we can use
+	if len(periodFull.StartTimes) == 0 {
+		f.Length = 0
+	}
+
 	// Put the collections into appropriate forecast structs
 	f.ForecastDays = make([]ForecastDay, f.Length)
 
@@ -141,7 +161,7 @@ func (f *Forecast) assignForecastData(body []byte) {
 	var dayPosition int
 	var nightPosition int
 
-	for i := range periodDay.StartTimes {
+	for i := 0; i < f.Length; i++ {
 		d := &f.ForecastDays[i]
 
 		if periodFull.StartTimes[0].PeriodName == startDay {
@@ -228,7 +248,7 @@ func (f *Forecast) assignForecastData(body []byte) {
 			t, _ := time.Parse(noaaTime, periodFull.StartTimes[dayPosition].Time)
 			d.StartTime = t
 		}
-		if nightPosition < len(periodFull.EndTimes) {
+		if nightPosition < len(periodFull.EndTimes) && nightPosition < len(periodFull.StartTimes) {
 			// Name "Saturday Night"
 			d.NameNight = periodFull.StartTimes[nightPosition].PeriodName
 
@@ -242,7 +262,8 @@ func (f *Forecast) assignForecastData(body []byte) {
 }
 
 // Searches the XML file for weather data and unmarshals it into XML result collections.
-func unmarshalForecast(body []byte) {
+func unmarshalForecast(body []byte) *forecastResults {
+	r := &forecastResults{}
 	decoder := xml.NewDecoder(bytes.NewReader(body))
 
 	// Loop through the elements
@@ -259,7 +280,7 @@ func unmarshalForecast(body []byte) {
 
 			// Decode point
 			if s.Name.Local == nodePoint {
-				decoder.DecodeElement(&forecastPoint, &s)
+				decoder.DecodeElement(&r.forecastPoint, &s)
 			}
 
 			// Decode temperature array
@@ -267,29 +288,29 @@ func unmarshalForecast(body []byte) {
 				for _, attr := range s.Attr {
 					// Unmarshal maximum temps
 					if attr.Value == nodeMaximum {
-						decoder.DecodeElement(&maxTemps, &s)
+						decoder.DecodeElement(&r.maxTemps, &s)
 					}
 
 					// Unmarshal minimum temps
 					if attr.Value == nodeMinimum {
-						decoder.DecodeElement(&minTemps, &s)
+						decoder.DecodeElement(&r.minTemps, &s)
 					}
 				}
 			}
 
 			// Decode precipitation chance array
 			if s.Name.Local == nodePrecipitationChance {
-				decoder.DecodeElement(&precipitationChances, &s)
+				decoder.DecodeElement(&r.precipitationChances, &s)
 			}
 
 			// Decode weather summary collections
 			if s.Name.Local == nodeWeather {
-				decoder.DecodeElement(&weatherDetails, &s)
+				decoder.DecodeElement(&r.weatherDetails, &s)
 			}
 
 			// Decode Condition Icon Urls
 			if s.Name.Local == nodeConditions {
-				decoder.DecodeElement(&conditionIcons, &s)
+				decoder.DecodeElement(&r.conditionIcons, &s)
 			}
 
 			if s.Name.Local == "time-layout" {
@@ -299,14 +320,16 @@ func unmarshalForecast(body []byte) {
 				// so we need to do some manual checking here.
 				// DayTime
 				if summaryLevel == 1 {
-					decoder.DecodeElement(&periodDay, &s)
+					decoder.DecodeElement(&r.periodDay, &s)
 				}
 				// Everything (Night + Day || Day + Night)
 				if summaryLevel == 3 {
-					decoder.DecodeElement(&periodFull, &s)
+					decoder.DecodeElement(&r.periodFull, &s)
 				}
 
 			}
 		}
 	}
+
+	return r
 }
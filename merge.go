@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mergeFields copies each observation field from one observation to another
+var mergeFields = map[ObservationField]func(dst *CurrentObservation, src CurrentObservation){
+	FieldStationID:         func(dst *CurrentObservation, src CurrentObservation) { dst.StationID = src.StationID },
+	FieldWeather:           func(dst *CurrentObservation, src CurrentObservation) { dst.Weather = src.Weather },
+	FieldTemperature:       func(dst *CurrentObservation, src CurrentObservation) { dst.Temperature = src.Temperature },
+	FieldHumidity:          func(dst *CurrentObservation, src CurrentObservation) { dst.Humidity = src.Humidity },
+	FieldDewpoint:          func(dst *CurrentObservation, src CurrentObservation) { dst.Dewpoint = src.Dewpoint },
+	FieldWindChill:         func(dst *CurrentObservation, src CurrentObservation) { dst.WindChill = src.WindChill },
+	FieldHeatIndex:         func(dst *CurrentObservation, src CurrentObservation) { dst.HeatIndex = src.HeatIndex },
+	FieldFeelsLike:         func(dst *CurrentObservation, src CurrentObservation) { dst.FeelsLike = src.FeelsLike },
+	FieldWindDir:           func(dst *CurrentObservation, src CurrentObservation) { dst.WindDir = src.WindDir },
+	FieldWindSpeed:         mergeWindSpeed,
+	FieldWindGust:          func(dst *CurrentObservation, src CurrentObservation) { dst.WindGust = src.WindGust },
+	FieldBarometer:         func(dst *CurrentObservation, src CurrentObservation) { dst.Barometer = src.Barometer },
+	FieldStationPressure:   func(dst *CurrentObservation, src CurrentObservation) { dst.StationPressure = src.StationPressure },
+	FieldRainToday:         func(dst *CurrentObservation, src CurrentObservation) { dst.RainToday = src.RainToday },
+	FieldRain1Hour:         func(dst *CurrentObservation, src CurrentObservation) { dst.Rain1Hour = src.Rain1Hour },
+	FieldRainRate:          func(dst *CurrentObservation, src CurrentObservation) { dst.RainRate = src.RainRate },
+	FieldIndoorTemperature: func(dst *CurrentObservation, src CurrentObservation) { dst.IndoorTemperature = src.IndoorTemperature },
+	FieldIndoorHumidity:    func(dst *CurrentObservation, src CurrentObservation) { dst.IndoorHumidity = src.IndoorHumidity },
+	FieldRawMETAR:          func(dst *CurrentObservation, src CurrentObservation) { dst.RawMETAR = src.RawMETAR },
+	FieldVisibility:        func(dst *CurrentObservation, src CurrentObservation) { dst.Visibility = src.Visibility },
+	FieldCeiling: func(dst *CurrentObservation, src CurrentObservation) {
+		dst.Ceiling = src.Ceiling
+		dst.HasCeiling = src.HasCeiling
+	},
+	FieldPresentWeather:   func(dst *CurrentObservation, src CurrentObservation) { dst.PresentWeather = src.PresentWeather },
+	FieldWaveHeight:       func(dst *CurrentObservation, src CurrentObservation) { dst.WaveHeight = src.WaveHeight },
+	FieldWavePeriod:       func(dst *CurrentObservation, src CurrentObservation) { dst.WavePeriod = src.WavePeriod },
+	FieldWaterTemperature: func(dst *CurrentObservation, src CurrentObservation) { dst.WaterTemperature = src.WaterTemperature },
+}
+
+// mergeWindSpeed copies the wind speed along with whether its direction is variable
+func mergeWindSpeed(dst *CurrentObservation, src CurrentObservation) {
+	dst.WindSpeed = src.WindSpeed
+	dst.WindVariable = src.WindVariable
+}
+
+// configureMerge sets up merge mode from the [merge-precedence] and [merge-max-age]
+// sections of the config file.  Each key of [merge-precedence] is a field and its
+// value lists the providers to take that field from, in order.  Each key of
+// [merge-max-age] is a field and its value is how old that field may get.
+func (c *ProviderChain) configureMerge(precedence, maxAges map[string]string) error {
+	c.merge = true
+	c.precedence = make(map[ObservationField][]*chainMember)
+	c.fieldMaxAge = make(map[ObservationField]time.Duration)
+
+	members := make(map[string]*chainMember)
+	for _, m := range c.members {
+		members[m.provider.Name()] = m
+	}
+
+	for field, value := range precedence {
+		f := ObservationField(strings.ToLower(field))
+		if _, ok := mergeFields[f]; !ok {
+			return fmt.Errorf("unknown field in [merge-precedence]: %v", field)
+		}
+		for _, name := range strings.Split(value, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			m, ok := members[name]
+			if !ok {
+				return fmt.Errorf("provider %q in [merge-precedence] for %v is not in the provider list", name, field)
+			}
+			c.precedence[f] = append(c.precedence[f], m)
+		}
+	}
+
+	for field, value := range maxAges {
+		f := ObservationField(strings.ToLower(field))
+		if _, ok := mergeFields[f]; !ok {
+			return fmt.Errorf("unknown field in [merge-max-age]: %v", field)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid value for %v in [merge-max-age]: %v", field, value)
+		}
+		c.fieldMaxAge[f] = d
+	}
+
+	return nil
+}
+
+// fieldPrecedence returns the providers to take a field from, in order.  Unless
+// the config file says otherwise, that's the order of the provider list.
+func (c *ProviderChain) fieldPrecedence(f ObservationField) []*chainMember {
+	if members, ok := c.precedence[f]; ok {
+		return members
+	}
+	return c.members
+}
+
+// maxFieldAge returns how old a field may get before we stop showing it
+func (c *ProviderChain) maxFieldAge(f ObservationField) time.Duration {
+	if d, ok := c.fieldMaxAge[f]; ok {
+		return d
+	}
+	return c.maxAge
+}
+
+// mergeMaxAge returns the age past which no field of an observation is any use to us
+func (c *ProviderChain) mergeMaxAge() time.Duration {
+	maxAge := c.maxAge
+	for _, d := range c.fieldMaxAge {
+		if d > maxAge {
+			maxAge = d
+		}
+	}
+	return maxAge
+}
+
+// fetchMerged fetches conditions from every healthy provider at once and composes
+// a single observation from them.  If none of them answer, the providers that are
+// cooling down get another try.
+func (c *ProviderChain) fetchMerged(ctx context.Context, site WeatherSite) (CurrentObservation, error) {
+	var healthy, cooling []*chainMember
+	for _, m := range c.members {
+		if c.coolingDown(m, time.Now()) {
+			cooling = append(cooling, m)
+		} else {
+			healthy = append(healthy, m)
+		}
+	}
+
+	results, errs := c.fetchAll(ctx, healthy, site)
+	if len(results) == 0 {
+		var moreErrs []string
+		results, moreErrs = c.fetchAll(ctx, cooling, site)
+		errs = append(errs, moreErrs...)
+	}
+
+	obs, ok := c.compose(results, time.Now())
+	if !ok {
+		return CurrentObservation{}, fmt.Errorf("no weather provider has current conditions: %v", strings.Join(errs, "; "))
+	}
+	if c.debug && len(errs) > 0 {
+		log.Println("Merged conditions without:", strings.Join(errs, "; "))
+	}
+	return obs, nil
+}
+
+// fetchAll fetches conditions from the given providers in parallel
+func (c *ProviderChain) fetchAll(ctx context.Context, members []*chainMember, site WeatherSite) (map[*chainMember]CurrentObservation, []string) {
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[*chainMember]CurrentObservation)
+	var errs []string
+
+	maxAge := c.mergeMaxAge()
+	for _, m := range members {
+		wg.Add(1)
+		go func(m *chainMember) {
+			defer wg.Done()
+			obs, err := c.fetch(ctx, m, site, maxAge)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				errs = append(errs, err.Error())
+				return
+			}
+			results[m] = obs
+		}(m)
+	}
+	wg.Wait()
+
+	return results, errs
+}
+
+// compose builds one observation from the providers' observations, taking each
+// field from the first provider in its precedence list that has a fresh value for
+// it.  The observation time is that of the first contributing provider in the list.
+func (c *ProviderChain) compose(results map[*chainMember]CurrentObservation, now time.Time) (CurrentObservation, bool) {
+	var merged CurrentObservation
+	contributed := make(map[*chainMember]bool)
+
+	for f, copyField := range mergeFields {
+		for _, m := range c.fieldPrecedence(f) {
+			obs, ok := results[m]
+			if !ok || !m.fields[f] || !obs.Has(f) {
+				continue
+			}
+			if !obs.ObservedAt.IsZero() && now.Sub(obs.ObservedAt) > c.maxFieldAge(f) {
+				continue
+			}
+			copyField(&merged, obs)
+			merged.Filled.Add(f)
+			contributed[m] = true
+			break
+		}
+	}
+
+	var names []string
+	for _, m := range c.members {
+		if !contributed[m] {
+			continue
+		}
+		if len(names) == 0 {
+			merged.ObservedAt = results[m].ObservedAt
+		}
+		names = append(names, m.provider.Name())
+	}
+	merged.Provider = strings.Join(names, "+")
+
+	return merged, len(names) > 0
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// stubProvider is a provider that always has the same conditions, or the same
+// error.  With a delay, it takes that long to answer, whatever its context says.
+type stubProvider struct {
+	name   string
+	fields []ObservationField
+	obs    CurrentObservation
+	err    error
+	delay  time.Duration
+	calls  int
+}
+
+func (p *stubProvider) Name() string                        { return p.name }
+func (p *stubProvider) UpdateInterval() time.Duration       { return time.Minute }
+func (p *stubProvider) SupportedFields() []ObservationField { return p.fields }
+
+func (p *stubProvider) FetchObservation(ctx context.Context, site WeatherSite) (CurrentObservation, error) {
+	p.calls++
+	time.Sleep(p.delay)
+	return p.obs, p.err
+}
+
+func TestComposeFillsMissingFields(t *testing.T) {
+	now := time.Now()
+
+	// The NWS sends null for a gust when there isn't one, and leaves out the wind
+	// chill and heat index when they don't apply
+	var primary CurrentObservation
+	primary.setText(FieldStationID, "KMHK")
+	primary.set(FieldTemperature, 0)
+	primary.set(FieldWindSpeed, 12)
+	primary.ObservedAt = now
+
+	var secondary CurrentObservation
+	secondary.setText(FieldStationID, "local")
+	secondary.set(FieldTemperature, 3.2)
+	secondary.set(FieldWindGust, 18)
+	secondary.set(FieldWindChill, -12)
+	secondary.ObservedAt = now
+
+	fields := []ObservationField{FieldStationID, FieldTemperature, FieldWindSpeed, FieldWindGust, FieldWindChill}
+	members := []*chainMember{
+		{provider: &stubProvider{name: "nws", fields: fields}, fields: make(map[ObservationField]bool)},
+		{provider: &stubProvider{name: "push", fields: fields}, fields: make(map[ObservationField]bool)},
+	}
+	for _, m := range members {
+		for _, f := range fields {
+			m.fields[f] = true
+		}
+	}
+	c := &ProviderChain{members: members, maxAge: time.Hour}
+
+	merged, ok := c.compose(map[*chainMember]CurrentObservation{
+		members[0]: primary,
+		members[1]: secondary,
+	}, now)
+	if !ok {
+		t.Fatal("compose found nothing to merge")
+	}
+
+	if merged.Temperature != 0 {
+		t.Errorf("temperature = %v, want the primary's real zero", merged.Temperature)
+	}
+	if merged.WindGust != 18 || merged.WindChill != -12 {
+		t.Errorf("wind gust, wind chill = %v, %v, want 18, -12 from the secondary", merged.WindGust, merged.WindChill)
+	}
+	if merged.StationID != "KMHK" {
+		t.Errorf("station ID = %q, want KMHK", merged.StationID)
+	}
+	for _, f := range fields {
+		if !merged.Has(f) {
+			t.Errorf("merged observation is missing %v", f)
+		}
+	}
+	if merged.Provider != "nws+push" {
+		t.Errorf("provider = %q, want nws+push", merged.Provider)
+	}
+}
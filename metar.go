@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const metarAPIBaseURL = "https://aviationweather.gov"
+
+func init() {
+	registerProvider("metar", newMETARProvider)
+}
+
+// METARProvider fetches raw METAR reports for an ICAO station from the Aviation
+// Weather Center and decodes them locally
+type METARProvider struct {
+	baseURL string
+	client  *http.Client
+	debug   bool
+}
+
+func newMETARProvider(w *WeatherBar) (WeatherProvider, error) {
+	return &METARProvider{
+		baseURL: metarAPIBaseURL,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		debug:   *w.debug,
+	}, nil
+}
+
+// Name returns the name of this provider
+func (p *METARProvider) Name() string {
+	return "metar"
+}
+
+// UpdateInterval returns the polling interval for METARs
+func (p *METARProvider) UpdateInterval() time.Duration {
+	return metarUpdateInterval
+}
+
+// SupportedFields returns the fields populated from a METAR
+func (p *METARProvider) SupportedFields() []ObservationField {
+	return []ObservationField{
+		FieldStationID,
+		FieldWeather,
+		FieldTemperature,
+		FieldDewpoint,
+		FieldWindDir,
+		FieldWindSpeed,
+		FieldWindGust,
+		FieldBarometer,
+		FieldRain1Hour,
+		FieldRawMETAR,
+		FieldVisibility,
+		FieldCeiling,
+		FieldPresentWeather,
+	}
+}
+
+// FetchObservation fetches and decodes the latest METAR for the site's station
+func (p *METARProvider) FetchObservation(ctx context.Context, site WeatherSite) (CurrentObservation, error) {
+	if p.debug {
+		log.Println("Fetching METAR for station", site.StationID, "from aviationweather.gov...")
+	}
+
+	raw, err := p.fetchRawMETAR(ctx, site.StationID)
+	if err != nil {
+		return CurrentObservation{}, err
+	}
+
+	m, err := decodeMETAR(raw, time.Now())
+	if err != nil {
+		return CurrentObservation{}, err
+	}
+	if p.debug && len(m.Unparsed) > 0 {
+		log.Println("Unrecognized METAR groups:", strings.Join(m.Unparsed, " "))
+	}
+
+	return m.toCurrentObservation(), nil
+}
+
+// fetchRawMETAR returns the text of the most recent METAR for a station
+func (p *METARProvider) fetchRawMETAR(ctx context.Context, icao string) (string, error) {
+	q := url.Values{}
+	q.Set("ids", icao)
+	q.Set("format", "raw")
+
+	req, err := http.NewRequest("GET", p.baseURL+"/api/data/metar?"+q.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	r, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK && r.StatusCode != http.StatusNoContent {
+		return "", fmt.Errorf("error fetching METAR for %v: %v", icao, r.Status)
+	}
+
+	// The most recent report comes first
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			return line, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", fmt.Errorf("no METAR available for %v", icao)
+}
+
+// toCurrentObservation maps a decoded METAR into a CurrentObservation
+func (m *METAR) toCurrentObservation() CurrentObservation {
+	obs := CurrentObservation{ObservedAt: m.Time}
+	obs.setText(FieldStationID, m.Station)
+	obs.setText(FieldRawMETAR, m.Raw)
+	obs.setText(FieldPresentWeather, m.PresentWeatherText())
+
+	weather := obs.PresentWeather
+	if weather == "" {
+		weather = m.SkyCondition()
+	}
+	obs.setText(FieldWeather, weather)
+
+	if m.HasVisibility {
+		obs.set(FieldVisibility, math.Round(m.VisibilityMiles*100)/100)
+	}
+
+	// Every METAR says what the sky is like, so no ceiling is an answer too
+	obs.Ceiling, obs.HasCeiling = m.Ceiling()
+	obs.Filled.Add(FieldCeiling)
+
+	if m.HasTemperature {
+		obs.set(FieldTemperature, roundTenth(celsiusToFahrenheit(m.TemperatureC)))
+	}
+	if m.HasDewpoint {
+		obs.set(FieldDewpoint, roundTenth(celsiusToFahrenheit(m.DewpointC)))
+	}
+
+	if m.HasWind {
+		// A variable wind has no direction to report
+		if m.WindVariable {
+			obs.WindVariable = true
+		} else {
+			obs.set(FieldWindDir, m.WindDirection)
+		}
+		obs.set(FieldWindSpeed, roundTenth(m.WindSpeedKt*mphPerKnot))
+	}
+	if m.HasGust {
+		obs.set(FieldWindGust, roundTenth(m.WindGustKt*mphPerKnot))
+	}
+
+	// Prefer sea-level pressure from the remarks, falling back to the altimeter setting
+	if m.HasSeaLevel {
+		obs.set(FieldBarometer, m.SeaLevelPressureMb)
+	} else if m.HasAltimeter {
+		obs.set(FieldBarometer, roundTenth(m.AltimeterMb))
+	}
+
+	if m.HasPrecip1Hour {
+		obs.set(FieldRain1Hour, m.Precip1HourIn)
+	}
+
+	return obs
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// METAR holds a decoded METAR or SPECI aviation weather report.  Values that are
+// missing from the report are left at their zero value and flagged by the Has*
+// fields where zero is a legitimate reading.
+type METAR struct {
+	Raw     string
+	Type    string // METAR or SPECI
+	Station string
+	Time    time.Time
+	Auto    bool
+
+	WindDirection   float64 // degrees true
+	WindVariable    bool    // direction reported as VRB
+	WindVarFrom     float64 // range of a variable direction, e.g. 180V240
+	WindVarTo       float64
+	WindSpeedKt     float64
+	WindGustKt      float64
+	HasWind         bool
+	HasGust         bool
+	VisibilityMiles float64
+	HasVisibility   bool
+
+	PresentWeather []WeatherPhenomenon
+	Clouds         []CloudLayer
+	CAVOK          bool
+
+	TemperatureC   float64
+	DewpointC      float64
+	HasTemperature bool
+	HasDewpoint    bool
+
+	AltimeterMb        float64
+	HasAltimeter       bool
+	SeaLevelPressureMb float64
+	HasSeaLevel        bool
+
+	Precip1HourIn  float64
+	Precip6HourIn  float64
+	Precip24HourIn float64
+	HasPrecip1Hour bool
+
+	Remarks  string
+	Unparsed []string
+}
+
+// WeatherPhenomenon is one present weather group, such as -SHRA or +TSGR
+type WeatherPhenomenon struct {
+	Raw        string
+	Intensity  string // "-", "+", "VC" or ""
+	Descriptor string
+	Phenomena  []string
+}
+
+// CloudLayer is one sky condition group, such as BKN025CB
+type CloudLayer struct {
+	Cover    string // FEW, SCT, BKN, OVC or VV
+	HeightFt float64
+	Type     string // CB or TCU
+}
+
+var (
+	metarTimeRegex       = regexp.MustCompile(`^(\d{2})(\d{2})(\d{2})Z$`)
+	metarWindRegex       = regexp.MustCompile(`^(\d{3}|VRB)(\d{2,3})(?:G(\d{2,3}))?(KT|MPS|KMH)$`)
+	metarWindVarRegex    = regexp.MustCompile(`^(\d{3})V(\d{3})$`)
+	metarVisSMRegex      = regexp.MustCompile(`^([MP])?(\d+)?(?:(\d)/(\d{1,2}))?SM$`)
+	metarVisMetersRegex  = regexp.MustCompile(`^(\d{4})(?:NDV)?$`)
+	metarRVRRegex        = regexp.MustCompile(`^R\d{2}[LCR]?/`)
+	metarWeatherRegex    = regexp.MustCompile(`^(-|\+|VC)?(MI|PR|BC|DR|BL|SH|TS|FZ)?((?:DZ|RA|SN|SG|IC|PL|GR|GS|UP|BR|FG|FU|VA|DU|SA|HZ|PY|PO|SQ|FC|SS|DS)*)$`)
+	metarCloudRegex      = regexp.MustCompile(`^(FEW|SCT|BKN|OVC|VV)(\d{3}|///)(CB|TCU|///)?$`)
+	metarTempRegex       = regexp.MustCompile(`^(M?\d{2})/(M?\d{2})?$`)
+	metarAltimeterRegex  = regexp.MustCompile(`^A(\d{4})$`)
+	metarQNHRegex        = regexp.MustCompile(`^Q(\d{4})$`)
+	metarSLPRegex        = regexp.MustCompile(`^SLP(\d{3})$`)
+	metarPrecipRegex     = regexp.MustCompile(`^P(\d{4})$`)
+	metarPrecip6Regex    = regexp.MustCompile(`^6(\d{4})$`)
+	metarPrecip24Regex   = regexp.MustCompile(`^7(\d{4})$`)
+	metarTempTenthsRegex = regexp.MustCompile(`^T([01])(\d{3})(?:([01])(\d{3}))?$`)
+)
+
+var metarIntensities = map[string]string{
+	"-":  "Light",
+	"+":  "Heavy",
+	"VC": "Nearby",
+}
+
+var metarDescriptors = map[string]string{
+	"MI": "Shallow",
+	"PR": "Partial",
+	"BC": "Patches of",
+	"DR": "Low Drifting",
+	"BL": "Blowing",
+	"SH": "Showers",
+	"TS": "Thunderstorm",
+	"FZ": "Freezing",
+}
+
+var metarPhenomena = map[string]string{
+	"DZ": "Drizzle",
+	"RA": "Rain",
+	"SN": "Snow",
+	"SG": "Snow Grains",
+	"IC": "Ice Crystals",
+	"PL": "Ice Pellets",
+	"GR": "Hail",
+	"GS": "Small Hail",
+	"UP": "Unknown Precipitation",
+	"BR": "Mist",
+	"FG": "Fog",
+	"FU": "Smoke",
+	"VA": "Volcanic Ash",
+	"DU": "Dust",
+	"SA": "Sand",
+	"HZ": "Haze",
+	"PY": "Spray",
+	"PO": "Dust Whirls",
+	"SQ": "Squalls",
+	"FC": "Funnel Cloud",
+	"SS": "Sandstorm",
+	"DS": "Duststorm",
+}
+
+var metarCloudCovers = map[string]string{
+	"FEW": "Few Clouds",
+	"SCT": "Partly Cloudy",
+	"BKN": "Mostly Cloudy",
+	"OVC": "Overcast",
+	"VV":  "Obscured",
+}
+
+// decodeMETAR decodes a raw METAR or SPECI report.  Reports only carry the day of
+// the month, so the month and year are taken from now.
+func decodeMETAR(raw string, now time.Time) (*METAR, error) {
+	m := &METAR{Raw: strings.TrimSpace(raw), Type: "METAR"}
+
+	body := strings.TrimSuffix(m.Raw, "=")
+	if i := strings.Index(body, " RMK "); i >= 0 {
+		m.Remarks = strings.TrimSpace(body[i+5:])
+		body = body[:i]
+	}
+
+	groups := strings.Fields(body)
+	if len(groups) > 0 && (groups[0] == "METAR" || groups[0] == "SPECI") {
+		m.Type = groups[0]
+		groups = groups[1:]
+	}
+	if len(groups) < 2 {
+		return nil, fmt.Errorf("METAR is too short: %q", raw)
+	}
+
+	m.Station = groups[0]
+	t, err := metarTime(groups[1], now)
+	if err != nil {
+		return nil, err
+	}
+	m.Time = t
+
+	for i := 2; i < len(groups); i++ {
+		g := groups[i]
+
+		// Trend forecasts follow the observation and aren't part of it
+		if g == "NOSIG" || g == "BECMG" || g == "TEMPO" {
+			break
+		}
+
+		switch {
+		case g == "AUTO":
+			m.Auto = true
+		case g == "COR" || g == "CCA":
+			// Correction markers carry no weather
+		case g == "CAVOK":
+			m.CAVOK = true
+			m.VisibilityMiles = 10000 / metersPerMile
+			m.HasVisibility = true
+		case g == "CLR" || g == "SKC" || g == "NSC" || g == "NCD":
+			// Clear skies are represented by an empty set of cloud layers
+		case metarWindRegex.MatchString(g):
+			m.decodeWind(metarWindRegex.FindStringSubmatch(g))
+		case metarWindVarRegex.MatchString(g):
+			match := metarWindVarRegex.FindStringSubmatch(g)
+			m.WindVarFrom, _ = strconv.ParseFloat(match[1], 64)
+			m.WindVarTo, _ = strconv.ParseFloat(match[2], 64)
+		case isWholeMiles(g) && i+1 < len(groups) && strings.HasSuffix(groups[i+1], "SM") && strings.Contains(groups[i+1], "/"):
+			// Visibility such as "1 1/2SM" is split across two groups
+			whole, _ := strconv.ParseFloat(g, 64)
+			frac, ok := metarVisibility(groups[i+1])
+			if ok {
+				m.VisibilityMiles = whole + frac
+				m.HasVisibility = true
+				i++
+			}
+		case strings.HasSuffix(g, "SM"):
+			if v, ok := metarVisibility(g); ok {
+				m.VisibilityMiles = v
+				m.HasVisibility = true
+			} else {
+				m.Unparsed = append(m.Unparsed, g)
+			}
+		case metarVisMetersRegex.MatchString(g) && !m.HasVisibility:
+			meters, _ := strconv.ParseFloat(metarVisMetersRegex.FindStringSubmatch(g)[1], 64)
+			// 9999 means 10 km or more
+			if meters == 9999 {
+				meters = 10000
+			}
+			m.VisibilityMiles = meters / metersPerMile
+			m.HasVisibility = true
+		case metarRVRRegex.MatchString(g):
+			// Runway visual range isn't interesting outside of a cockpit
+		case metarCloudRegex.MatchString(g):
+			match := metarCloudRegex.FindStringSubmatch(g)
+			layer := CloudLayer{Cover: match[1]}
+			if match[2] != "///" {
+				hundreds, _ := strconv.ParseFloat(match[2], 64)
+				layer.HeightFt = hundreds * 100
+			}
+			if match[3] != "///" {
+				layer.Type = match[3]
+			}
+			m.Clouds = append(m.Clouds, layer)
+		case metarTempRegex.MatchString(g):
+			match := metarTempRegex.FindStringSubmatch(g)
+			m.TemperatureC = metarSignedInt(match[1])
+			m.HasTemperature = true
+			if match[2] != "" {
+				m.DewpointC = metarSignedInt(match[2])
+				m.HasDewpoint = true
+			}
+		case metarAltimeterRegex.MatchString(g):
+			hundredths, _ := strconv.ParseFloat(metarAltimeterRegex.FindStringSubmatch(g)[1], 64)
+			m.AltimeterMb = hundredths / 100 * millibarsPerInchHg
+			m.HasAltimeter = true
+		case metarQNHRegex.MatchString(g):
+			m.AltimeterMb, _ = strconv.ParseFloat(metarQNHRegex.FindStringSubmatch(g)[1], 64)
+			m.HasAltimeter = true
+		case g != "" && metarWeatherRegex.MatchString(g):
+			if wx, ok := metarWeather(g); ok {
+				m.PresentWeather = append(m.PresentWeather, wx)
+			} else {
+				m.Unparsed = append(m.Unparsed, g)
+			}
+		default:
+			m.Unparsed = append(m.Unparsed, g)
+		}
+	}
+
+	m.decodeRemarks()
+
+	return m, nil
+}
+
+// metarTime resolves a ddhhmmZ group into the most recent matching time at or before now
+func metarTime(g string, now time.Time) (time.Time, error) {
+	match := metarTimeRegex.FindStringSubmatch(g)
+	if match == nil {
+		return time.Time{}, fmt.Errorf("invalid METAR observation time: %q", g)
+	}
+	day, _ := strconv.Atoi(match[1])
+	hour, _ := strconv.Atoi(match[2])
+	minute, _ := strconv.Atoi(match[3])
+
+	now = now.UTC()
+	t := time.Date(now.Year(), now.Month(), day, hour, minute, 0, 0, time.UTC)
+
+	// A report from the end of last month will appear to be in the future.  Allow a
+	// little slack for clock skew before deciding that.
+	if t.After(now.Add(time.Hour)) || t.Day() != day {
+		t = time.Date(now.Year(), now.Month()-1, day, hour, minute, 0, 0, time.UTC)
+	}
+	return t, nil
+}
+
+func (m *METAR) decodeWind(match []string) {
+	speed, _ := strconv.ParseFloat(match[2], 64)
+	gust, _ := strconv.ParseFloat(match[3], 64)
+
+	// Normalize everything to knots
+	switch match[4] {
+	case "MPS":
+		speed *= knotsPerMeterPerSecond
+		gust *= knotsPerMeterPerSecond
+	case "KMH":
+		speed /= kphPerKnot
+		gust /= kphPerKnot
+	}
+
+	if match[1] == "VRB" {
+		m.WindVariable = true
+	} else {
+		m.WindDirection, _ = strconv.ParseFloat(match[1], 64)
+	}
+	m.WindSpeedKt = speed
+	m.WindGustKt = gust
+	m.HasWind = true
+	m.HasGust = match[3] != ""
+}
+
+// isWholeMiles returns true for the leading whole-number part of a split visibility group
+func isWholeMiles(g string) bool {
+	if len(g) != 1 {
+		return false
+	}
+	return g[0] >= '1' && g[0] <= '9'
+}
+
+// metarVisibility decodes statute-mile visibilities such as 10SM, 1/2SM, M1/4SM and P6SM
+func metarVisibility(g string) (float64, bool) {
+	match := metarVisSMRegex.FindStringSubmatch(g)
+	if match == nil || (match[2] == "" && match[3] == "") {
+		return 0, false
+	}
+
+	var v float64
+	if match[2] != "" {
+		v, _ = strconv.ParseFloat(match[2], 64)
+	}
+	if match[3] != "" {
+		num, _ := strconv.ParseFloat(match[3], 64)
+		den, _ := strconv.ParseFloat(match[4], 64)
+		if den == 0 {
+			return 0, false
+		}
+		v += num / den
+	}
+	return v, true
+}
+
+// metarWeather decodes a present weather group.  The regex admits some strings that
+// aren't weather (like a bare intensity), so we check the pieces here.
+func metarWeather(g string) (WeatherPhenomenon, bool) {
+	match := metarWeatherRegex.FindStringSubmatch(g)
+	wx := WeatherPhenomenon{Raw: g, Intensity: match[1], Descriptor: match[2]}
+
+	codes := match[3]
+	for len(codes) >= 2 {
+		wx.Phenomena = append(wx.Phenomena, codes[:2])
+		codes = codes[2:]
+	}
+
+	// A descriptor may stand alone (TS, VCSH), but an intensity needs something to modify
+	if wx.Descriptor == "" && len(wx.Phenomena) == 0 {
+		return wx, false
+	}
+	return wx, true
+}
+
+// metarSignedInt decodes temperatures where an M prefix means minus
+func metarSignedInt(s string) float64 {
+	negative := strings.HasPrefix(s, "M")
+	v, _ := strconv.ParseFloat(strings.TrimPrefix(s, "M"), 64)
+	if negative {
+		return -v
+	}
+	return v
+}
+
+// decodeRemarks picks the machine-readable groups out of the remarks section
+func (m *METAR) decodeRemarks() {
+	for _, g := range strings.Fields(m.Remarks) {
+		switch {
+		case metarSLPRegex.MatchString(g):
+			// Sea-level pressure is encoded as the last three digits of tenths of
+			// millibars, so we pick whichever of 9xx.x or 10xx.x is plausible.
+			tenths, _ := strconv.ParseFloat(metarSLPRegex.FindStringSubmatch(g)[1], 64)
+			slp := tenths / 10
+			if slp < 50 {
+				slp += 1000
+			} else {
+				slp += 900
+			}
+			m.SeaLevelPressureMb = slp
+			m.HasSeaLevel = true
+		case metarPrecipRegex.MatchString(g):
+			hundredths, _ := strconv.ParseFloat(metarPrecipRegex.FindStringSubmatch(g)[1], 64)
+			m.Precip1HourIn = hundredths / 100
+			m.HasPrecip1Hour = true
+		case metarPrecip6Regex.MatchString(g):
+			hundredths, _ := strconv.ParseFloat(metarPrecip6Regex.FindStringSubmatch(g)[1], 64)
+			m.Precip6HourIn = hundredths / 100
+		case metarPrecip24Regex.MatchString(g):
+			hundredths, _ := strconv.ParseFloat(metarPrecip24Regex.FindStringSubmatch(g)[1], 64)
+			m.Precip24HourIn = hundredths / 100
+		case metarTempTenthsRegex.MatchString(g):
+			// The T group gives temperature and dewpoint to a tenth of a degree, so it
+			// takes precedence over the whole degrees in the body of the report.
+			match := metarTempTenthsRegex.FindStringSubmatch(g)
+			m.TemperatureC = metarTenths(match[1], match[2])
+			m.HasTemperature = true
+			if match[3] != "" {
+				m.DewpointC = metarTenths(match[3], match[4])
+				m.HasDewpoint = true
+			}
+		}
+	}
+}
+
+// metarTenths decodes a remarks temperature, where a sign digit of 1 means minus
+func metarTenths(sign string, digits string) float64 {
+	v, _ := strconv.ParseFloat(digits, 64)
+	v /= 10
+	if sign == "1" {
+		return -v
+	}
+	return v
+}
+
+// Ceiling returns the height of the lowest broken, overcast or obscured layer
+func (m *METAR) Ceiling() (float64, bool) {
+	for _, layer := range m.Clouds {
+		if layer.Cover == "BKN" || layer.Cover == "OVC" || layer.Cover == "VV" {
+			return layer.HeightFt, true
+		}
+	}
+	return 0, false
+}
+
+// SkyCondition describes the most extensive cloud cover in the report
+func (m *METAR) SkyCondition() string {
+	ranks := map[string]int{"FEW": 1, "SCT": 2, "BKN": 3, "OVC": 4, "VV": 5}
+	sky := "Clear"
+	rank := 0
+	for _, layer := range m.Clouds {
+		if ranks[layer.Cover] > rank {
+			rank = ranks[layer.Cover]
+			sky = metarCloudCovers[layer.Cover]
+		}
+	}
+	return sky
+}
+
+// PresentWeatherText describes all present weather groups in plain English
+func (m *METAR) PresentWeatherText() string {
+	descriptions := make([]string, 0, len(m.PresentWeather))
+	for _, wx := range m.PresentWeather {
+		descriptions = append(descriptions, wx.String())
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+// String describes a weather phenomenon in plain English, e.g. "Heavy Rain Showers"
+func (wx WeatherPhenomenon) String() string {
+	var words []string
+	var phenomena []string
+	for _, p := range wx.Phenomena {
+		phenomena = append(phenomena, metarPhenomena[p])
+	}
+	what := strings.Join(phenomena, " and ")
+
+	// +FC is how a tornado is reported
+	if wx.Intensity == "+" && len(wx.Phenomena) == 1 && wx.Phenomena[0] == "FC" {
+		return "Tornado"
+	}
+
+	if wx.Intensity != "" && wx.Intensity != "VC" {
+		words = append(words, metarIntensities[wx.Intensity])
+	}
+
+	switch wx.Descriptor {
+	case "":
+		words = append(words, what)
+	case "SH":
+		if what != "" {
+			words = append(words, what)
+		}
+		words = append(words, "Showers")
+	case "TS":
+		words = append(words, "Thunderstorm")
+		if what != "" {
+			words = append(words, "with", what)
+		}
+	default:
+		words = append(words, metarDescriptors[wx.Descriptor])
+		if what != "" {
+			words = append(words, what)
+		}
+	}
+
+	if wx.Intensity == "VC" {
+		words = append(words, "Nearby")
+	}
+
+	return strings.Join(words, " ")
+}
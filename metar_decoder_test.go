@@ -0,0 +1,246 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// metarNow is late on the day of the test reports
+var metarNow = time.Date(2024, 3, 14, 23, 0, 0, 0, time.UTC)
+
+// decodeTestMETAR decodes a report or fails the test
+func decodeTestMETAR(t *testing.T, raw string) *METAR {
+	t.Helper()
+	m, err := decodeMETAR(raw, metarNow)
+	if err != nil {
+		t.Fatalf("%q: %v", raw, err)
+	}
+	return m
+}
+
+func TestDecodeMETARWind(t *testing.T) {
+	tests := []struct {
+		raw              string
+		dir, speed, gust float64
+		variable         bool
+		varFrom, varTo   float64
+	}{
+		{raw: "KMHK 141853Z 18012KT 10SM CLR 22/08 A2992", dir: 180, speed: 12},
+		{raw: "KMHK 141853Z 27015G28KT 10SM CLR 22/08 A2992", dir: 270, speed: 15, gust: 28},
+		{raw: "KMHK 141853Z VRB03KT 10SM CLR 22/08 A2992", speed: 3, variable: true},
+		{raw: "KMHK 141853Z VRB05G15KT 10SM CLR 22/08 A2992", speed: 5, gust: 15, variable: true},
+		{raw: "KMHK 141853Z 21010KT 180V240 10SM CLR 22/08 A2992", dir: 210, speed: 10, varFrom: 180, varTo: 240},
+		{raw: "KMHK 141853Z 00000KT 10SM CLR 22/08 A2992"},
+		{raw: "EGLL 141850Z 24010MPS 9999 FEW030 12/06 Q1012", dir: 240, speed: 10 * knotsPerMeterPerSecond},
+		{raw: "UUEE 141850Z 09036KMH 9999 SCT020 M02/M05 Q1020", dir: 90, speed: 36 / kphPerKnot},
+	}
+
+	for _, tt := range tests {
+		m := decodeTestMETAR(t, tt.raw)
+		if !m.HasWind {
+			t.Errorf("%q: no wind decoded", tt.raw)
+			continue
+		}
+		if m.WindDirection != tt.dir || math.Abs(m.WindSpeedKt-tt.speed) > 0.01 || m.WindGustKt != tt.gust || m.WindVariable != tt.variable {
+			t.Errorf("%q: wind = %v° %vkt G%v (variable %v), want %v° %vkt G%v (variable %v)", tt.raw,
+				m.WindDirection, m.WindSpeedKt, m.WindGustKt, m.WindVariable, tt.dir, tt.speed, tt.gust, tt.variable)
+		}
+		if m.HasGust != (tt.gust != 0) {
+			t.Errorf("%q: gust decoded %v, want %v", tt.raw, m.HasGust, tt.gust != 0)
+		}
+		if m.WindVarFrom != tt.varFrom || m.WindVarTo != tt.varTo {
+			t.Errorf("%q: variable range = %vV%v, want %vV%v", tt.raw, m.WindVarFrom, m.WindVarTo, tt.varFrom, tt.varTo)
+		}
+	}
+}
+
+func TestDecodeMETARVisibility(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want float64
+		ok   bool
+	}{
+		{"KMHK 141853Z 18012KT 10SM CLR 22/08 A2992", 10, true},
+		{"KMHK 141853Z 18012KT 1/2SM FG VV002 12/12 A2992", 0.5, true},
+		{"KMHK 141853Z 18012KT 1 1/2SM BR OVC005 12/11 A2992", 1.5, true},
+		{"KMHK 141853Z 18012KT 2 3/4SM -RA BKN008 12/11 A2992", 2.75, true},
+		{"KMHK 141853Z 18012KT M1/4SM FG VV001 12/12 A2992", 0.25, true},
+		{"KMHK 141853Z 18012KT P6SM SCT250 22/08 A2992", 6, true},
+		{"EGLL 141850Z 24010KT 9999 FEW030 12/06 Q1012", 10000 / metersPerMile, true},
+		{"EGLL 141850Z 24010KT 0800 FG VV002 08/08 Q1012", 800 / metersPerMile, true},
+		{"EGLL 141850Z 24010KT CAVOK 12/06 Q1012", 10000 / metersPerMile, true},
+		{"KMHK 141853Z AUTO 18012KT CLR 22/08 A2992", 0, false},
+	}
+
+	for _, tt := range tests {
+		m := decodeTestMETAR(t, tt.raw)
+		if m.HasVisibility != tt.ok || math.Abs(m.VisibilityMiles-tt.want) > 0.001 {
+			t.Errorf("%q: visibility = %v (decoded %v), want %v (decoded %v)", tt.raw, m.VisibilityMiles, m.HasVisibility, tt.want, tt.ok)
+		}
+		if len(m.Unparsed) > 0 {
+			t.Errorf("%q: groups left unparsed: %v", tt.raw, m.Unparsed)
+		}
+	}
+}
+
+func TestDecodeMETARWeather(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"KMHK 141853Z 18012KT 3SM -RA BR OVC008 12/11 A2992", "Light Rain, Mist"},
+		{"KMHK 141853Z 18012KT 1SM +TSRAGR BKN010CB 18/16 A2992", "Heavy Thunderstorm with Rain and Hail"},
+		{"KMHK 141853Z 18012KT 10SM VCSH SCT040 18/10 A2992", "Showers Nearby"},
+		{"KMHK 141853Z 18012KT 10SM TS SCT040CB 18/10 A2992", "Thunderstorm"},
+		{"KMHK 141853Z 18012KT 1/2SM FZFG VV002 M01/M01 A2992", "Freezing Fog"},
+		{"KMHK 141853Z 18012KT 2SM -SHSN BKN015 M03/M06 A2992", "Light Snow Showers"},
+		{"KMHK 141853Z 18012KT 1/4SM +FC OVC005 20/18 A2992", "Tornado"},
+		{"KMHK 141853Z 18012KT 10SM CLR 22/08 A2992", ""},
+	}
+
+	for _, tt := range tests {
+		m := decodeTestMETAR(t, tt.raw)
+		if got := m.PresentWeatherText(); got != tt.want {
+			t.Errorf("%q: weather = %q, want %q", tt.raw, got, tt.want)
+		}
+		if len(m.Unparsed) > 0 {
+			t.Errorf("%q: groups left unparsed: %v", tt.raw, m.Unparsed)
+		}
+	}
+
+	// A bare intensity isn't weather
+	if _, ok := metarWeather("+"); ok {
+		t.Error(`metarWeather("+") was accepted`)
+	}
+}
+
+func TestDecodeMETARClouds(t *testing.T) {
+	tests := []struct {
+		raw        string
+		clouds     []CloudLayer
+		sky        string
+		ceiling    float64
+		hasCeiling bool
+	}{
+		{
+			raw: "KMHK 141853Z 18012KT 10SM CLR 22/08 A2992",
+			sky: "Clear",
+		},
+		{
+			raw:    "KMHK 141853Z 18012KT 10SM FEW050 SCT120 22/08 A2992",
+			clouds: []CloudLayer{{Cover: "FEW", HeightFt: 5000}, {Cover: "SCT", HeightFt: 12000}},
+			sky:    "Partly Cloudy",
+		},
+		{
+			raw:        "KMHK 141853Z 18012KT 10SM SCT025TCU BKN040CB OVC100 22/08 A2992",
+			clouds:     []CloudLayer{{Cover: "SCT", HeightFt: 2500, Type: "TCU"}, {Cover: "BKN", HeightFt: 4000, Type: "CB"}, {Cover: "OVC", HeightFt: 10000}},
+			sky:        "Overcast",
+			ceiling:    4000,
+			hasCeiling: true,
+		},
+		{
+			raw:        "KMHK 141853Z 18012KT 1/4SM FG VV001 12/12 A2992",
+			clouds:     []CloudLayer{{Cover: "VV", HeightFt: 100}},
+			sky:        "Obscured",
+			ceiling:    100,
+			hasCeiling: true,
+		},
+		{
+			raw:    "KMHK 141853Z AUTO 18012KT 10SM BKN/// 22/08 A2992",
+			clouds: []CloudLayer{{Cover: "BKN"}},
+			sky:    "Mostly Cloudy",
+			// A broken layer of unknown height is still a ceiling
+			hasCeiling: true,
+		},
+	}
+
+	for _, tt := range tests {
+		m := decodeTestMETAR(t, tt.raw)
+		if !reflect.DeepEqual(m.Clouds, tt.clouds) {
+			t.Errorf("%q: clouds = %+v, want %+v", tt.raw, m.Clouds, tt.clouds)
+		}
+		if got := m.SkyCondition(); got != tt.sky {
+			t.Errorf("%q: sky = %q, want %q", tt.raw, got, tt.sky)
+		}
+		if ceiling, ok := m.Ceiling(); ceiling != tt.ceiling || ok != tt.hasCeiling {
+			t.Errorf("%q: ceiling = %v, %v, want %v, %v", tt.raw, ceiling, ok, tt.ceiling, tt.hasCeiling)
+		}
+	}
+}
+
+func TestDecodeMETARRemarks(t *testing.T) {
+	tests := []struct {
+		raw                 string
+		temp, dewpoint      float64
+		slp                 float64
+		hasSLP              bool
+		precip1h            float64
+		precip6h, precip24h float64
+	}{
+		{
+			raw:      "KMHK 141853Z 18012KT 10SM CLR 22/08 A2992 RMK AO2 SLP132 T02220083",
+			temp:     22.2,
+			dewpoint: 8.3,
+			slp:      1013.2,
+			hasSLP:   true,
+		},
+		{
+			raw:      "KMHK 141853Z 18012KT 10SM OVC010 M02/M04 A3045 RMK AO2 SLP987 T10171039",
+			temp:     -1.7,
+			dewpoint: -3.9,
+			slp:      998.7,
+			hasSLP:   true,
+		},
+		{
+			raw:       "KMHK 141853Z 18012KT 2SM RA OVC010 12/11 A2980 RMK AO2 SLP092 P0012 60034 70101 T01220111",
+			temp:      12.2,
+			dewpoint:  11.1,
+			slp:       1009.2,
+			hasSLP:    true,
+			precip1h:  0.12,
+			precip6h:  0.34,
+			precip24h: 1.01,
+		},
+		{
+			// Without a T group, the whole degrees in the body stand
+			raw:      "KMHK 141853Z 18012KT 10SM CLR 22/08 A2992 RMK AO2 SLPNO",
+			temp:     22,
+			dewpoint: 8,
+		},
+	}
+
+	for _, tt := range tests {
+		m := decodeTestMETAR(t, tt.raw)
+		if !m.HasTemperature || !m.HasDewpoint || m.TemperatureC != tt.temp || m.DewpointC != tt.dewpoint {
+			t.Errorf("%q: temperature, dewpoint = %v, %v, want %v, %v", tt.raw, m.TemperatureC, m.DewpointC, tt.temp, tt.dewpoint)
+		}
+		if m.HasSeaLevel != tt.hasSLP || math.Abs(m.SeaLevelPressureMb-tt.slp) > 0.01 {
+			t.Errorf("%q: sea-level pressure = %v (decoded %v), want %v", tt.raw, m.SeaLevelPressureMb, m.HasSeaLevel, tt.slp)
+		}
+		if m.HasPrecip1Hour != (tt.precip1h != 0) || m.Precip1HourIn != tt.precip1h || m.Precip6HourIn != tt.precip6h || m.Precip24HourIn != tt.precip24h {
+			t.Errorf("%q: precipitation = %v, %v, %v, want %v, %v, %v", tt.raw,
+				m.Precip1HourIn, m.Precip6HourIn, m.Precip24HourIn, tt.precip1h, tt.precip6h, tt.precip24h)
+		}
+	}
+}
+
+func TestMETARVariableWind(t *testing.T) {
+	obs := decodeTestMETAR(t, "KMHK 141853Z VRB04G18KT 10SM CLR 22/08 A2992").toCurrentObservation()
+	if obs.Has(FieldWindDir) || !obs.WindVariable {
+		t.Errorf("variable wind: direction filled %v, variable %v, want false, true", obs.Has(FieldWindDir), obs.WindVariable)
+	}
+	if !obs.Has(FieldWindSpeed) || obs.WindGust != roundTenth(18*mphPerKnot) {
+		t.Errorf("variable wind: speed filled %v, gust %v, want true, %v", obs.Has(FieldWindSpeed), obs.WindGust, roundTenth(18*mphPerKnot))
+	}
+
+	obs = decodeTestMETAR(t, "KMHK 141853Z 00000KT 10SM CLR 22/08 A2992").toCurrentObservation()
+	if !obs.Has(FieldWindDir) || obs.WindVariable {
+		t.Errorf("calm wind: direction filled %v, variable %v, want true, false", obs.Has(FieldWindDir), obs.WindVariable)
+	}
+	// A report without a G group says nothing about gusts
+	if obs.Has(FieldWindGust) {
+		t.Error("calm wind: gust filled, want no gust")
+	}
+}
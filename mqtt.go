@@ -0,0 +1,567 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// rtl_433 reports rain as a running total since the sensor was powered up.  Mapping
+// that total to this pseudo-field has us work out today's rainfall from it.
+const mqttRainTotal ObservationField = "rain-total"
+
+// We ask the broker to expect a ping from us at least this often
+const mqttKeepAlive = 60 * time.Second
+
+func init() {
+	registerProvider("mqtt", newMQTTProvider)
+}
+
+// MQTTProvider subscribes to sensor readings published to an MQTT broker, such as
+// the events that rtl_433 decodes from 433 MHz weather sensors or the state topics
+// of Home Assistant sensors.  The [mqtt-fields] section of the config file maps the
+// values in those messages to observation fields.
+type MQTTProvider struct {
+	broker    string
+	clientID  string
+	username  string
+	password  string
+	topics    []string
+	fields    []mqttFieldMapping
+	match     map[string]string
+	minFields int
+	maxAge    time.Duration
+	stationID string
+	debug     bool
+
+	readingsMutex sync.Mutex
+	readings      map[ObservationField]mqttReading
+	rain          mqttRainCounter
+	sensorName    string
+	latest        CurrentObservation
+	latestTime    time.Time
+}
+
+// mqttFieldMapping lists the message keys that can supply an observation field.
+// The first key present in a message wins.
+type mqttFieldMapping struct {
+	field ObservationField
+	keys  []mqttKey
+}
+
+// mqttKey is a message key and the conversion from its units into ours
+type mqttKey struct {
+	name    string
+	convert func(float64) float64
+}
+
+// mqttReading is the latest value that we've received for a field
+type mqttReading struct {
+	value   float64
+	updated time.Time
+}
+
+// mqttRainCounter turns a running rain total into today's rainfall.  We can't
+// know how much of the total fell before we started, so on the day that we start,
+// today's rainfall is the rain since then.
+type mqttRainCounter struct {
+	day   string
+	base  float64
+	last  float64
+	valid bool
+}
+
+// mqttFieldTargets are the observation fields that can be mapped to message keys
+var mqttFieldTargets = map[ObservationField]bool{
+	FieldTemperature:       true,
+	FieldHumidity:          true,
+	FieldDewpoint:          true,
+	FieldWindChill:         true,
+	FieldHeatIndex:         true,
+	FieldFeelsLike:         true,
+	FieldWindDir:           true,
+	FieldWindSpeed:         true,
+	FieldWindGust:          true,
+	FieldBarometer:         true,
+	FieldStationPressure:   true,
+	FieldRainToday:         true,
+	FieldRain1Hour:         true,
+	FieldRainRate:          true,
+	FieldIndoorTemperature: true,
+	FieldIndoorHumidity:    true,
+}
+
+// mqttUnits converts the units that sensors commonly report into the ones that
+// CurrentObservation uses.  rtl_433 appends these to its key names, e.g. temperature_C.
+var mqttUnits = map[string]func(float64) float64{
+	"F":     func(v float64) float64 { return v },
+	"C":     func(v float64) float64 { return roundTenth(celsiusToFahrenheit(v)) },
+	"K":     func(v float64) float64 { return roundTenth(celsiusToFahrenheit(v - 273.15)) },
+	"mph":   func(v float64) float64 { return v },
+	"mi_h":  func(v float64) float64 { return v },
+	"km_h":  func(v float64) float64 { return roundTenth(v / kphPerMph) },
+	"kph":   func(v float64) float64 { return roundTenth(v / kphPerMph) },
+	"m_s":   func(v float64) float64 { return roundTenth(v * knotsPerMeterPerSecond * mphPerKnot) },
+	"kn":    func(v float64) float64 { return roundTenth(v * mphPerKnot) },
+	"in":    func(v float64) float64 { return v },
+	"mm":    func(v float64) float64 { return v / millimetersPerInch },
+	"in_h":  func(v float64) float64 { return v },
+	"mm_h":  func(v float64) float64 { return v / millimetersPerInch },
+	"hPa":   func(v float64) float64 { return v },
+	"mbar":  func(v float64) float64 { return v },
+	"kPa":   func(v float64) float64 { return v * 10 },
+	"inHg":  func(v float64) float64 { return roundTenth(v * millibarsPerInchHg) },
+	"deg":   func(v float64) float64 { return v },
+	"pct":   func(v float64) float64 { return v },
+	"ratio": func(v float64) float64 { return v * 100 },
+}
+
+// mqttDefaultFields are the rtl_433 keys that we look for if the config file
+// doesn't have an [mqtt-fields] section
+var mqttDefaultFields = map[string]string{
+	"temperature":    "temperature_C, temperature_F",
+	"humidity":       "humidity",
+	"wind-speed":     "wind_avg_km_h, wind_avg_m_s, wind_avg_mi_h",
+	"wind-gust":      "wind_max_km_h, wind_max_m_s, wind_max_mi_h",
+	"wind-direction": "wind_dir_deg",
+	"rain-total":     "rain_mm, rain_in",
+	"rain-rate":      "rain_rate_mm_h, rain_rate_in_h",
+	"barometer":      "pressure_hPa, pressure_kPa, pressure_inHg",
+}
+
+func newMQTTProvider(w *WeatherBar) (WeatherProvider, error) {
+	cfg := w.cfg.Weather
+	if cfg.MQTTBroker == "" {
+		return nil, fmt.Errorf("the mqtt provider requires mqtt-broker to be set")
+	}
+
+	p := &MQTTProvider{
+		broker:    cfg.MQTTBroker,
+		clientID:  fmt.Sprintf("weather-bar-%d", os.Getpid()),
+		username:  cfg.MQTTUsername,
+		password:  cfg.MQTTPassword,
+		maxAge:    mqttUpdateInterval,
+		stationID: cfg.Station,
+		debug:     *w.debug,
+		readings:  make(map[ObservationField]mqttReading),
+	}
+
+	for _, topic := range strings.Split(cfg.MQTTTopics, ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			p.topics = append(p.topics, topic)
+		}
+	}
+	if len(p.topics) == 0 {
+		p.topics = []string{"rtl_433/+/events"}
+	}
+
+	var err error
+	p.match, err = parseMQTTMatch(cfg.MQTTMatch)
+	if err != nil {
+		return nil, err
+	}
+
+	// If the user mapped their own fields, we wait for all of them.  Our defaults
+	// cover many kinds of sensor, so with those any one field will do.
+	fieldConfig := w.cfg.MQTTFields
+	p.minFields = len(fieldConfig)
+	if len(fieldConfig) == 0 {
+		fieldConfig = mqttDefaultFields
+		p.minFields = 1
+	}
+
+	p.fields, err = parseMQTTFields(fieldConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.MQTTMinFields != "" {
+		p.minFields, err = strconv.Atoi(cfg.MQTTMinFields)
+		if err != nil || p.minFields < 1 {
+			return nil, fmt.Errorf("invalid value for mqtt-min-fields: %v", cfg.MQTTMinFields)
+		}
+	}
+
+	if cfg.MQTTMaxAge != "" {
+		p.maxAge, err = time.ParseDuration(cfg.MQTTMaxAge)
+		if err != nil || p.maxAge <= 0 {
+			return nil, fmt.Errorf("invalid value for mqtt-max-age: %v", cfg.MQTTMaxAge)
+		}
+	}
+
+	return p, nil
+}
+
+// parseMQTTFields parses the field mappings.  Each one maps an observation field to
+// a comma-separated list of message keys, each optionally followed by its unit:
+//
+//	temperature = temperature_C, outdoor.temp F
+//
+// Without a unit, we take it from an rtl_433-style suffix on the key, and failing
+// that, assume that the value is already in our units.
+func parseMQTTFields(fieldConfig map[string]string) ([]mqttFieldMapping, error) {
+	var fields []mqttFieldMapping
+	targets := make(map[ObservationField]ObservationField)
+
+	for name, value := range fieldConfig {
+		field := ObservationField(strings.ToLower(name))
+		target := field
+		if field == mqttRainTotal {
+			target = FieldRainToday
+		}
+		if _, ok := mqttFieldTargets[target]; !ok {
+			return nil, fmt.Errorf("unknown field in [mqtt-fields]: %v", name)
+		}
+		if other, ok := targets[target]; ok {
+			return nil, fmt.Errorf("fields %v and %v in [mqtt-fields] both set %v", other, field, target)
+		}
+		targets[target] = field
+
+		mapping := mqttFieldMapping{field: field}
+		for _, entry := range strings.Split(value, ",") {
+			parts := strings.Fields(entry)
+			if len(parts) == 0 {
+				continue
+			}
+			if len(parts) > 2 {
+				return nil, fmt.Errorf("invalid key for %v in [mqtt-fields]: %q", name, entry)
+			}
+
+			key := mqttKey{name: parts[0], convert: mqttUnitFromKey(parts[0])}
+			if len(parts) == 2 {
+				convert, ok := mqttUnits[parts[1]]
+				if !ok {
+					return nil, fmt.Errorf("unknown unit for %v in [mqtt-fields]: %v", name, parts[1])
+				}
+				key.convert = convert
+			}
+			mapping.keys = append(mapping.keys, key)
+		}
+		if len(mapping.keys) == 0 {
+			return nil, fmt.Errorf("no message keys given for %v in [mqtt-fields]", name)
+		}
+
+		fields = append(fields, mapping)
+	}
+
+	// Map iteration order is random, so sort to keep our behavior predictable
+	sort.Slice(fields, func(i, j int) bool { return fields[i].field < fields[j].field })
+
+	return fields, nil
+}
+
+// mqttUnitFromKey finds the conversion for an rtl_433-style unit suffix on a key name
+func mqttUnitFromKey(key string) func(float64) float64 {
+	for unit, convert := range mqttUnits {
+		if strings.HasSuffix(key, "_"+unit) {
+			return convert
+		}
+	}
+	return func(v float64) float64 { return v }
+}
+
+// parseMQTTMatch parses a comma-separated list of key=value pairs that a message
+// must contain for us to use it
+func parseMQTTMatch(s string) (map[string]string, error) {
+	match := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid value for mqtt-match: %q", pair)
+		}
+		match[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return match, nil
+}
+
+// Name returns the name of this provider
+func (p *MQTTProvider) Name() string {
+	return "mqtt"
+}
+
+// UpdateInterval returns how long a reading stays fresh.  We don't poll MQTT, but
+// a sensor that hasn't been heard from in this long is probably out of range.
+func (p *MQTTProvider) UpdateInterval() time.Duration {
+	return p.maxAge
+}
+
+// SupportedFields returns the fields that are mapped to message keys
+func (p *MQTTProvider) SupportedFields() []ObservationField {
+	fields := []ObservationField{FieldStationID}
+	for _, m := range p.fields {
+		if m.field == mqttRainTotal {
+			fields = append(fields, FieldRainToday)
+		} else {
+			fields = append(fields, m.field)
+		}
+	}
+	return fields
+}
+
+// FetchObservation returns the most recent observation assembled from MQTT messages
+func (p *MQTTProvider) FetchObservation(ctx context.Context, site WeatherSite) (CurrentObservation, error) {
+	p.readingsMutex.Lock()
+	defer p.readingsMutex.Unlock()
+
+	if p.latestTime.IsZero() {
+		return CurrentObservation{}, fmt.Errorf("not enough fresh readings from %v yet", p.broker)
+	}
+	return p.latest, nil
+}
+
+// Run subscribes to our topics and sends an observation to obsChan whenever a message
+// arrives and enough fields are fresh.  Once we've connected, the client reconnects
+// by itself whenever the broker goes away.
+func (p *MQTTProvider) Run(ctx context.Context, obsChan chan<- CurrentObservation) error {
+	// We only read at QoS 0, since a missed reading is soon replaced by the next one
+	topics := make(map[string]byte)
+	for _, topic := range p.topics {
+		topics[topic] = 0
+	}
+
+	handler := func(c mqtt.Client, msg mqtt.Message) {
+		obs, ok := p.handleMessage(msg.Topic(), msg.Payload(), time.Now())
+		if !ok {
+			return
+		}
+		select {
+		case obsChan <- obs:
+		case <-ctx.Done():
+		}
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(p.broker).
+		SetClientID(p.clientID).
+		SetUsername(p.username).
+		SetPassword(p.password).
+		SetKeepAlive(mqttKeepAlive).
+		SetMaxReconnectInterval(maxReconnectDelay)
+
+	// Every connection starts a clean session, since we don't care about messages
+	// that were published while we were away, so we subscribe every time
+	opts.SetOnConnectHandler(func(c mqtt.Client) {
+		token := c.SubscribeMultiple(topics, handler)
+		go func() {
+			token.Wait()
+			if token.Error() != nil {
+				log.Printf("error subscribing to %v on %v: %v\n", strings.Join(p.topics, ", "), p.broker, token.Error())
+			} else if p.debug {
+				log.Println("Subscribed to", strings.Join(p.topics, ", "), "on", p.broker)
+			}
+		}()
+	})
+	opts.SetConnectionLostHandler(func(c mqtt.Client, err error) {
+		log.Printf("MQTT connection to %v lost: %v (reconnecting)\n", p.broker, err)
+	})
+
+	client := mqtt.NewClient(opts)
+	defer client.Disconnect(250)
+
+	delay := minReconnectDelay
+	for {
+		token := client.Connect()
+		select {
+		case <-token.Done():
+		case <-ctx.Done():
+			return nil
+		}
+		if token.Error() == nil {
+			break
+		}
+
+		log.Printf("MQTT connection to %v failed: %v (retrying in %v)\n", p.broker, token.Error(), delay)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil
+		}
+
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+
+	<-ctx.Done()
+	return nil
+}
+
+// handleMessage records the readings in a message and returns a new observation
+// if enough of our fields are now fresh
+func (p *MQTTProvider) handleMessage(topic string, payload []byte, now time.Time) (CurrentObservation, bool) {
+	values, isJSON := decodeMQTTPayload(topic, payload)
+	if values == nil {
+		if p.debug {
+			log.Printf("Ignoring MQTT message on %v: %q\n", topic, payload)
+		}
+		return CurrentObservation{}, false
+	}
+	if isJSON && !p.matches(values) {
+		return CurrentObservation{}, false
+	}
+
+	p.readingsMutex.Lock()
+	defer p.readingsMutex.Unlock()
+
+	updated := false
+	for _, m := range p.fields {
+		for _, key := range m.keys {
+			v, ok := mqttNumber(lookupMQTTKey(values, key.name))
+			if !ok {
+				continue
+			}
+			v = key.convert(v)
+
+			target := m.field
+			if target == mqttRainTotal {
+				target = FieldRainToday
+				v = p.rain.update(v, now)
+			}
+			p.readings[target] = mqttReading{value: v, updated: now}
+			updated = true
+			break
+		}
+	}
+	if !updated {
+		return CurrentObservation{}, false
+	}
+
+	if model, ok := values["model"].(string); ok {
+		p.sensorName = model
+		if id, ok := values["id"]; ok {
+			p.sensorName = fmt.Sprintf("%v-%v", model, id)
+		}
+	}
+
+	stationID := p.stationID
+	if stationID == "" {
+		stationID = p.sensorName
+	}
+	if stationID == "" {
+		stationID = "mqtt"
+	}
+	obs := CurrentObservation{ObservedAt: now}
+	obs.setText(FieldStationID, stationID)
+
+	fresh := 0
+	for field, r := range p.readings {
+		if now.Sub(r.updated) > p.maxAge {
+			continue
+		}
+		obs.set(field, r.value)
+		fresh++
+	}
+
+	if fresh < p.minFields {
+		if p.debug {
+			log.Printf("Have %v of %v fresh fields after MQTT message on %v\n", fresh, p.minFields, topic)
+		}
+		return CurrentObservation{}, false
+	}
+
+	p.latest = obs
+	p.latestTime = now
+
+	if p.debug {
+		log.Printf("Received MQTT message on %v: %+v\n", topic, obs)
+	}
+
+	return obs, true
+}
+
+// matches checks a JSON message against the mqtt-match setting
+func (p *MQTTProvider) matches(values map[string]interface{}) bool {
+	for key, want := range p.match {
+		v := lookupMQTTKey(values, key)
+		if v == nil || fmt.Sprint(v) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// update takes the latest rain total and returns the rain that has fallen today.
+// If the total goes backwards, the sensor was reset and starts counting from zero.
+func (r *mqttRainCounter) update(total float64, now time.Time) float64 {
+	day := now.Format("2006-01-02")
+	switch {
+	case !r.valid:
+		r.base = total
+		r.valid = true
+	case day != r.day:
+		// Whatever fell since the last total that we saw belongs to the new day
+		r.base = r.last
+		if total < r.last {
+			r.base = 0
+		}
+	case total < r.last:
+		// Keep the rain counted so far today and carry on from the new total
+		r.base -= r.last
+	}
+	r.day = day
+	r.last = total
+	return total - r.base
+}
+
+// decodeMQTTPayload decodes a JSON object or a single number.  A bare number, as
+// published by Home Assistant state topics or rtl_433 in per-value topic mode, is
+// returned under the last segment of the topic name.
+func decodeMQTTPayload(topic string, payload []byte) (map[string]interface{}, bool) {
+	var values map[string]interface{}
+	if json.Unmarshal(payload, &values) == nil {
+		return values, true
+	}
+
+	v, err := strconv.ParseFloat(strings.TrimSpace(string(payload)), 64)
+	if err != nil {
+		return nil, false
+	}
+	name := topic[strings.LastIndex(topic, "/")+1:]
+	return map[string]interface{}{name: v}, false
+}
+
+// lookupMQTTKey finds a key in a decoded message.  Dots separate the keys of
+// nested objects.
+func lookupMQTTKey(values map[string]interface{}, key string) interface{} {
+	if v, ok := values[key]; ok {
+		return v
+	}
+
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+	nested, ok := values[parts[0]].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return lookupMQTTKey(nested, parts[1])
+}
+
+// mqttNumber converts a decoded JSON value into a number.  Home Assistant often
+// publishes numbers as strings.
+func mqttNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
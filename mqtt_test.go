@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMQTTRainCounter(t *testing.T) {
+	day := time.Date(2024, 3, 14, 8, 0, 0, 0, time.Local)
+	next := day.Add(24 * time.Hour)
+	after := next.Add(24 * time.Hour)
+
+	tests := []struct {
+		total float64
+		now   time.Time
+		want  float64
+	}{
+		{10.00, day, 0},
+		{10.25, day, 0.25},
+		{10.50, day, 0.5},
+		// The sensor was reset and counts up from zero again
+		{0.00, day, 0.5},
+		{0.10, day, 0.6},
+		{0.05, day, 0.65},
+		// The first total of the next day includes the rain since the last one
+		{0.15, next, 0.1},
+		{0.40, next, 0.35},
+		// A reset overnight counts up from zero
+		{0.02, after, 0.02},
+		{0.12, after, 0.12},
+	}
+
+	var r mqttRainCounter
+	for i, tt := range tests {
+		if got := r.update(tt.total, tt.now); math.Abs(got-tt.want) > 0.0001 {
+			t.Errorf("update %v: total %v gives %v today, want %v", i, tt.total, got, tt.want)
+		}
+	}
+}
+
+func TestMQTTHandleMessage(t *testing.T) {
+	fields, err := parseMQTTFields(map[string]string{
+		"temperature": "temperature_C, outdoor.temp F",
+		"humidity":    "humidity",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &MQTTProvider{
+		fields:    fields,
+		match:     map[string]string{"id": "1234"},
+		minFields: 2,
+		maxAge:    10 * time.Minute,
+		readings:  make(map[ObservationField]mqttReading),
+	}
+	now := time.Date(2024, 3, 14, 8, 0, 0, 0, time.Local)
+
+	// A neighbor's sensor doesn't match
+	if _, ok := p.handleMessage("rtl_433/events", []byte(`{"model": "Acurite-5n1", "id": 99, "temperature_C": 30}`), now); ok {
+		t.Error("message from another sensor was used")
+	}
+
+	// One field isn't enough
+	if _, ok := p.handleMessage("rtl_433/events", []byte(`{"model": "Acurite-5n1", "id": 1234, "temperature_C": 21.5}`), now); ok {
+		t.Error("observation sent with only one field")
+	}
+
+	// A bare number is named after the last part of its topic
+	obs, ok := p.handleMessage("homeassistant/sensor/humidity", []byte("40"), now.Add(time.Minute))
+	if !ok {
+		t.Fatal("no observation after both fields arrived")
+	}
+	if obs.Temperature != 70.7 || obs.Humidity != 40 || obs.StationID != "Acurite-5n1-1234" {
+		t.Errorf("temperature, humidity, station = %v, %v, %v, want 70.7, 40, Acurite-5n1-1234", obs.Temperature, obs.Humidity, obs.StationID)
+	}
+
+	// Nested keys with an explicit unit, and a reading that has gone stale
+	obs, ok = p.handleMessage("weather/outside", []byte(`{"id": "1234", "outdoor": {"temp": 55}}`), now.Add(15*time.Minute))
+	if ok {
+		t.Errorf("observation sent with a stale humidity: %+v", obs)
+	}
+	if p.readings[FieldTemperature].value != 55 {
+		t.Errorf("nested temperature = %v, want 55", p.readings[FieldTemperature].value)
+	}
+}
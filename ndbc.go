@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chrissnell/weather-bar/internal/noaa"
+)
+
+const ndbcBaseURL = "https://www.ndbc.noaa.gov"
+
+// Buoys measure waves less often than wind, so a value missing from the latest
+// report is taken from an earlier one, as long as it's no older than this
+const ndbcMaxReportAge = 1 * time.Hour
+
+// NDBC marks missing values with MM
+const ndbcMissing = "MM"
+
+func init() {
+	registerProvider("ndbc", newNDBCProvider)
+}
+
+// NDBCProvider fetches the latest standard meteorological report from a National
+// Data Buoy Center buoy or coastal station
+type NDBCProvider struct {
+	baseURL string
+	client  *http.Client
+	station string
+	debug   bool
+
+	// The list of stations, and the station resolved for the most recent point, so
+	// that we only fetch the list once and only search it when our location changes
+	resolvedMutex   sync.Mutex
+	stations        []NDBCStation
+	resolvedPoint   noaa.Point
+	resolvedStation string
+}
+
+// NDBCStation is a station from NDBC's list of active stations
+type NDBCStation struct {
+	ID             string  `xml:"id,attr"`
+	Name           string  `xml:"name,attr"`
+	Latitude       float64 `xml:"lat,attr"`
+	Longitude      float64 `xml:"lon,attr"`
+	Meteorological string  `xml:"met,attr"`
+}
+
+// NDBCStationList encapsulates NDBC's activestations.xml
+type NDBCStationList struct {
+	Stations []NDBCStation `xml:"station"`
+}
+
+// NDBCReport is one row of a realtime2 standard meteorological data file.  It
+// maps each column to its value, leaving out the ones that are missing.
+type NDBCReport struct {
+	Time   time.Time
+	Values map[string]float64
+}
+
+func newNDBCProvider(w *WeatherBar) (WeatherProvider, error) {
+	return &NDBCProvider{
+		baseURL: ndbcBaseURL,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		station: strings.ToUpper(strings.TrimSpace(w.cfg.Weather.NDBCStation)),
+		debug:   *w.debug,
+	}, nil
+}
+
+// Name returns the name of this provider
+func (p *NDBCProvider) Name() string {
+	return "ndbc"
+}
+
+// UpdateInterval returns the polling interval for NDBC reports
+func (p *NDBCProvider) UpdateInterval() time.Duration {
+	return ndbcUpdateInterval
+}
+
+// UsesPoint returns true because we find the nearest buoy ourselves, unless one
+// is given in the config file
+func (p *NDBCProvider) UsesPoint() bool {
+	return true
+}
+
+// SupportedFields returns the fields populated from NDBC reports.  Not every
+// station measures all of them.
+func (p *NDBCProvider) SupportedFields() []ObservationField {
+	return []ObservationField{
+		FieldStationID,
+		FieldTemperature,
+		FieldDewpoint,
+		FieldWindDir,
+		FieldWindSpeed,
+		FieldWindGust,
+		FieldBarometer,
+		FieldVisibility,
+		FieldWaveHeight,
+		FieldWavePeriod,
+		FieldWaterTemperature,
+	}
+}
+
+// FetchObservation fetches the latest report from the configured station, or
+// from the station nearest to the site
+func (p *NDBCProvider) FetchObservation(ctx context.Context, site WeatherSite) (CurrentObservation, error) {
+	stationID := p.station
+	if stationID == "" {
+		var err error
+		stationID, err = p.resolveStation(ctx, site.Point)
+		if err != nil {
+			return CurrentObservation{}, err
+		}
+	}
+
+	if p.debug {
+		log.Println("Fetching conditions for station", stationID, "from NDBC...")
+	}
+
+	body, err := p.get(ctx, p.baseURL+"/data/realtime2/"+stationID+".txt")
+	if err != nil {
+		return CurrentObservation{}, err
+	}
+	defer body.Close()
+
+	reports, err := parseNDBCReports(body)
+	if err != nil {
+		return CurrentObservation{}, fmt.Errorf("unable to parse report from NDBC station %v: %v", stationID, err)
+	}
+	if len(reports) == 0 {
+		return CurrentObservation{}, fmt.Errorf("no reports available from NDBC station %v", stationID)
+	}
+
+	return ndbcObservation(stationID, reports), nil
+}
+
+// resolveStation finds the meteorological station nearest to the given point
+func (p *NDBCProvider) resolveStation(ctx context.Context, point noaa.Point) (string, error) {
+	p.resolvedMutex.Lock()
+	defer p.resolvedMutex.Unlock()
+
+	if p.resolvedStation != "" && p.resolvedPoint == point {
+		return p.resolvedStation, nil
+	}
+
+	if p.stations == nil {
+		body, err := p.get(ctx, p.baseURL+"/activestations.xml")
+		if err != nil {
+			return "", err
+		}
+		defer body.Close()
+
+		var list NDBCStationList
+		err = xml.NewDecoder(body).Decode(&list)
+		if err != nil {
+			return "", fmt.Errorf("unable to parse NDBC station list: %v", err)
+		}
+		for _, s := range list.Stations {
+			if s.Meteorological == "y" {
+				p.stations = append(p.stations, s)
+			}
+		}
+	}
+
+	var nearest string
+	nearestDistance := 0.0
+	for _, s := range p.stations {
+		p2 := &noaa.Point{Latitude: s.Latitude, Longitude: s.Longitude}
+		distance := point.HaversineDistance(p2)
+		if distance < nearestDistance || nearest == "" {
+			nearest = s.ID
+			nearestDistance = distance
+		}
+	}
+	if nearest == "" {
+		return "", fmt.Errorf("NDBC returned no meteorological stations")
+	}
+
+	p.resolvedPoint = point
+	p.resolvedStation = strings.ToUpper(nearest)
+	if p.debug {
+		log.Printf("Nearest NDBC station: %v (%.0f km)\n", p.resolvedStation, nearestDistance)
+	}
+
+	return p.resolvedStation, nil
+}
+
+// get fetches an NDBC URL and returns the response body, which the caller must close
+func (p *NDBCProvider) get(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	if r.StatusCode != http.StatusOK {
+		r.Body.Close()
+		return nil, fmt.Errorf("error fetching %v: %v", url, r.Status)
+	}
+	return r.Body, nil
+}
+
+// parseNDBCReports parses a realtime2 standard meteorological data file.  The
+// first line names the columns, e.g. "#YY  MM DD hh mm WDIR WSPD GST  WVHT ...",
+// the second gives their units and the reports follow, newest first.
+func parseNDBCReports(r io.Reader) ([]NDBCReport, error) {
+	var columns []string
+	var reports []NDBCReport
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			if columns == nil {
+				columns = strings.Fields(strings.TrimPrefix(line, "#"))
+			}
+			continue
+		}
+		if columns == nil {
+			return nil, fmt.Errorf("missing column headings")
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != len(columns) {
+			return nil, fmt.Errorf("expected %v columns, not %v: %q", len(columns), len(fields), line)
+		}
+
+		report := NDBCReport{Values: make(map[string]float64)}
+		for i, field := range fields {
+			if field == ndbcMissing {
+				continue
+			}
+			v, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for %v: %q", columns[i], field)
+			}
+			report.Values[columns[i]] = v
+		}
+
+		t, ok := report.time()
+		if !ok {
+			return nil, fmt.Errorf("missing report time: %q", line)
+		}
+		report.Time = t
+		reports = append(reports, report)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
+
+// time returns the time of the report, which is given in UTC
+func (r NDBCReport) time() (time.Time, bool) {
+	var parts []int
+	for _, column := range []string{"YY", "MM", "DD", "hh", "mm"} {
+		v, ok := r.Values[column]
+		if !ok {
+			return time.Time{}, false
+		}
+		parts = append(parts, int(v))
+	}
+	return time.Date(parts[0], time.Month(parts[1]), parts[2], parts[3], parts[4], 0, 0, time.UTC), true
+}
+
+// ndbcObservation maps NDBC reports into a CurrentObservation.  Each value comes
+// from the latest report, or from an earlier one if the latest doesn't have it.
+func ndbcObservation(stationID string, reports []NDBCReport) CurrentObservation {
+	latest := reports[0]
+
+	value := func(column string) (float64, bool) {
+		for _, r := range reports {
+			if latest.Time.Sub(r.Time) > ndbcMaxReportAge {
+				break
+			}
+			if v, ok := r.Values[column]; ok {
+				return v, true
+			}
+		}
+		return 0, false
+	}
+
+	obs := CurrentObservation{ObservedAt: latest.Time}
+	obs.setText(FieldStationID, stationID)
+
+	if v, ok := value("WDIR"); ok {
+		obs.set(FieldWindDir, v)
+	}
+	if v, ok := value("WSPD"); ok {
+		obs.set(FieldWindSpeed, roundTenth(v/metersPerSecondPerMph))
+	}
+	if v, ok := value("GST"); ok {
+		obs.set(FieldWindGust, roundTenth(v/metersPerSecondPerMph))
+	}
+	if v, ok := value("PRES"); ok {
+		obs.set(FieldBarometer, v)
+	}
+	if v, ok := value("ATMP"); ok {
+		obs.set(FieldTemperature, roundTenth(celsiusToFahrenheit(v)))
+	}
+	if v, ok := value("DEWP"); ok {
+		obs.set(FieldDewpoint, roundTenth(celsiusToFahrenheit(v)))
+	}
+	if v, ok := value("VIS"); ok {
+		obs.set(FieldVisibility, roundTenth(v*milesPerNauticalMile))
+	}
+	if v, ok := value("WVHT"); ok {
+		obs.set(FieldWaveHeight, roundTenth(v*feetPerMeter))
+	}
+	if v, ok := value("DPD"); ok {
+		obs.set(FieldWavePeriod, v)
+	}
+	if v, ok := value("WTMP"); ok {
+		obs.set(FieldWaterTemperature, roundTenth(celsiusToFahrenheit(v)))
+	}
+
+	return obs
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// A realtime2 file from station 46026, newest report first.  The latest report
+// is missing its waves and water temperature, which are only measured every
+// half hour or so, and the visibility is only in a report from too long ago.
+const ndbcRealtime2 = `#YY  MM DD hh mm WDIR WSPD GST  WVHT   DPD   APD MWD   PRES  ATMP  WTMP  DEWP  VIS PTDY  TIDE
+#yr  mo dy hr mn degT m/s  m/s     m   sec   sec degT   hPa  degC  degC  degC  nmi  hPa    ft
+2024 03 14 18 50 270  5.0  7.0    MM    MM    MM  MM 1015.2  12.0    MM   8.0   MM    MM    MM
+2024 03 14 18 40 280  4.0  6.0   1.5   9.0   6.5 280 1015.3  12.1  13.5   8.1   MM -0.5    MM
+2024 03 14 18 20 280  4.0  6.0   1.6   9.0   6.6 280 1015.4  12.2  13.6   8.2   MM    MM    MM
+2024 03 14 17 40 290  3.0  5.0   1.7  10.0   6.7 285 1015.5  12.3  13.7   8.3  5.0    MM    MM
+`
+
+func TestNDBCObservation(t *testing.T) {
+	reports, err := parseNDBCReports(strings.NewReader(ndbcRealtime2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 4 {
+		t.Fatalf("parsed %v reports, want 4", len(reports))
+	}
+	if _, ok := reports[0].Values["WVHT"]; ok {
+		t.Error("a value marked MM was parsed")
+	}
+
+	obs := ndbcObservation("46026", reports)
+	if want := time.Date(2024, 3, 14, 18, 50, 0, 0, time.UTC); !obs.ObservedAt.Equal(want) {
+		t.Errorf("observed at %v, want %v", obs.ObservedAt, want)
+	}
+
+	tests := []struct {
+		field ObservationField
+		got   float64
+		want  float64
+	}{
+		// From the latest report, with wind in m/s
+		{FieldWindDir, obs.WindDir, 270},
+		{FieldWindSpeed, obs.WindSpeed, 11.2},
+		{FieldWindGust, obs.WindGust, 15.7},
+		{FieldBarometer, obs.Barometer, 1015.2},
+		{FieldTemperature, obs.Temperature, 53.6},
+		{FieldDewpoint, obs.Dewpoint, 46.4},
+		// From the report before, with wave heights in meters
+		{FieldWaveHeight, obs.WaveHeight, 4.9},
+		{FieldWavePeriod, obs.WavePeriod, 9},
+		{FieldWaterTemperature, obs.WaterTemperature, 56.3},
+	}
+	for _, tt := range tests {
+		if !obs.Has(tt.field) || tt.got != tt.want {
+			t.Errorf("%v = %v (filled %v), want %v", tt.field, tt.got, obs.Has(tt.field), tt.want)
+		}
+	}
+
+	// The only visibility is more than an hour older than the latest report
+	if obs.Has(FieldVisibility) {
+		t.Errorf("visibility = %v from a report that's too old", obs.Visibility)
+	}
+	if obs.StationID != "46026" {
+		t.Errorf("station = %q, want 46026", obs.StationID)
+	}
+}
+
+func TestParseNDBCReportsErrors(t *testing.T) {
+	const heading = "#YY  MM DD hh mm WDIR WSPD\n#yr  mo dy hr mn degT m/s\n"
+
+	tests := []struct {
+		name string
+		text string
+		err  string
+	}{
+		{
+			name: "too few columns",
+			text: heading + "2024 03 14 18 50 270\n",
+			err:  `expected 7 columns, not 6: "2024 03 14 18 50 270"`,
+		},
+		{
+			name: "too many columns",
+			text: heading + "2024 03 14 18 50 270 5.0 7.0\n",
+			err:  `expected 7 columns, not 8: "2024 03 14 18 50 270 5.0 7.0"`,
+		},
+		{
+			name: "no headings",
+			text: "2024 03 14 18 50 270 5.0\n",
+			err:  "missing column headings",
+		},
+		{
+			name: "invalid value",
+			text: heading + "2024 03 14 18 50 270 calm\n",
+			err:  `invalid value for WSPD: "calm"`,
+		},
+		{
+			name: "missing time",
+			text: heading + "2024 03 MM 18 50 270 5.0\n",
+			err:  `missing report time: "2024 03 MM 18 50 270 5.0"`,
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := parseNDBCReports(strings.NewReader(tt.text))
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%v: error = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
@@ -1,37 +1,131 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"time"
+
+	"github.com/chrissnell/weather-bar/internal/noaa"
 )
 
-func (w *WeatherBar) getCurrentConditionsFromNOAA(icao string) error {
-	if *w.debug {
-		log.Println("Fetching conditions for station", icao, "from NOAA...")
+func init() {
+	registerProvider("noaa", newNOAAProvider)
+}
+
+// NOAAProvider fetches current conditions from NOAA's XML observation feed
+type NOAAProvider struct {
+	debug bool
+}
+
+func newNOAAProvider(w *WeatherBar) (WeatherProvider, error) {
+	return &NOAAProvider{debug: *w.debug}, nil
+}
+
+// Name returns the name of this provider
+func (p *NOAAProvider) Name() string {
+	return "noaa"
+}
+
+// UpdateInterval returns the polling interval for NOAA
+func (p *NOAAProvider) UpdateInterval() time.Duration {
+	return noaaUpdateInterval
+}
+
+// SupportedFields returns the fields populated by NOAA observations.  Stations
+// only report rainfall in their XML feed if they have a rain gauge.
+func (p *NOAAProvider) SupportedFields() []ObservationField {
+	return []ObservationField{
+		FieldStationID,
+		FieldWeather,
+		FieldTemperature,
+		FieldHumidity,
+		FieldDewpoint,
+		FieldWindChill,
+		FieldHeatIndex,
+		FieldFeelsLike,
+		FieldBarometer,
+		FieldWindSpeed,
+		FieldWindDir,
+		FieldWindGust,
+		FieldRainToday,
+		FieldRain1Hour,
+		FieldVisibility,
+	}
+}
+
+// FetchObservation fetches the current conditions for the site's station from NOAA
+func (p *NOAAProvider) FetchObservation(ctx context.Context, site WeatherSite) (CurrentObservation, error) {
+	if p.debug {
+		log.Println("Fetching conditions for station", site.StationID, "from NOAA...")
 	}
+
 	// Fetch current conditions
-	w.stationMutex.RLock()
-	conditions := w.station.CurrentConditions()
-	w.stationMutex.RUnlock()
+	station := noaa.Station{Id: site.StationID}
+	conditions := station.CurrentConditions()
 
 	// If we didn't get back a StationID, something went wrong
 	// with weather fetching so don't bother sending a new observation.
 	if conditions.StationId == "" {
-		return fmt.Errorf("unable to fetch observation for %v", w.station.Id)
+		return CurrentObservation{}, fmt.Errorf("unable to fetch observation for %v", site.StationID)
+	}
+
+	return noaaObservation(conditions), nil
+}
+
+// noaaObservation maps NOAA's current conditions into a CurrentObservation
+func noaaObservation(c *noaa.CurrentCondition) CurrentObservation {
+	obs := CurrentObservation{ObservedAt: c.ObservationTime}
+	obs.setText(FieldStationID, c.StationId)
+	obs.setText(FieldWeather, c.Weather)
+
+	// NOAA leaves out the values that a station didn't measure, so we check for
+	// their text versions to tell a missing value from a real zero.  Values that
+	// have no text version are never zero when they're measured.
+	if c.TemperatureString != "" {
+		obs.set(FieldTemperature, c.TemperatureF)
+		obs.set(FieldFeelsLike, c.TemperatureF)
+	}
+	if c.DewpointString != "" {
+		obs.set(FieldDewpoint, c.DewpointF)
+	}
+	if c.RelativeHumidity != 0 {
+		obs.set(FieldHumidity, c.RelativeHumidity)
+	}
+	if c.WindString != "" {
+		obs.set(FieldWindSpeed, c.WindMph)
+		obs.set(FieldWindDir, c.WindDegrees)
+	}
+	if c.WindGustMph != 0 {
+		obs.set(FieldWindGust, c.WindGustMph)
+	}
+	if c.VisibilityMi != 0 {
+		obs.set(FieldVisibility, c.VisibilityMi)
+	}
+	if c.PrecipTodayString != "" {
+		obs.set(FieldRainToday, c.PrecipTodayIn)
+	}
+	if c.Precip1hrString != "" {
+		obs.set(FieldRain1Hour, c.Precip1hrIn)
 	}
 
-	obs := CurrentObservation{
-		StationID:   conditions.StationId,
-		Temperature: conditions.TemperatureF,
-		Barometer:   conditions.PressureMB,
-		WindSpeed:   conditions.WindMph,
-		WindDir:     conditions.WindDegrees,
+	// Some stations only give the altimeter setting in inches of mercury
+	if c.PressureMB != 0 {
+		obs.set(FieldBarometer, c.PressureMB)
+	} else if c.PressureIn != 0 {
+		obs.set(FieldBarometer, roundTenth(c.PressureIn*millibarsPerInchHg))
 	}
 
-	if *w.debug {
-		log.Printf("Current observation: %+v\n", obs)
+	// NOAA only includes the wind chill and heat index when they apply.  The
+	// feels-like temperature is whichever of them applies.
+	if c.WindchillString != "" {
+		obs.set(FieldWindChill, c.WindchillF)
+		obs.set(FieldFeelsLike, c.WindchillF)
+	}
+	if c.HeatIndexString != "" {
+		obs.set(FieldHeatIndex, c.HeatIndexF)
+		obs.set(FieldFeelsLike, c.HeatIndexF)
 	}
 
-	w.wxObsChan <- obs
-	return nil
+	return obs
 }
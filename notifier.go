@@ -0,0 +1,34 @@
+package main
+
+import "sync"
+
+// notifier tells everything that has subscribed to it when something happens,
+// such as when we move or wake up from sleep
+type notifier struct {
+	mutex sync.Mutex
+	chans []chan struct{}
+}
+
+// subscribe returns a channel that receives a value whenever the notifier
+// announces something.  Subscribe before starting the goroutine that announces,
+// so that nothing is missed.
+func (n *notifier) subscribe() <-chan struct{} {
+	c := make(chan struct{}, 1)
+	n.mutex.Lock()
+	n.chans = append(n.chans, c)
+	n.mutex.Unlock()
+	return c
+}
+
+// announce tells every subscriber that something has happened.  Subscribers that
+// haven't caught up with the last announcement don't need telling twice.
+func (n *notifier) announce() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for _, c := range n.chans {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
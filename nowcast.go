@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chrissnell/weather-bar/internal/noaa"
+)
+
+// Precipitation below this many inches in 15 minutes doesn't count as rain
+const nowcastWetThreshold = 0.004
+
+// How far ahead the nowcast looks
+const nowcastHorizon = 1 * time.Hour
+
+// The length of each step of Open-Meteo's 15-minutely forecast
+const nowcastStep = 15 * time.Minute
+
+// Classes set by the nowcast, for use in conditional formats
+const (
+	classPrecipNow  = "precip-now"
+	classPrecipSoon = "precip-soon"
+)
+
+// Nowcast is the precipitation expected in each 15-minute step over the next
+// couple of hours.  Each amount is the precipitation during the step that ends
+// at its time.
+type Nowcast struct {
+	Times    []time.Time
+	Precip   []float64
+	Snowfall []float64
+}
+
+// OpenMeteoMinutely encapsulates an Open-Meteo forecast API response with
+// 15-minutely data
+type OpenMeteoMinutely struct {
+	Timezone   string `json:"timezone"`
+	UTCOffset  int    `json:"utc_offset_seconds"`
+	Minutely15 struct {
+		Time          []string   `json:"time"`
+		Precipitation []*float64 `json:"precipitation"`
+		Snowfall      []*float64 `json:"snowfall"`
+	} `json:"minutely_15"`
+}
+
+// usesNowcast returns true if the format shows the nowcast or tests its classes
+func usesNowcast(format string) bool {
+	return strings.Contains(format, "%precip-nowcast%") || usesClasses(format, classPrecipNow, classPrecipSoon)
+}
+
+// nowcastWatcher fetches the precipitation nowcast for our location every few
+// minutes, and again whenever we move
+func (w *WeatherBar) nowcastWatcher(ctx context.Context, moved <-chan struct{}) {
+	ticker := time.NewTicker(nowcastUpdateInterval)
+	defer ticker.Stop()
+
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	point, ok := w.waitForPoint(ctx)
+	if !ok {
+		return
+	}
+
+	for {
+		err := w.updateNowcast(ctx, client, point)
+		if err != nil {
+			log.Println(err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-moved:
+		case <-ctx.Done():
+			log.Println("Termination request recieved.  Cancelling nowcast watcher.")
+			return
+		}
+		point = w.currentSite().Point
+	}
+}
+
+// updateNowcast fetches the nowcast for the point and redraws the bar with it
+func (w *WeatherBar) updateNowcast(ctx context.Context, client *http.Client, point noaa.Point) error {
+	if *w.debug {
+		log.Printf("Fetching precipitation nowcast for %.4f,%.4f from Open-Meteo...\n", point.Latitude, point.Longitude)
+	}
+
+	n, err := fetchOpenMeteoNowcast(ctx, client, openMeteoAPIBaseURL, point)
+	if err != nil {
+		return fmt.Errorf("unable to fetch precipitation nowcast: %v", err)
+	}
+
+	w.nowcastMutex.Lock()
+	w.nowcast = n
+	w.nowcastMutex.Unlock()
+
+	w.redraw()
+	return nil
+}
+
+// currentNowcast returns the latest nowcast
+func (w *WeatherBar) currentNowcast() Nowcast {
+	w.nowcastMutex.RLock()
+	defer w.nowcastMutex.RUnlock()
+	return w.nowcast
+}
+
+// fetchOpenMeteoNowcast fetches the 15-minutely precipitation forecast for a point
+// from Open-Meteo
+func fetchOpenMeteoNowcast(ctx context.Context, client *http.Client, baseURL string, point noaa.Point) (Nowcast, error) {
+	q := url.Values{}
+	q.Set("latitude", strconv.FormatFloat(point.Latitude, 'f', 4, 64))
+	q.Set("longitude", strconv.FormatFloat(point.Longitude, 'f', 4, 64))
+	q.Set("minutely_15", "precipitation,snowfall")
+	q.Set("past_minutely_15", "1")
+	q.Set("forecast_minutely_15", "8")
+	q.Set("precipitation_unit", "inch")
+	q.Set("timezone", "auto")
+
+	var om OpenMeteoMinutely
+	err := openMeteoGet(ctx, client, baseURL+"/v1/forecast?"+q.Encode(), &om)
+	if err != nil {
+		return Nowcast{}, err
+	}
+
+	return om.toNowcast(), nil
+}
+
+// toNowcast maps the Open-Meteo response into a Nowcast.  Steps without a value
+// are dropped.
+func (om OpenMeteoMinutely) toNowcast() Nowcast {
+	var n Nowcast
+
+	// Times are local to the grid point
+	zone := openMeteoLocation(om.Timezone, om.UTCOffset)
+	for i, ts := range om.Minutely15.Time {
+		precip := hourlyValue(om.Minutely15.Precipitation, i)
+		t, err := time.ParseInLocation(openMeteoTimeFormat, ts, zone)
+		if err != nil || precip == nil {
+			continue
+		}
+
+		snowfall := 0.0
+		if v := hourlyValue(om.Minutely15.Snowfall, i); v != nil {
+			snowfall = *v
+		}
+
+		n.Times = append(n.Times, t)
+		n.Precip = append(n.Precip, *precip)
+		n.Snowfall = append(n.Snowfall, snowfall)
+	}
+
+	return n
+}
+
+// upcoming returns the index of the step that we're in now and the indexes of
+// the steps that follow it within the horizon
+func (n Nowcast) upcoming(now time.Time) []int {
+	var steps []int
+	for i, t := range n.Times {
+		if !t.After(now) {
+			continue
+		}
+		if t.Sub(now) > nowcastHorizon+nowcastStep {
+			break
+		}
+		steps = append(steps, i)
+	}
+	return steps
+}
+
+// wet returns true if the step has enough precipitation to count
+func (n Nowcast) wet(i int) bool {
+	return n.Precip[i] >= nowcastWetThreshold
+}
+
+// kind names the precipitation in a step
+func (n Nowcast) kind(i int) string {
+	if n.Snowfall[i] > 0 {
+		return "Snow"
+	}
+	return "Rain"
+}
+
+// Text describes what the precipitation will do over the next hour, e.g. "Rain in
+// 20 min".  It's blank if we have no nowcast for now.
+func (n Nowcast) Text(now time.Time) string {
+	steps := n.upcoming(now)
+	if len(steps) == 0 {
+		return ""
+	}
+
+	current := steps[0]
+	for _, i := range steps[1:] {
+		// A step starts 15 minutes before its time
+		minutes := nowcastMinutes(n.Times[i].Add(-nowcastStep).Sub(now))
+		if n.wet(i) != n.wet(current) {
+			if n.wet(current) {
+				return fmt.Sprintf("%v ending in %v min", n.kind(current), minutes)
+			}
+			return fmt.Sprintf("%v in %v min", n.kind(i), minutes)
+		}
+	}
+
+	if n.wet(current) {
+		return fmt.Sprintf("%v for the next hour", n.kind(current))
+	}
+	return "Dry next hour"
+}
+
+// Classes sets the precip-now class if it's raining or snowing now and the
+// precip-soon class if it is or will be within the next hour
+func (n Nowcast) Classes(now time.Time, classes map[string]bool) {
+	steps := n.upcoming(now)
+	for k, i := range steps {
+		if !n.wet(i) {
+			continue
+		}
+		if k == 0 {
+			classes[classPrecipNow] = true
+		}
+		classes[classPrecipSoon] = true
+	}
+}
+
+// nowcastMinutes rounds a wait up to the next 5 minutes, since the nowcast is
+// nowhere near precise enough for anything finer
+func nowcastMinutes(d time.Duration) int {
+	minutes := int(math.Ceil(d.Minutes()/5) * 5)
+	if minutes < 5 {
+		minutes = 5
+	}
+	return minutes
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNowcast(t *testing.T) {
+	start := time.Date(2024, 3, 14, 12, 15, 0, 0, time.UTC)
+	now := start.Add(-10 * time.Minute)
+
+	// nowcast builds a nowcast of 15-minute steps ending at 12:15, 12:30 and so
+	// on, with the same snowfall throughout
+	nowcast := func(snowfall float64, precip ...float64) Nowcast {
+		var n Nowcast
+		for i, p := range precip {
+			n.Times = append(n.Times, start.Add(time.Duration(i)*nowcastStep))
+			n.Precip = append(n.Precip, p)
+			n.Snowfall = append(n.Snowfall, snowfall)
+		}
+		return n
+	}
+
+	tests := []struct {
+		name     string
+		nowcast  Nowcast
+		now      time.Time
+		want     string
+		wantNow  bool
+		wantSoon bool
+	}{
+		{"dry", nowcast(0, 0, 0, 0, 0, 0, 0, 0, 0), now, "Dry next hour", false, false},
+		// The step ending at 12:45 starts 25 minutes from now
+		{"rain coming", nowcast(0, 0, 0, 0.02, 0.05, 0.05, 0, 0, 0), now, "Rain in 25 min", false, true},
+		{"rain stopping", nowcast(0, 0.02, 0.01, 0.01, 0, 0, 0, 0, 0), now, "Rain ending in 40 min", true, true},
+		{"rain next step", nowcast(0, 0, 0.02, 0.02, 0.02, 0.02, 0, 0, 0), start.Add(-time.Minute), "Rain in 5 min", false, true},
+		{"snow", nowcast(0.1, 0.04, 0.04, 0.04, 0.04, 0.04, 0.04, 0.04, 0.04), now, "Snow for the next hour", true, true},
+		{"trace", nowcast(0, 0.003, 0.003, 0, 0, 0, 0, 0, 0), now, "Dry next hour", false, false},
+		// The step ending at 13:30 is beyond the next hour
+		{"rain later", nowcast(0, 0, 0, 0, 0, 0, 0.1, 0.1, 0.1), now, "Dry next hour", false, false},
+		{"empty", Nowcast{}, now, "", false, false},
+		{"out of date", nowcast(0, 0.02, 0.02), start.Add(time.Hour), "", false, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.nowcast.Text(tt.now); got != tt.want {
+			t.Errorf("%v: text = %q, want %q", tt.name, got, tt.want)
+		}
+
+		classes := make(map[string]bool)
+		tt.nowcast.Classes(tt.now, classes)
+		want := make(map[string]bool)
+		if tt.wantNow {
+			want[classPrecipNow] = true
+		}
+		if tt.wantSoon {
+			want[classPrecipSoon] = true
+		}
+		if !reflect.DeepEqual(classes, want) {
+			t.Errorf("%v: classes = %v, want %v", tt.name, classes, want)
+		}
+	}
+}
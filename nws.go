@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chrissnell/weather-bar/internal/noaa"
+)
+
+const nwsAPIBaseURL = "https://api.weather.gov"
+
+// The NWS API rejects requests that don't identify the calling application
+const nwsUserAgent = "weather-bar (https://github.com/chrissnell/weather-bar)"
+
+func init() {
+	registerProvider("nws", newNWSProvider)
+}
+
+// NWSProvider fetches current conditions from the National Weather Service's JSON API
+type NWSProvider struct {
+	baseURL string
+	client  *http.Client
+	station string
+	debug   bool
+
+	// The observation station resolved for the most recent point, so that we only
+	// hit the /points endpoint when our location changes
+	resolvedMutex   sync.Mutex
+	resolvedPoint   noaa.Point
+	resolvedStation string
+}
+
+// NWSPoint encapsulates the API response for a /points/{lat},{lon} request
+type NWSPoint struct {
+	Properties struct {
+		GridID              string `json:"gridId"`
+		GridX               int    `json:"gridX"`
+		GridY               int    `json:"gridY"`
+		ObservationStations string `json:"observationStations"`
+	} `json:"properties"`
+}
+
+// NWSStationCollection encapsulates the list of observation stations for a grid
+type NWSStationCollection struct {
+	Features []struct {
+		Properties struct {
+			StationIdentifier string `json:"stationIdentifier"`
+			Name              string `json:"name"`
+		} `json:"properties"`
+	} `json:"features"`
+}
+
+// NWSObservation encapsulates the API response for a station observation
+type NWSObservation struct {
+	Properties struct {
+		Station               string      `json:"station"`
+		Timestamp             time.Time   `json:"timestamp"`
+		TextDescription       string      `json:"textDescription"`
+		Temperature           NWSQuantity `json:"temperature"`
+		Dewpoint              NWSQuantity `json:"dewpoint"`
+		WindDirection         NWSQuantity `json:"windDirection"`
+		WindSpeed             NWSQuantity `json:"windSpeed"`
+		WindGust              NWSQuantity `json:"windGust"`
+		BarometricPressure    NWSQuantity `json:"barometricPressure"`
+		SeaLevelPressure      NWSQuantity `json:"seaLevelPressure"`
+		PrecipitationLastHour NWSQuantity `json:"precipitationLastHour"`
+		RelativeHumidity      NWSQuantity `json:"relativeHumidity"`
+		WindChill             NWSQuantity `json:"windChill"`
+		HeatIndex             NWSQuantity `json:"heatIndex"`
+	} `json:"properties"`
+}
+
+// NWSQuantity is a value with a unit of measure.  The value is null when the station
+// didn't report it or when it failed the NWS's quality control checks.
+type NWSQuantity struct {
+	UnitCode       string   `json:"unitCode"`
+	Value          *float64 `json:"value"`
+	QualityControl string   `json:"qualityControl"`
+}
+
+// NWSProblem is the error body returned by the NWS API
+type NWSProblem struct {
+	Title  string `json:"title"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+func newNWSProvider(w *WeatherBar) (WeatherProvider, error) {
+	return &NWSProvider{
+		baseURL: nwsAPIBaseURL,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		station: w.cfg.Weather.Station,
+		debug:   *w.debug,
+	}, nil
+}
+
+// Name returns the name of this provider
+func (p *NWSProvider) Name() string {
+	return "nws"
+}
+
+// UpdateInterval returns the polling interval for the NWS API
+func (p *NWSProvider) UpdateInterval() time.Duration {
+	return nwsUpdateInterval
+}
+
+// SupportedFields returns the fields populated by NWS observations
+func (p *NWSProvider) SupportedFields() []ObservationField {
+	return []ObservationField{
+		FieldStationID,
+		FieldWeather,
+		FieldTemperature,
+		FieldHumidity,
+		FieldDewpoint,
+		FieldWindChill,
+		FieldHeatIndex,
+		FieldWindDir,
+		FieldWindSpeed,
+		FieldWindGust,
+		FieldBarometer,
+		FieldRain1Hour,
+	}
+}
+
+// FetchObservation fetches the latest observation for the site from the NWS API.
+// A station hardcoded in the config file is always used.  Otherwise, we ask the
+// API which observation stations serve our point and use the first of them.
+func (p *NWSProvider) FetchObservation(ctx context.Context, site WeatherSite) (CurrentObservation, error) {
+	stationID := p.station
+	if stationID == "" && (site.Point.Latitude != 0 || site.Point.Longitude != 0) {
+		var err error
+		stationID, err = p.resolveStation(ctx, site.Point)
+		if err != nil {
+			if site.StationID == "" {
+				return CurrentObservation{}, err
+			}
+			log.Println("error resolving NWS observation station:", err)
+		}
+	}
+	if stationID == "" {
+		stationID = site.StationID
+	}
+	if stationID == "" {
+		return CurrentObservation{}, fmt.Errorf("no NWS observation station available")
+	}
+
+	if p.debug {
+		log.Println("Fetching conditions for station", stationID, "from the NWS API...")
+	}
+
+	var nwsObs NWSObservation
+	err := p.get(ctx, p.baseURL+"/stations/"+stationID+"/observations/latest", &nwsObs)
+	if err != nil {
+		return CurrentObservation{}, err
+	}
+
+	return nwsObs.toCurrentObservation(stationID), nil
+}
+
+// resolveStation finds the observation station nearest to the given point
+func (p *NWSProvider) resolveStation(ctx context.Context, point noaa.Point) (string, error) {
+	p.resolvedMutex.Lock()
+	defer p.resolvedMutex.Unlock()
+
+	if p.resolvedStation != "" && p.resolvedPoint == point {
+		return p.resolvedStation, nil
+	}
+
+	// The API redirects requests with more than four decimal places of precision
+	var nwsPoint NWSPoint
+	err := p.get(ctx, fmt.Sprintf("%v/points/%.4f,%.4f", p.baseURL, point.Latitude, point.Longitude), &nwsPoint)
+	if err != nil {
+		return "", err
+	}
+	if nwsPoint.Properties.ObservationStations == "" {
+		return "", fmt.Errorf("NWS returned no observation stations for %.4f,%.4f", point.Latitude, point.Longitude)
+	}
+
+	// The station list URL is absolute, pointing at the real API.  Rebase it on our
+	// configured base URL so that we always talk to the same server.
+	stationsURL := nwsPoint.Properties.ObservationStations
+	if i := strings.Index(stationsURL, "/gridpoints/"); i >= 0 {
+		stationsURL = p.baseURL + stationsURL[i:]
+	}
+
+	var stations NWSStationCollection
+	err = p.get(ctx, stationsURL, &stations)
+	if err != nil {
+		return "", err
+	}
+	if len(stations.Features) == 0 {
+		return "", fmt.Errorf("NWS returned no observation stations for grid %v %v,%v",
+			nwsPoint.Properties.GridID, nwsPoint.Properties.GridX, nwsPoint.Properties.GridY)
+	}
+
+	p.resolvedPoint = point
+	p.resolvedStation = stations.Features[0].Properties.StationIdentifier
+	if p.debug {
+		log.Println("Nearest NWS observation station:", p.resolvedStation)
+	}
+
+	return p.resolvedStation, nil
+}
+
+// get fetches an NWS API URL and decodes the JSON response into v
+func (p *NWSProvider) get(ctx context.Context, url string, v interface{}) error {
+	return nwsGet(ctx, p.client, url, v)
+}
+
+// nwsGet fetches an NWS API URL with the given client and decodes the JSON
+// response into v
+func nwsGet(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", nwsUserAgent)
+	req.Header.Set("Accept", "application/geo+json")
+
+	r, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		var problem NWSProblem
+		if json.NewDecoder(r.Body).Decode(&problem) == nil && problem.Detail != "" {
+			return fmt.Errorf("NWS API error fetching %v: %v (%v)", url, problem.Detail, r.StatusCode)
+		}
+		return fmt.Errorf("NWS API error fetching %v: %v", url, r.Status)
+	}
+
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+// toCurrentObservation converts the NWS's SI units into the units used by CurrentObservation
+func (o NWSObservation) toCurrentObservation(stationID string) CurrentObservation {
+	props := o.Properties
+	obs := CurrentObservation{ObservedAt: props.Timestamp}
+	obs.setText(FieldStationID, stationID)
+	obs.setText(FieldWeather, props.TextDescription)
+
+	if v, ok := props.Temperature.fahrenheit(); ok {
+		obs.set(FieldTemperature, v)
+	}
+	if v, ok := props.Dewpoint.fahrenheit(); ok {
+		obs.set(FieldDewpoint, v)
+	}
+	if v, ok := props.WindChill.fahrenheit(); ok {
+		obs.set(FieldWindChill, v)
+	}
+	if v, ok := props.HeatIndex.fahrenheit(); ok {
+		obs.set(FieldHeatIndex, v)
+	}
+	if v, ok := props.RelativeHumidity.value(); ok {
+		obs.set(FieldHumidity, math.Round(v))
+	}
+	if v, ok := props.WindDirection.value(); ok {
+		obs.set(FieldWindDir, v)
+	}
+	if v, ok := props.WindSpeed.mph(); ok {
+		obs.set(FieldWindSpeed, v)
+	}
+	if v, ok := props.WindGust.mph(); ok {
+		obs.set(FieldWindGust, v)
+	}
+
+	// Prefer sea-level pressure, which is what people expect to see on a barometer
+	if v, ok := props.SeaLevelPressure.millibars(); ok {
+		obs.set(FieldBarometer, v)
+	} else if v, ok := props.BarometricPressure.millibars(); ok {
+		obs.set(FieldBarometer, v)
+	}
+
+	if v, ok := props.PrecipitationLastHour.inches(); ok {
+		obs.set(FieldRain1Hour, v)
+	}
+
+	return obs
+}
+
+// unit strips the "wmoUnit:" or "unit:" namespace from a unit code
+func (q NWSQuantity) unit() string {
+	if i := strings.Index(q.UnitCode, ":"); i >= 0 {
+		return q.UnitCode[i+1:]
+	}
+	return q.UnitCode
+}
+
+// value returns the raw value of the quantity, or false if it was null or
+// rejected by quality control
+func (q NWSQuantity) value() (float64, bool) {
+	if q.Value == nil || q.QualityControl == "X" {
+		return 0, false
+	}
+	return *q.Value, true
+}
+
+// fahrenheit returns a temperature in degrees Fahrenheit, or false if it's missing
+// or in a unit we don't know
+func (q NWSQuantity) fahrenheit() (float64, bool) {
+	v, ok := q.value()
+	if !ok {
+		return 0, false
+	}
+	switch q.unit() {
+	case "degC":
+		return roundTenth(celsiusToFahrenheit(v)), true
+	case "degF":
+		return v, true
+	case "K":
+		return roundTenth(celsiusToFahrenheit(v - 273.15)), true
+	}
+	return 0, false
+}
+
+// mph returns a speed in miles/hour, or false if it's missing or in a unit we
+// don't know
+func (q NWSQuantity) mph() (float64, bool) {
+	v, ok := q.value()
+	if !ok {
+		return 0, false
+	}
+	switch q.unit() {
+	case "km_h-1":
+		return roundTenth(v / kphPerMph), true
+	case "m_s-1":
+		return roundTenth(v / metersPerSecondPerMph), true
+	case "kn":
+		return roundTenth(v * mphPerKnot), true
+	case "mi_h-1":
+		return v, true
+	}
+	return 0, false
+}
+
+// millibars returns a pressure in millibars, or false if it's missing or in a unit
+// we don't know
+func (q NWSQuantity) millibars() (float64, bool) {
+	v, ok := q.value()
+	if !ok {
+		return 0, false
+	}
+	switch q.unit() {
+	case "Pa":
+		return roundTenth(v / 100), true
+	case "hPa":
+		return v, true
+	}
+	return 0, false
+}
+
+// inches returns a precipitation amount in inches, or false if it's missing or in
+// a unit we don't know
+func (q NWSQuantity) inches() (float64, bool) {
+	v, ok := q.value()
+	if !ok {
+		return 0, false
+	}
+	switch q.unit() {
+	case "mm":
+		return v / millimetersPerInch, true
+	case "m":
+		return v * 1000 / millimetersPerInch, true
+	case "in":
+		return v, true
+	}
+	return 0, false
+}
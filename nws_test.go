@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chrissnell/weather-bar/internal/noaa"
+)
+
+// nwsTestObservation has a mix of units, a null gust and a temperature that
+// failed quality control
+const nwsTestObservation = `{
+  "properties": {
+    "station": "https://api.weather.gov/stations/KMHK",
+    "timestamp": "2024-03-14T18:53:00+00:00",
+    "textDescription": "Mostly Cloudy",
+    "temperature": {"unitCode": "wmoUnit:degC", "value": 22.2, "qualityControl": "X"},
+    "dewpoint": {"unitCode": "wmoUnit:degC", "value": -1.1, "qualityControl": "V"},
+    "windDirection": {"unitCode": "wmoUnit:degree_(angle)", "value": 0, "qualityControl": "V"},
+    "windSpeed": {"unitCode": "wmoUnit:km_h-1", "value": 18.5, "qualityControl": "V"},
+    "windGust": {"unitCode": "wmoUnit:km_h-1", "value": null, "qualityControl": "Z"},
+    "barometricPressure": {"unitCode": "wmoUnit:Pa", "value": 101320, "qualityControl": "V"},
+    "seaLevelPressure": {"unitCode": "wmoUnit:Pa", "value": null, "qualityControl": "Z"},
+    "precipitationLastHour": {"unitCode": "wmoUnit:mm", "value": 0, "qualityControl": "C"},
+    "relativeHumidity": {"unitCode": "wmoUnit:percent", "value": 21.46, "qualityControl": "V"},
+    "windChill": {"unitCode": "wmoUnit:degC", "value": null, "qualityControl": "V"},
+    "heatIndex": {"unitCode": "wmoUnit:degC", "value": null, "qualityControl": "V"}
+  }
+}`
+
+func TestNWSFetchObservation(t *testing.T) {
+	var requests []string
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		if r.Header.Get("User-Agent") != nwsUserAgent {
+			http.Error(w, `{"title": "Forbidden", "detail": "A User-Agent is required", "status": 403}`, http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/points/39.1836,-96.5717":
+			// The station list points at the real API, not at us
+			fmt.Fprint(w, `{"properties": {"gridId": "TOP", "gridX": 30, "gridY": 70,
+				"observationStations": "https://api.weather.gov/gridpoints/TOP/30,70/stations"}}`)
+		case "/gridpoints/TOP/30,70/stations":
+			fmt.Fprint(w, `{"features": [{"properties": {"stationIdentifier": "KMHK", "name": "Manhattan Regional Airport"}},
+				{"properties": {"stationIdentifier": "KFRI", "name": "Fort Riley"}}]}`)
+		case "/stations/KMHK/observations/latest":
+			fmt.Fprint(w, nwsTestObservation)
+		default:
+			http.Error(w, `{"title": "Not Found", "detail": "No such station", "status": 404}`, http.StatusNotFound)
+		}
+	})
+
+	p := &NWSProvider{baseURL: server.URL, client: server.Client()}
+	site := WeatherSite{Point: noaa.Point{Latitude: 39.18361, Longitude: -96.57167}}
+
+	obs, err := p.FetchObservation(context.Background(), site)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"/points/39.1836,-96.5717", "/gridpoints/TOP/30,70/stations", "/stations/KMHK/observations/latest"}
+	if fmt.Sprint(requests) != fmt.Sprint(want) {
+		t.Errorf("requests = %v, want %v", requests, want)
+	}
+
+	if obs.StationID != "KMHK" || obs.Weather != "Mostly Cloudy" {
+		t.Errorf("station, weather = %q, %q, want KMHK, Mostly Cloudy", obs.StationID, obs.Weather)
+	}
+	if !obs.ObservedAt.Equal(time.Date(2024, 3, 14, 18, 53, 0, 0, time.UTC)) {
+		t.Errorf("observed at %v", obs.ObservedAt)
+	}
+
+	filled := map[ObservationField]float64{
+		FieldDewpoint:  30,
+		FieldWindDir:   0,
+		FieldWindSpeed: 11.5,
+		FieldBarometer: 1013.2,
+		FieldRain1Hour: 0,
+		FieldHumidity:  21,
+	}
+	for f, v := range filled {
+		if got := *numericFields[f](&obs); !obs.Has(f) || got != v {
+			t.Errorf("%v = %v (filled %v), want %v", f, got, obs.Has(f), v)
+		}
+	}
+
+	// Null values and values that failed quality control are missing, not zero
+	for _, f := range []ObservationField{FieldTemperature, FieldWindGust, FieldWindChill, FieldHeatIndex} {
+		if obs.Has(f) {
+			t.Errorf("%v = %v, want it left empty", f, *numericFields[f](&obs))
+		}
+	}
+
+	// The station is only resolved once for a point
+	requests = nil
+	_, err = p.FetchObservation(context.Background(), site)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 {
+		t.Errorf("second fetch made requests %v, want just the observation", requests)
+	}
+
+	// Errors carry the detail from the API's problem response
+	p.station = "KXXX"
+	_, err = p.FetchObservation(context.Background(), site)
+	if err == nil || err.Error() != fmt.Sprintf("NWS API error fetching %v/stations/KXXX/observations/latest: No such station (404)", server.URL) {
+		t.Errorf("fetching an unknown station returned %v", err)
+	}
+}
+
+func TestNWSQuantityUnits(t *testing.T) {
+	value := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		q      NWSQuantity
+		unit   func(NWSQuantity) (float64, bool)
+		want   float64
+		wantOK bool
+	}{
+		{NWSQuantity{UnitCode: "wmoUnit:degC", Value: value(-40)}, NWSQuantity.fahrenheit, -40, true},
+		{NWSQuantity{UnitCode: "wmoUnit:degF", Value: value(71.3)}, NWSQuantity.fahrenheit, 71.3, true},
+		{NWSQuantity{UnitCode: "wmoUnit:K", Value: value(273.15)}, NWSQuantity.fahrenheit, 32, true},
+		{NWSQuantity{UnitCode: "wmoUnit:Cel", Value: value(20)}, NWSQuantity.fahrenheit, 0, false},
+		{NWSQuantity{UnitCode: "wmoUnit:degC", Value: value(20), QualityControl: "X"}, NWSQuantity.fahrenheit, 0, false},
+		{NWSQuantity{UnitCode: "wmoUnit:degC"}, NWSQuantity.fahrenheit, 0, false},
+		{NWSQuantity{UnitCode: "wmoUnit:km_h-1", Value: value(100)}, NWSQuantity.mph, 62.1, true},
+		{NWSQuantity{UnitCode: "wmoUnit:m_s-1", Value: value(10)}, NWSQuantity.mph, 22.4, true},
+		{NWSQuantity{UnitCode: "unit:kn", Value: value(20)}, NWSQuantity.mph, 23, true},
+		{NWSQuantity{UnitCode: "unit:mi_h-1", Value: value(15)}, NWSQuantity.mph, 15, true},
+		{NWSQuantity{UnitCode: "wmoUnit:Pa", Value: value(101325)}, NWSQuantity.millibars, 1013.3, true},
+		{NWSQuantity{UnitCode: "wmoUnit:hPa", Value: value(1009.8)}, NWSQuantity.millibars, 1009.8, true},
+		{NWSQuantity{UnitCode: "wmoUnit:mm", Value: value(25.4)}, NWSQuantity.inches, 1, true},
+		{NWSQuantity{UnitCode: "wmoUnit:m", Value: value(0.0127)}, NWSQuantity.inches, 0.5, true},
+		{NWSQuantity{UnitCode: "wmoUnit:in", Value: value(0.25)}, NWSQuantity.inches, 0.25, true},
+	}
+
+	for i, tt := range tests {
+		got, ok := tt.unit(tt.q)
+		if ok != tt.wantOK || roundTenth(got*100) != roundTenth(tt.want*100) {
+			t.Errorf("test %v: %v (QC %q) = %v, %v, want %v, %v", i, tt.q.UnitCode, tt.q.QualityControl, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
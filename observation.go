@@ -0,0 +1,149 @@
+package main
+
+import "time"
+
+// CurrentObservation represents the weather conditions right now for a given station.
+// Temperatures are in degrees Fahrenheit, speeds in miles/hour, pressures in millibars
+// and precipitation in inches.
+type CurrentObservation struct {
+	StationID   string
+	Weather     string
+	Temperature float64
+	Humidity    float64
+	Dewpoint    float64
+	WindChill   float64
+	HeatIndex   float64
+	FeelsLike   float64
+	WindDir     float64
+	WindSpeed   float64
+	WindGust    float64
+	Barometer   float64
+	RainToday   float64
+	Rain1Hour   float64
+	RainRate    float64
+
+	// WindVariable is set when the wind has a speed but no steady direction, in
+	// which case WindDir isn't filled in
+	WindVariable bool
+
+	// StationPressure is the pressure at the station, not reduced to sea level
+	StationPressure float64
+
+	// These are only available from NDBC buoys.  Wave height is in feet and the
+	// dominant wave period in seconds.
+	WaveHeight       float64
+	WavePeriod       float64
+	WaterTemperature float64
+
+	// These are never reported by providers, only derived from the other fields.
+	// Humidex is in degrees Celsius, as is customary.
+	Humidex float64
+	WetBulb float64
+
+	// These are only available from stations with indoor sensors
+	IndoorTemperature float64
+	IndoorHumidity    float64
+
+	// These are only available from METAR reports
+	RawMETAR       string
+	Visibility     float64
+	Ceiling        float64
+	HasCeiling     bool
+	PresentWeather string
+
+	// ObservedAt is when the conditions were measured, if the source tells us
+	ObservedAt time.Time
+
+	// Provider is the name of the provider that supplied the conditions
+	Provider string
+
+	// Filled holds the fields that the provider actually filled in.  Zero is a
+	// perfectly good value for most fields, so this is how we tell a missing
+	// value from a real zero.
+	Filled FieldSet
+}
+
+// FieldSet is a set of observation fields.  It's a plain value, so copies of an
+// observation never share it.
+type FieldSet uint64
+
+// fieldBits numbers the observation fields for FieldSet
+var fieldBits = func() map[ObservationField]FieldSet {
+	bits := make(map[ObservationField]FieldSet)
+	for i, f := range observationFields {
+		bits[f] = 1 << uint(i)
+	}
+	return bits
+}()
+
+// Add adds fields to the set
+func (s *FieldSet) Add(fields ...ObservationField) {
+	for _, f := range fields {
+		*s |= fieldBits[f]
+	}
+}
+
+// Has returns true if the field is in the set
+func (s FieldSet) Has(f ObservationField) bool {
+	return s&fieldBits[f] != 0
+}
+
+// numericFields gives the address of each of the numeric observation fields
+var numericFields = map[ObservationField]func(*CurrentObservation) *float64{
+	FieldTemperature:       func(o *CurrentObservation) *float64 { return &o.Temperature },
+	FieldHumidity:          func(o *CurrentObservation) *float64 { return &o.Humidity },
+	FieldDewpoint:          func(o *CurrentObservation) *float64 { return &o.Dewpoint },
+	FieldWindChill:         func(o *CurrentObservation) *float64 { return &o.WindChill },
+	FieldHeatIndex:         func(o *CurrentObservation) *float64 { return &o.HeatIndex },
+	FieldFeelsLike:         func(o *CurrentObservation) *float64 { return &o.FeelsLike },
+	FieldWindDir:           func(o *CurrentObservation) *float64 { return &o.WindDir },
+	FieldWindSpeed:         func(o *CurrentObservation) *float64 { return &o.WindSpeed },
+	FieldWindGust:          func(o *CurrentObservation) *float64 { return &o.WindGust },
+	FieldBarometer:         func(o *CurrentObservation) *float64 { return &o.Barometer },
+	FieldRainToday:         func(o *CurrentObservation) *float64 { return &o.RainToday },
+	FieldRain1Hour:         func(o *CurrentObservation) *float64 { return &o.Rain1Hour },
+	FieldRainRate:          func(o *CurrentObservation) *float64 { return &o.RainRate },
+	FieldStationPressure:   func(o *CurrentObservation) *float64 { return &o.StationPressure },
+	FieldHumidex:           func(o *CurrentObservation) *float64 { return &o.Humidex },
+	FieldWetBulb:           func(o *CurrentObservation) *float64 { return &o.WetBulb },
+	FieldWaveHeight:        func(o *CurrentObservation) *float64 { return &o.WaveHeight },
+	FieldWavePeriod:        func(o *CurrentObservation) *float64 { return &o.WavePeriod },
+	FieldWaterTemperature:  func(o *CurrentObservation) *float64 { return &o.WaterTemperature },
+	FieldIndoorTemperature: func(o *CurrentObservation) *float64 { return &o.IndoorTemperature },
+	FieldIndoorHumidity:    func(o *CurrentObservation) *float64 { return &o.IndoorHumidity },
+	FieldVisibility:        func(o *CurrentObservation) *float64 { return &o.Visibility },
+}
+
+// textFields gives the address of each of the text observation fields
+var textFields = map[ObservationField]func(*CurrentObservation) *string{
+	FieldStationID:      func(o *CurrentObservation) *string { return &o.StationID },
+	FieldWeather:        func(o *CurrentObservation) *string { return &o.Weather },
+	FieldRawMETAR:       func(o *CurrentObservation) *string { return &o.RawMETAR },
+	FieldPresentWeather: func(o *CurrentObservation) *string { return &o.PresentWeather },
+}
+
+// Has returns true if the provider filled in the field
+func (o CurrentObservation) Has(f ObservationField) bool {
+	return o.Filled.Has(f)
+}
+
+// set fills in a numeric field
+func (o *CurrentObservation) set(f ObservationField, v float64) {
+	*numericFields[f](o) = v
+	o.Filled.Add(f)
+}
+
+// setIfPresent fills in a numeric field from an optional JSON value
+func (o *CurrentObservation) setIfPresent(f ObservationField, v *float64) {
+	if v != nil {
+		o.set(f, *v)
+	}
+}
+
+// setText fills in a text field.  Empty text doesn't count as filled in.
+func (o *CurrentObservation) setText(f ObservationField, v string) {
+	*textFields[f](o) = v
+	if v != "" {
+		o.Filled.Add(f)
+	}
+}
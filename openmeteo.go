@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const openMeteoAPIBaseURL = "https://api.open-meteo.com"
+
+// Open-Meteo reports local times in ISO 8601 without seconds or a zone offset
+const openMeteoTimeFormat = "2006-01-02T15:04"
+
+func init() {
+	registerProvider("openmeteo", newOpenMeteoProvider)
+}
+
+// OpenMeteoProvider fetches current conditions from the Open-Meteo forecast API,
+// which covers the whole planet and needs no API key
+type OpenMeteoProvider struct {
+	baseURL string
+	client  *http.Client
+	debug   bool
+}
+
+// OpenMeteoForecast encapsulates the Open-Meteo forecast API response object
+type OpenMeteoForecast struct {
+	Latitude  float64          `json:"latitude"`
+	Longitude float64          `json:"longitude"`
+	Elevation float64          `json:"elevation"`
+	Timezone  string           `json:"timezone"`
+	UTCOffset int              `json:"utc_offset_seconds"`
+	Current   OpenMeteoCurrent `json:"current"`
+	Hourly    struct {
+		Time          []string   `json:"time"`
+		Precipitation []*float64 `json:"precipitation"`
+	} `json:"hourly"`
+}
+
+// OpenMeteoError is the error body returned by the Open-Meteo APIs
+type OpenMeteoError struct {
+	Error  bool   `json:"error"`
+	Reason string `json:"reason"`
+}
+
+// OpenMeteoCurrent holds the current conditions, in the units that we requested
+type OpenMeteoCurrent struct {
+	Time                string   `json:"time"`
+	Temperature         *float64 `json:"temperature_2m"`
+	RelativeHumidity    *float64 `json:"relative_humidity_2m"`
+	Dewpoint            *float64 `json:"dew_point_2m"`
+	ApparentTemperature *float64 `json:"apparent_temperature"`
+	PressureMSL         *float64 `json:"pressure_msl"`
+	WindSpeed           *float64 `json:"wind_speed_10m"`
+	WindDirection       *float64 `json:"wind_direction_10m"`
+	WindGusts           *float64 `json:"wind_gusts_10m"`
+	Precipitation       *float64 `json:"precipitation"`
+	WeatherCode         *int     `json:"weather_code"`
+}
+
+// The variables we ask Open-Meteo for.  The names must match the JSON tags above.
+var openMeteoCurrentVariables = []string{
+	"temperature_2m",
+	"relative_humidity_2m",
+	"dew_point_2m",
+	"apparent_temperature",
+	"pressure_msl",
+	"wind_speed_10m",
+	"wind_direction_10m",
+	"wind_gusts_10m",
+	"precipitation",
+	"weather_code",
+}
+
+// wmoWeatherCodes describes the WMO present weather codes used by Open-Meteo
+var wmoWeatherCodes = map[int]string{
+	0:  "Clear",
+	1:  "Mainly Clear",
+	2:  "Partly Cloudy",
+	3:  "Overcast",
+	45: "Fog",
+	48: "Freezing Fog",
+	51: "Light Drizzle",
+	53: "Drizzle",
+	55: "Heavy Drizzle",
+	56: "Light Freezing Drizzle",
+	57: "Freezing Drizzle",
+	61: "Light Rain",
+	63: "Rain",
+	65: "Heavy Rain",
+	66: "Light Freezing Rain",
+	67: "Freezing Rain",
+	71: "Light Snow",
+	73: "Snow",
+	75: "Heavy Snow",
+	77: "Snow Grains",
+	80: "Light Rain Showers",
+	81: "Rain Showers",
+	82: "Violent Rain Showers",
+	85: "Light Snow Showers",
+	86: "Snow Showers",
+	95: "Thunderstorm",
+	96: "Thunderstorm with Hail",
+	99: "Thunderstorm with Heavy Hail",
+}
+
+func newOpenMeteoProvider(w *WeatherBar) (WeatherProvider, error) {
+	return &OpenMeteoProvider{
+		baseURL: openMeteoAPIBaseURL,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		debug:   *w.debug,
+	}, nil
+}
+
+// Name returns the name of this provider
+func (p *OpenMeteoProvider) Name() string {
+	return "openmeteo"
+}
+
+// UpdateInterval returns the polling interval for Open-Meteo
+func (p *OpenMeteoProvider) UpdateInterval() time.Duration {
+	return openMeteoUpdateInterval
+}
+
+// UsesPoint returns true because Open-Meteo looks up conditions by latitude/longitude
+func (p *OpenMeteoProvider) UsesPoint() bool {
+	return true
+}
+
+// SupportedFields returns the fields populated by Open-Meteo
+func (p *OpenMeteoProvider) SupportedFields() []ObservationField {
+	return []ObservationField{
+		FieldStationID,
+		FieldWeather,
+		FieldTemperature,
+		FieldHumidity,
+		FieldDewpoint,
+		FieldFeelsLike,
+		FieldWindDir,
+		FieldWindSpeed,
+		FieldWindGust,
+		FieldBarometer,
+		FieldRainToday,
+		FieldRain1Hour,
+	}
+}
+
+// FetchObservation fetches the current conditions at the site's point from Open-Meteo
+func (p *OpenMeteoProvider) FetchObservation(ctx context.Context, site WeatherSite) (CurrentObservation, error) {
+	if p.debug {
+		log.Printf("Fetching conditions for %.4f,%.4f from Open-Meteo...\n", site.Point.Latitude, site.Point.Longitude)
+	}
+
+	q := url.Values{}
+	q.Set("latitude", strconv.FormatFloat(site.Point.Latitude, 'f', 4, 64))
+	q.Set("longitude", strconv.FormatFloat(site.Point.Longitude, 'f', 4, 64))
+	q.Set("current", strings.Join(openMeteoCurrentVariables, ","))
+	q.Set("hourly", "precipitation")
+	q.Set("past_days", "1")
+	q.Set("forecast_days", "1")
+	q.Set("temperature_unit", "fahrenheit")
+	q.Set("wind_speed_unit", "mph")
+	q.Set("precipitation_unit", "inch")
+	q.Set("timezone", "auto")
+
+	var forecast OpenMeteoForecast
+	err := openMeteoGet(ctx, p.client, p.baseURL+"/v1/forecast?"+q.Encode(), &forecast)
+	if err != nil {
+		return CurrentObservation{}, err
+	}
+
+	return forecast.toCurrentObservation(), nil
+}
+
+// openMeteoGet fetches an Open-Meteo API URL with the given client and decodes the
+// JSON response into v
+func openMeteoGet(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	r, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		var e OpenMeteoError
+		if json.NewDecoder(r.Body).Decode(&e) == nil && e.Reason != "" {
+			return fmt.Errorf("Open-Meteo API error: %v (%v)", e.Reason, r.Status)
+		}
+		return fmt.Errorf("Open-Meteo API error: %v", r.Status)
+	}
+
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+// openMeteoLocation returns the time zone of an Open-Meteo grid point.  We load
+// it by name so that times on the far side of a DST change come out right, and
+// fall back to the offset at the time of the request if we can't.
+func openMeteoLocation(name string, offset int) *time.Location {
+	if name != "" {
+		loc, err := time.LoadLocation(name)
+		if err == nil {
+			return loc
+		}
+	}
+	return time.FixedZone(name, offset)
+}
+
+// toCurrentObservation maps the Open-Meteo response into a CurrentObservation
+func (f OpenMeteoForecast) toCurrentObservation() CurrentObservation {
+	cur := f.Current
+
+	// Open-Meteo doesn't have stations, so we identify the grid point instead
+	var obs CurrentObservation
+	obs.setText(FieldStationID, fmt.Sprintf("%.2f,%.2f", f.Latitude, f.Longitude))
+
+	// The current time is local to the grid point
+	t, err := time.ParseInLocation(openMeteoTimeFormat, cur.Time, openMeteoLocation(f.Timezone, f.UTCOffset))
+	if err == nil {
+		obs.ObservedAt = t
+	}
+
+	if cur.WeatherCode != nil {
+		obs.setText(FieldWeather, wmoWeatherCodes[*cur.WeatherCode])
+	}
+	obs.setIfPresent(FieldTemperature, cur.Temperature)
+	obs.setIfPresent(FieldHumidity, cur.RelativeHumidity)
+	obs.setIfPresent(FieldDewpoint, cur.Dewpoint)
+	obs.setIfPresent(FieldWindDir, cur.WindDirection)
+	obs.setIfPresent(FieldWindSpeed, cur.WindSpeed)
+	obs.setIfPresent(FieldWindGust, cur.WindGusts)
+	obs.setIfPresent(FieldBarometer, cur.PressureMSL)
+
+	obs.setIfPresent(FieldFeelsLike, cur.ApparentTemperature)
+
+	if lastHour, today, ok := f.recentPrecipitation(); ok {
+		obs.set(FieldRain1Hour, lastHour)
+		obs.set(FieldRainToday, today)
+	}
+
+	return obs
+}
+
+// recentPrecipitation totals the hourly precipitation for the last hour and for today.
+// Each hourly value is the precipitation during the hour that ends at its timestamp.
+// It returns false if there are no hourly values to total.
+func (f OpenMeteoForecast) recentPrecipitation() (lastHour float64, today float64, ok bool) {
+	now, err := time.Parse(openMeteoTimeFormat, f.Current.Time)
+	if err != nil {
+		return 0, 0, false
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	for i, ts := range f.Hourly.Time {
+		if i >= len(f.Hourly.Precipitation) || f.Hourly.Precipitation[i] == nil {
+			continue
+		}
+		t, err := time.Parse(openMeteoTimeFormat, ts)
+		if err != nil || t.After(now) {
+			continue
+		}
+
+		lastHour = *f.Hourly.Precipitation[i]
+		if t.After(midnight) {
+			today += *f.Hourly.Precipitation[i]
+		}
+		ok = true
+	}
+
+	return lastHour, today, ok
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chrissnell/weather-bar/internal/noaa"
+)
+
+func TestOpenMeteoFetchObservation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("latitude") == "91.0000" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": true, "reason": "Latitude must be in range of -90 to 90°. Given: 91.0."}`)
+			return
+		}
+		fmt.Fprint(w, `{"latitude": 39.18, "longitude": -96.57, "timezone": "America/Chicago", "utc_offset_seconds": -21600,
+			"current": {"time": "2024-03-14T13:45", "temperature_2m": 45.2, "apparent_temperature": 38.1, "wind_speed_10m": 12,
+				"weather_code": 2}}`)
+	}))
+	defer server.Close()
+
+	p := &OpenMeteoProvider{baseURL: server.URL, client: server.Client()}
+
+	obs, err := p.FetchObservation(context.Background(), WeatherSite{Point: noaa.Point{Latitude: 39.1836, Longitude: -96.5717}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The offset in the response is out of date, from before the switch to
+	// daylight time, so the zone has to come from its name
+	if want := time.Date(2024, 3, 14, 18, 45, 0, 0, time.UTC); !obs.ObservedAt.Equal(want) {
+		t.Errorf("observed at %v, want %v", obs.ObservedAt, want)
+	}
+	if obs.Temperature != 45.2 || obs.Weather != "Partly Cloudy" {
+		t.Errorf("temperature, weather = %v, %q, want 45.2, Partly Cloudy", obs.Temperature, obs.Weather)
+	}
+	// The apparent temperature isn't a wind chill, which is left for us to derive
+	if obs.FeelsLike != 38.1 || obs.Has(FieldWindChill) || obs.Has(FieldHeatIndex) {
+		t.Errorf("feels like = %v, wind chill filled %v, heat index filled %v, want 38.1 and neither",
+			obs.FeelsLike, obs.Has(FieldWindChill), obs.Has(FieldHeatIndex))
+	}
+
+	_, err = p.FetchObservation(context.Background(), WeatherSite{Point: noaa.Point{Latitude: 91, Longitude: 0}})
+	want := "Open-Meteo API error: Latitude must be in range of -90 to 90°. Given: 91.0. (400 Bad Request)"
+	if err == nil || err.Error() != want {
+		t.Errorf("error = %v, want %v", err, want)
+	}
+}
+
+func TestOpenMeteoLocation(t *testing.T) {
+	summer := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	// A zone that we know gets its summer offset even from a winter response
+	if _, offset := summer.In(openMeteoLocation("America/Chicago", -21600)).Zone(); offset != -18000 {
+		t.Errorf("America/Chicago offset in July = %v, want -18000", offset)
+	}
+
+	// A zone that we don't know keeps the offset from the response
+	if _, offset := summer.In(openMeteoLocation("Nowhere/Atlantis", 3600)).Zone(); offset != 3600 {
+		t.Errorf("unknown zone offset = %v, want 3600", offset)
+	}
+	if _, offset := summer.In(openMeteoLocation("", -7200)).Zone(); offset != -7200 {
+		t.Errorf("unnamed zone offset = %v, want -7200", offset)
+	}
+}
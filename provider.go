@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chrissnell/weather-bar/internal/noaa"
+)
+
+// WeatherProvider is implemented by every source of current weather conditions.
+// Providers register themselves by name and are selected with the "provider" key
+// in the [weather] section of the config file.
+type WeatherProvider interface {
+	// Name returns the name that the provider was registered under
+	Name() string
+
+	// FetchObservation fetches the current conditions for the given site
+	FetchObservation(ctx context.Context, site WeatherSite) (CurrentObservation, error)
+
+	// UpdateInterval returns how often the provider should be polled.  There is
+	// no point polling faster than the source updates its conditions.
+	UpdateInterval() time.Duration
+
+	// SupportedFields returns the observation fields that this provider populates
+	SupportedFields() []ObservationField
+}
+
+// PushProvider is implemented by providers that receive observations as they happen
+// instead of being polled.  Run blocks, sending each observation to obsChan, until
+// ctx is cancelled.  FetchObservation returns the most recently received observation.
+type PushProvider interface {
+	WeatherProvider
+	Run(ctx context.Context, obsChan chan<- CurrentObservation) error
+}
+
+// PointProvider is implemented by providers that look up conditions by latitude
+// and longitude.  These providers don't need us to find a nearby weather station.
+type PointProvider interface {
+	UsesPoint() bool
+}
+
+// needsStation returns true if the provider fetches conditions by station ID
+func needsStation(p WeatherProvider) bool {
+	pp, ok := p.(PointProvider)
+	return !ok || !pp.UsesPoint()
+}
+
+// SiteProvider is implemented by providers that may not need a station or a
+// location at all, like a command that reads a weather station attached to this
+// computer.
+type SiteProvider interface {
+	NeedsSite() bool
+}
+
+// needsSite returns true if the provider needs a station or location to fetch
+// conditions.  Push providers don't care where we are.
+func needsSite(p WeatherProvider) bool {
+	if _, ok := p.(PushProvider); ok {
+		return false
+	}
+	sp, ok := p.(SiteProvider)
+	return !ok || sp.NeedsSite()
+}
+
+// WeatherSite describes the place that a provider should fetch conditions for
+type WeatherSite struct {
+	StationID string
+	Point     noaa.Point
+}
+
+// ObservationField names a single value carried in a CurrentObservation
+type ObservationField string
+
+// These are the fields of a CurrentObservation that a provider may populate
+const (
+	FieldStationID   ObservationField = "station-id"
+	FieldWeather     ObservationField = "weather"
+	FieldTemperature ObservationField = "temperature"
+	FieldHumidity    ObservationField = "humidity"
+	FieldDewpoint    ObservationField = "dewpoint"
+	FieldWindChill   ObservationField = "wind-chill"
+	FieldHeatIndex   ObservationField = "heat-index"
+	FieldFeelsLike   ObservationField = "feels-like"
+	FieldWindDir     ObservationField = "wind-direction"
+	FieldWindSpeed   ObservationField = "wind-speed"
+	FieldWindGust    ObservationField = "wind-gust"
+	FieldBarometer   ObservationField = "barometer"
+	FieldRainToday   ObservationField = "rain-today"
+	FieldRain1Hour   ObservationField = "rain-last-hour"
+	FieldRainRate    ObservationField = "rain-rate"
+
+	FieldStationPressure ObservationField = "station-pressure"
+	FieldHumidex         ObservationField = "humidex"
+	FieldWetBulb         ObservationField = "wet-bulb"
+
+	FieldWaveHeight       ObservationField = "wave-height"
+	FieldWavePeriod       ObservationField = "wave-period"
+	FieldWaterTemperature ObservationField = "water-temperature"
+
+	FieldIndoorTemperature ObservationField = "indoor-temperature"
+	FieldIndoorHumidity    ObservationField = "indoor-humidity"
+
+	FieldRawMETAR       ObservationField = "metar-raw"
+	FieldVisibility     ObservationField = "visibility"
+	FieldCeiling        ObservationField = "ceiling"
+	FieldPresentWeather ObservationField = "present-weather"
+)
+
+// observationFields lists every observation field
+var observationFields = []ObservationField{
+	FieldStationID, FieldWeather, FieldTemperature, FieldHumidity, FieldDewpoint,
+	FieldWindChill, FieldHeatIndex, FieldFeelsLike, FieldWindDir, FieldWindSpeed,
+	FieldWindGust, FieldBarometer, FieldRainToday, FieldRain1Hour, FieldRainRate,
+	FieldStationPressure, FieldHumidex, FieldWetBulb,
+	FieldWaveHeight, FieldWavePeriod, FieldWaterTemperature,
+	FieldIndoorTemperature, FieldIndoorHumidity,
+	FieldRawMETAR, FieldVisibility, FieldCeiling, FieldPresentWeather,
+}
+
+// tokenFields maps each weather-format token to the observation field it displays
+var tokenFields = map[string]ObservationField{
+	"%temperature-fahrenheit%":        FieldTemperature,
+	"%temperature-celcius%":           FieldTemperature,
+	"%barometer%":                     FieldBarometer,
+	"%wind-speed-mph%":                FieldWindSpeed,
+	"%wind-speed-kph%":                FieldWindSpeed,
+	"%wind-direction%":                FieldWindDir,
+	"%wind-cardinal%":                 FieldWindDir,
+	"%wind-gust-mph%":                 FieldWindGust,
+	"%wind-gust-kph%":                 FieldWindGust,
+	"%weather%":                       FieldWeather,
+	"%humidity%":                      FieldHumidity,
+	"%wind-chill-fahrenheit%":         FieldWindChill,
+	"%wind-chill-celcius%":            FieldWindChill,
+	"%heat-index-fahrenheit%":         FieldHeatIndex,
+	"%heat-index-celcius%":            FieldHeatIndex,
+	"%feels-like-fahrenheit%":         FieldFeelsLike,
+	"%feels-like-celcius%":            FieldFeelsLike,
+	"%dewpoint-fahrenheit%":           FieldDewpoint,
+	"%dewpoint-celcius%":              FieldDewpoint,
+	"%humidex%":                       FieldHumidex,
+	"%wet-bulb-fahrenheit%":           FieldWetBulb,
+	"%wet-bulb-celcius%":              FieldWetBulb,
+	"%station-id%":                    FieldStationID,
+	"%rain-today-inches%":             FieldRainToday,
+	"%rain-last-hour-inches%":         FieldRain1Hour,
+	"%rain-rate-inches%":              FieldRainRate,
+	"%indoor-temperature-fahrenheit%": FieldIndoorTemperature,
+	"%indoor-temperature-celcius%":    FieldIndoorTemperature,
+	"%indoor-humidity%":               FieldIndoorHumidity,
+	"%metar-raw%":                     FieldRawMETAR,
+	"%visibility%":                    FieldVisibility,
+	"%ceiling%":                       FieldCeiling,
+	"%present-weather%":               FieldPresentWeather,
+	"%wave-height-feet%":              FieldWaveHeight,
+	"%wave-height-meters%":            FieldWaveHeight,
+	"%wave-period%":                   FieldWavePeriod,
+	"%water-temperature-fahrenheit%":  FieldWaterTemperature,
+	"%water-temperature-celcius%":     FieldWaterTemperature,
+	"%pressure-trend%":                FieldBarometer,
+	"%pressure-change%":               FieldBarometer,
+	"%temperature-change-fahrenheit%": FieldTemperature,
+	"%temperature-change-celcius%":    FieldTemperature,
+	"%peak-gust-mph%":                 FieldWindGust,
+	"%peak-gust-kph%":                 FieldWindGust,
+}
+
+// providerFactory builds a provider from our configuration
+type providerFactory func(w *WeatherBar) (WeatherProvider, error)
+
+var providerRegistry = make(map[string]providerFactory)
+
+// registerProvider makes a provider available under the given name.  Providers
+// call this from an init() function in their own source file.
+func registerProvider(name string, factory providerFactory) {
+	if _, exists := providerRegistry[name]; exists {
+		panic("weather provider registered twice: " + name)
+	}
+	providerRegistry[name] = factory
+}
+
+// providerNames returns the names of all registered providers in sorted order
+func providerNames() []string {
+	names := make([]string, 0, len(providerRegistry))
+	for name := range providerRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// configuredProviderNames returns the providers named in the config file, in the
+// order that they should be tried.  Older config files don't have a provider key,
+// so for those we fall back to the old behavior: use Weather Underground if an API
+// key was given, NOAA otherwise.
+func configuredProviderNames(cfg *Config) []string {
+	if cfg.Weather.Provider == "" {
+		if cfg.Weather.WUAPIKey != "" {
+			return []string{"wu"}
+		}
+		return []string{"noaa"}
+	}
+
+	var names []string
+	for _, name := range strings.Split(cfg.Weather.Provider, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// newConfiguredProvider builds the provider named in the config file, or a chain
+// of them if more than one was named
+func newConfiguredProvider(w *WeatherBar) (WeatherProvider, error) {
+	names := configuredProviderNames(w.cfg)
+	switch len(names) {
+	case 0:
+		return nil, fmt.Errorf("no weather provider given (available: %v)", strings.Join(providerNames(), ", "))
+	case 1:
+		return newWeatherProvider(w, names[0])
+	}
+	return newProviderChain(w, names)
+}
+
+// newWeatherProvider builds the provider registered under the given name
+func newWeatherProvider(w *WeatherBar, name string) (WeatherProvider, error) {
+	factory, ok := providerRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown weather provider %q (available: %v)", name, strings.Join(providerNames(), ", "))
+	}
+	return factory(w)
+}
+
+// warnUnsupportedTokens logs any tokens in the format string that the provider
+// will never populate, so that users aren't left wondering why a value is always zero.
+// Fields that we can derive from the provider's fields count as supported.
+func warnUnsupportedTokens(format string, p WeatherProvider) {
+	supported := make(map[ObservationField]bool)
+	for _, f := range p.SupportedFields() {
+		supported[f] = true
+	}
+	addDerivableFields(supported)
+
+	tokens := make([]string, 0, len(tokenFields))
+	for token := range tokenFields {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+
+	for _, token := range tokens {
+		if strings.Contains(format, token) && !supported[tokenFields[token]] {
+			log.Printf("Warning: token %v is not supported by the %v provider\n", token, p.Name())
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// By default, we listen for station uploads on all interfaces on this port
+const defaultPushListenAddress = ":8080"
+
+func init() {
+	registerProvider("push", newPushStationProvider)
+}
+
+// PushStationProvider runs a small HTTP server that accepts observations uploaded
+// by a local weather station gateway.  It understands the Ecowitt and Ambient
+// Weather "custom server" formats as well as the Weather Underground upload
+// protocol that most gateways can also speak.
+type PushStationProvider struct {
+	listenAddress string
+	passkey       string
+	stationID     string
+	debug         bool
+
+	latestMutex sync.RWMutex
+	latest      CurrentObservation
+	latestTime  time.Time
+}
+
+func newPushStationProvider(w *WeatherBar) (WeatherProvider, error) {
+	p := &PushStationProvider{
+		listenAddress: w.cfg.Weather.PushListenAddress,
+		passkey:       w.cfg.Weather.PushPasskey,
+		stationID:     w.cfg.Weather.Station,
+		debug:         *w.debug,
+	}
+	if p.listenAddress == "" {
+		p.listenAddress = defaultPushListenAddress
+	}
+	return p, nil
+}
+
+// Name returns the name of this provider
+func (p *PushStationProvider) Name() string {
+	return "push"
+}
+
+// UpdateInterval returns how often a typical gateway uploads.  We don't poll push
+// providers, but this is how old an observation can get before it's suspect.
+func (p *PushStationProvider) UpdateInterval() time.Duration {
+	return pushUpdateInterval
+}
+
+// SupportedFields returns the fields that station gateways upload
+func (p *PushStationProvider) SupportedFields() []ObservationField {
+	return []ObservationField{
+		FieldStationID,
+		FieldTemperature,
+		FieldHumidity,
+		FieldDewpoint,
+		FieldFeelsLike,
+		FieldWindDir,
+		FieldWindSpeed,
+		FieldWindGust,
+		FieldBarometer,
+		FieldStationPressure,
+		FieldRainToday,
+		FieldRain1Hour,
+		FieldRainRate,
+		FieldIndoorTemperature,
+		FieldIndoorHumidity,
+	}
+}
+
+// FetchObservation returns the most recent upload from the station
+func (p *PushStationProvider) FetchObservation(ctx context.Context, site WeatherSite) (CurrentObservation, error) {
+	p.latestMutex.RLock()
+	defer p.latestMutex.RUnlock()
+
+	if p.latestTime.IsZero() {
+		return CurrentObservation{}, fmt.Errorf("no observations have been uploaded to %v yet", p.listenAddress)
+	}
+	return p.latest, nil
+}
+
+// Run listens for station uploads and sends each one to obsChan until ctx is cancelled
+func (p *PushStationProvider) Run(ctx context.Context, obsChan chan<- CurrentObservation) error {
+	listener, err := net.Listen("tcp", p.listenAddress)
+	if err != nil {
+		return err
+	}
+	return p.serve(ctx, listener, obsChan)
+}
+
+func (p *PushStationProvider) serve(ctx context.Context, listener net.Listener, obsChan chan<- CurrentObservation) error {
+	srv := &http.Server{
+		Handler: p.handler(ctx, obsChan),
+		// Gateways send tiny requests, so there's no reason to hold connections open
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	if p.debug {
+		log.Println("Listening for weather station uploads on", listener.Addr())
+	}
+
+	err := srv.Serve(listener)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// handler accepts uploads on any path.  Ecowitt gateways POST a form, while Ambient
+// and WU-protocol gateways send a GET with the observation in the query string.
+func (p *PushStationProvider) handler(ctx context.Context, obsChan chan<- CurrentObservation) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if p.passkey != "" && !p.authorized(r.Form) {
+			log.Println("Rejected weather station upload from", r.RemoteAddr, "with the wrong passkey")
+			http.Error(rw, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		obs, err := p.parseUpload(r.Form)
+		if err != nil {
+			log.Println("error parsing weather station upload:", err)
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		p.latestMutex.Lock()
+		p.latest = obs
+		p.latestTime = time.Now()
+		p.latestMutex.Unlock()
+
+		if p.debug {
+			log.Printf("Received upload from %v: %+v\n", r.RemoteAddr, obs)
+		}
+
+		select {
+		case obsChan <- obs:
+		case <-ctx.Done():
+		}
+
+		// Ecowitt and Ambient gateways only care about the status code, but
+		// WU-protocol gateways look for this body
+		rw.Write([]byte("success\n"))
+	})
+}
+
+// authorized checks the upload's station key.  Ecowitt and Ambient send a PASSKEY,
+// Ambient also sends a MAC and WU-protocol gateways send an ID and PASSWORD.
+func (p *PushStationProvider) authorized(form url.Values) bool {
+	for _, key := range []string{"PASSKEY", "MAC", "PASSWORD", "ID"} {
+		if form.Get(key) == p.passkey {
+			return true
+		}
+	}
+	return false
+}
+
+// parseUpload converts the form fields of an upload into a CurrentObservation.
+// Every supported format uses imperial units.
+func (p *PushStationProvider) parseUpload(form url.Values) (CurrentObservation, error) {
+	stationID := p.stationID
+	if stationID == "" {
+		stationID = firstFormValue(form, "stationtype", "model", "ID")
+	}
+	if stationID == "" {
+		stationID = "local"
+	}
+	var obs CurrentObservation
+	obs.setText(FieldStationID, stationID)
+
+	// Gateways send the time of the observation in UTC, or "now" to mean the time
+	// of the upload
+	obs.ObservedAt = time.Now()
+	if t, err := time.Parse("2006-01-02 15:04:05", form.Get("dateutc")); err == nil {
+		obs.ObservedAt = t
+	}
+
+	fields := []struct {
+		field ObservationField
+		keys  []string
+	}{
+		{FieldTemperature, []string{"tempf"}},
+		{FieldHumidity, []string{"humidity"}},
+		{FieldDewpoint, []string{"dewptf"}},
+		{FieldFeelsLike, []string{"feelsLike", "feelslikef"}},
+		{FieldWindDir, []string{"winddir"}},
+		{FieldWindSpeed, []string{"windspeedmph"}},
+		{FieldWindGust, []string{"windgustmph"}},
+		{FieldRainToday, []string{"dailyrainin"}},
+		{FieldRain1Hour, []string{"hourlyrainin", "rainin"}},
+		{FieldRainRate, []string{"rainratein"}},
+		{FieldIndoorTemperature, []string{"tempinf", "indoortempf"}},
+		{FieldIndoorHumidity, []string{"humidityin", "indoorhumidity"}},
+	}
+
+	found := 0
+	for _, f := range fields {
+		v, ok, err := parseFormFloat(form, f.keys...)
+		if err != nil {
+			return CurrentObservation{}, err
+		}
+		if ok {
+			obs.set(f.field, v)
+			found++
+		}
+	}
+
+	// Relative (sea-level) pressure is what people expect from a barometer.  If we
+	// only get the station's absolute pressure, the barometer is derived from it.
+	baro, ok, err := parseFormFloat(form, "baromrelin", "baromin")
+	if err != nil {
+		return CurrentObservation{}, err
+	}
+	if ok {
+		obs.set(FieldBarometer, roundTenth(baro*millibarsPerInchHg))
+		found++
+	}
+	abs, ok, err := parseFormFloat(form, "baromabsin")
+	if err != nil {
+		return CurrentObservation{}, err
+	}
+	if ok {
+		obs.set(FieldStationPressure, roundTenth(abs*millibarsPerInchHg))
+		found++
+	}
+
+	if found == 0 {
+		return CurrentObservation{}, fmt.Errorf("upload contained no weather measurements")
+	}
+
+	return obs, nil
+}
+
+// parseFormFloat parses the first of the given form fields that's present
+func parseFormFloat(form url.Values, keys ...string) (float64, bool, error) {
+	s := firstFormValue(form, keys...)
+	if s == "" {
+		return 0, false, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid value for %v: %q", keys[0], s)
+	}
+
+	// WU-protocol gateways send -9999 for sensors that they don't have
+	if v == -9999 {
+		return 0, false, nil
+	}
+	return v, true, nil
+}
+
+// firstFormValue returns the value of the first of the given form fields that's present
+func firstFormValue(form url.Values, keys ...string) string {
+	for _, key := range keys {
+		if v := form.Get(key); v != "" {
+			return v
+		}
+	}
+	return ""
+}
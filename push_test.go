@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPushStationUploads(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	obsChan := make(chan CurrentObservation, 1)
+	p := &PushStationProvider{listenAddress: "localhost:0", passkey: "secret"}
+	server := httptest.NewServer(p.handler(ctx, obsChan))
+	defer server.Close()
+
+	if _, err := p.FetchObservation(ctx, WeatherSite{}); err == nil {
+		t.Error("fetching before any uploads didn't return an error")
+	}
+
+	tests := []struct {
+		name        string
+		post        bool
+		form        string
+		wantStation string
+		wantTime    time.Time
+		want        map[ObservationField]float64
+	}{
+		{
+			name: "Ecowitt",
+			post: true,
+			form: "PASSKEY=secret&stationtype=GW1100A_V2.3.1&dateutc=2024-03-14+18:53:00&tempinf=71.6&humidityin=38" +
+				"&baromrelin=29.920&baromabsin=28.850&tempf=68.2&humidity=50&winddir=220&windspeedmph=4.5&windgustmph=9.2" +
+				"&rainratein=0.000&hourlyrainin=0.000&dailyrainin=0.120&model=GW1100A",
+			wantStation: "GW1100A_V2.3.1",
+			wantTime:    time.Date(2024, 3, 14, 18, 53, 0, 0, time.UTC),
+			want: map[ObservationField]float64{
+				FieldTemperature:       68.2,
+				FieldHumidity:          50,
+				FieldWindDir:           220,
+				FieldWindSpeed:         4.5,
+				FieldWindGust:          9.2,
+				FieldBarometer:         1013.2,
+				FieldStationPressure:   977,
+				FieldRainRate:          0,
+				FieldRain1Hour:         0,
+				FieldRainToday:         0.12,
+				FieldIndoorTemperature: 71.6,
+				FieldIndoorHumidity:    38,
+			},
+		},
+		{
+			// Ambient sends "now" rather than the time of the observation
+			name:        "Ambient",
+			form:        "MAC=secret&stationtype=AMBWeatherPro_V5.0.6&dateutc=now&tempf=41.0&feelsLike=36.5&dewptf=30.2&humidity=65&baromrelin=30.01",
+			wantStation: "AMBWeatherPro_V5.0.6",
+			want: map[ObservationField]float64{
+				FieldTemperature: 41,
+				FieldFeelsLike:   36.5,
+				FieldDewpoint:    30.2,
+				FieldHumidity:    65,
+				FieldBarometer:   1016.3,
+			},
+		},
+		{
+			// The gateway has no gust or indoor sensors
+			name:        "WU protocol",
+			form:        "ID=KKSMANHA42&PASSWORD=secret&action=updateraw&dateutc=2024-03-14+18:53:00&tempf=68.2&windgustmph=-9999&indoortempf=-9999&baromin=29.92&rainin=0.04",
+			wantStation: "KKSMANHA42",
+			wantTime:    time.Date(2024, 3, 14, 18, 53, 0, 0, time.UTC),
+			want: map[ObservationField]float64{
+				FieldTemperature: 68.2,
+				FieldBarometer:   1013.2,
+				FieldRain1Hour:   0.04,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		var r *http.Response
+		var err error
+		if tt.post {
+			r, err = http.Post(server.URL+"/data/report/", "application/x-www-form-urlencoded", strings.NewReader(tt.form))
+		} else {
+			r, err = http.Get(server.URL + "/weatherstation/updateweatherstation.php?" + tt.form)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(r.Body)
+		r.Body.Close()
+		if r.StatusCode != http.StatusOK || string(body) != "success\n" {
+			t.Errorf("%v: response = %v %q, want 200 success", tt.name, r.StatusCode, body)
+			continue
+		}
+
+		obs := <-obsChan
+		if obs.StationID != tt.wantStation {
+			t.Errorf("%v: station = %q, want %q", tt.name, obs.StationID, tt.wantStation)
+		}
+		if tt.wantTime.IsZero() {
+			if time.Since(obs.ObservedAt) > time.Minute {
+				t.Errorf("%v: observed at %v, want now", tt.name, obs.ObservedAt)
+			}
+		} else if !obs.ObservedAt.Equal(tt.wantTime) {
+			t.Errorf("%v: observed at %v, want %v", tt.name, obs.ObservedAt, tt.wantTime)
+		}
+
+		var wantFields []ObservationField
+		for f, v := range tt.want {
+			wantFields = append(wantFields, f)
+			if got := *numericFields[f](&obs); got != v {
+				t.Errorf("%v: %v = %v, want %v", tt.name, f, got, v)
+			}
+		}
+		wantFields = append(wantFields, FieldStationID)
+		if got, want := filledFields(obs), sortedFields(wantFields); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: filled fields = %v, want %v", tt.name, got, want)
+		}
+
+		latest, err := p.FetchObservation(ctx, WeatherSite{})
+		if err != nil || !reflect.DeepEqual(latest, obs) {
+			t.Errorf("%v: latest = %+v, %v, want the upload", tt.name, latest, err)
+		}
+	}
+}
+
+func TestPushStationRejectedUploads(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	obsChan := make(chan CurrentObservation, 1)
+	p := &PushStationProvider{passkey: "secret"}
+	server := httptest.NewServer(p.handler(ctx, obsChan))
+	defer server.Close()
+
+	tests := []struct {
+		name string
+		form url.Values
+		want int
+	}{
+		{"wrong passkey", url.Values{"PASSKEY": {"guess"}, "tempf": {"68.2"}}, http.StatusUnauthorized},
+		{"no passkey", url.Values{"tempf": {"68.2"}}, http.StatusUnauthorized},
+		{"invalid value", url.Values{"PASSKEY": {"secret"}, "tempf": {"warm"}}, http.StatusBadRequest},
+		{"no measurements", url.Values{"PASSKEY": {"secret"}, "stationtype": {"GW1100A_V2.3.1"}}, http.StatusBadRequest},
+		{"only missing sensors", url.Values{"PASSKEY": {"secret"}, "tempf": {"-9999"}}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		r, err := http.PostForm(server.URL, tt.form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Body.Close()
+		if r.StatusCode != tt.want {
+			t.Errorf("%v: status = %v, want %v", tt.name, r.StatusCode, tt.want)
+		}
+	}
+
+	select {
+	case obs := <-obsChan:
+		t.Errorf("a rejected upload was sent: %+v", obs)
+	default:
+	}
+	if _, err := p.FetchObservation(ctx, WeatherSite{}); err == nil {
+		t.Error("a rejected upload became the latest observation")
+	}
+}
+
+func TestPushStationConfiguredID(t *testing.T) {
+	p := &PushStationProvider{stationID: "backyard"}
+	obs, err := p.parseUpload(url.Values{"stationtype": {"GW1100A_V2.3.1"}, "tempf": {"68.2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obs.StationID != "backyard" {
+		t.Errorf("station = %q, want backyard", obs.StationID)
+	}
+
+	p.stationID = ""
+	obs, err = p.parseUpload(url.Values{"tempf": {"68.2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obs.StationID != "local" {
+		t.Errorf("station = %q, want local", obs.StationID)
+	}
+}
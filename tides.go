@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chrissnell/weather-bar/internal/noaa"
+)
+
+const (
+	coopsMetadataBaseURL = "https://api.tidesandcurrents.noaa.gov/mdapi/prod/webapi"
+	coopsDataBaseURL     = "https://api.tidesandcurrents.noaa.gov/api/prod/datagetter"
+)
+
+// CO-OPS reports times like this when asked for GMT
+const coopsTimeFormat = "2006-01-02 15:04"
+
+// We don't show the tides from a station further away than this many
+// kilometers, since we're probably not on the coast
+const tideStationMaxDistance = 100
+
+// The water level has to change by this many feet over the last hour for the tide
+// to count as rising or falling rather than slack
+const tideSlackThreshold = 0.1
+
+// tideTokens are the weather-format tokens filled in from the tides.  We only
+// fetch tides if the format uses one of them.
+var tideTokens = []string{
+	"%next-tide%",
+	"%tide-trend%",
+	"%water-temp%",
+}
+
+// TidePrediction is a predicted high or low tide
+type TidePrediction struct {
+	Time   time.Time
+	High   bool
+	Height float64
+}
+
+// Tides holds the tides and water conditions at the stations nearest to us.
+// Trend is 1 if the water is rising, -1 if it's falling and 0 if it's slack.
+type Tides struct {
+	Predictions  []TidePrediction
+	Trend        int
+	HasTrend     bool
+	WaterTemp    float64
+	HasWaterTemp bool
+
+	// The station and date that the predictions are for, so we only fetch them
+	// once a day
+	predictionStation string
+	predictionDate    string
+}
+
+// COOPSStation is a station from the CO-OPS metadata API
+type COOPSStation struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lng"`
+}
+
+// COOPSStationList encapsulates the CO-OPS metadata API response for a list of
+// stations
+type COOPSStationList struct {
+	Stations []COOPSStation `json:"stations"`
+}
+
+// COOPSValue is a value from the CO-OPS data API.  Values are strings, which are
+// empty when the value is missing.
+type COOPSValue struct {
+	Time  string `json:"t"`
+	Value string `json:"v"`
+	Type  string `json:"type"`
+}
+
+// COOPSData encapsulates the CO-OPS data API response
+type COOPSData struct {
+	Data        []COOPSValue `json:"data"`
+	Predictions []COOPSValue `json:"predictions"`
+	Error       *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// tideStations finds and remembers the CO-OPS stations nearest to us.  The
+// station lists are long, so we only fetch each one once.
+type tideStations struct {
+	metadataURL string
+	dataURL     string
+	client      *http.Client
+	station     string
+	lists       map[string][]COOPSStation
+}
+
+// usesTides returns true if the format has any tide tokens in it
+func usesTides(format string) bool {
+	for _, token := range tideTokens {
+		if strings.Contains(format, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// tidesWatcher fetches the tides at the stations nearest to us on its own
+// schedule, and again whenever we move
+func (w *WeatherBar) tidesWatcher(ctx context.Context, moved <-chan struct{}) {
+	ticker := time.NewTicker(tidesUpdateInterval)
+	defer ticker.Stop()
+
+	stations := &tideStations{
+		metadataURL: coopsMetadataBaseURL,
+		dataURL:     coopsDataBaseURL,
+		client:      &http.Client{Timeout: 30 * time.Second},
+		station:     strings.TrimSpace(w.cfg.Tides.Station),
+		lists:       make(map[string][]COOPSStation),
+	}
+
+	point, ok := w.waitForPoint(ctx)
+	if !ok {
+		return
+	}
+
+	for {
+		w.updateTides(ctx, stations, point)
+
+		select {
+		case <-ticker.C:
+		case <-moved:
+		case <-ctx.Done():
+			log.Println("Termination request recieved.  Cancelling tides watcher.")
+			return
+		}
+		point = w.currentSite().Point
+	}
+}
+
+// updateTides fetches the water conditions at the stations nearest to the point,
+// and the tide predictions if we don't have today's yet, and redraws the bar with them
+func (w *WeatherBar) updateTides(ctx context.Context, stations *tideStations, point noaa.Point) {
+	loc := w.timezone()
+	today := time.Now().In(loc).Format("2006-01-02")
+	tides := w.currentTides()
+
+	station, err := stations.nearest(ctx, "tidepredictions", point)
+	switch {
+	case err != nil:
+		log.Println("unable to find a tide station:", err)
+	case station == "":
+		tides.Predictions = nil
+	case station != tides.predictionStation || today != tides.predictionDate:
+		if *w.debug {
+			log.Println("Fetching tide predictions for station", station)
+		}
+		predictions, err := stations.fetchPredictions(ctx, station, loc)
+		if err != nil {
+			log.Printf("unable to fetch tide predictions for station %v: %v\n", station, err)
+			break
+		}
+		tides.Predictions = predictions
+		tides.predictionStation = station
+		tides.predictionDate = today
+	}
+
+	tides.Trend, tides.HasTrend = 0, false
+	station, err = stations.nearest(ctx, "waterlevels", point)
+	if err != nil {
+		log.Println("unable to find a water level station:", err)
+	} else if station != "" {
+		tides.Trend, tides.HasTrend, err = stations.fetchTrend(ctx, station)
+		if err != nil {
+			log.Printf("unable to fetch water levels for station %v: %v\n", station, err)
+		}
+	}
+
+	tides.WaterTemp, tides.HasWaterTemp = 0, false
+	station, err = stations.nearest(ctx, "watertemp", point)
+	if err != nil {
+		log.Println("unable to find a water temperature station:", err)
+	} else if station != "" {
+		tides.WaterTemp, tides.HasWaterTemp, err = stations.fetchWaterTemp(ctx, station)
+		if err != nil {
+			log.Printf("unable to fetch water temperature for station %v: %v\n", station, err)
+		}
+	}
+
+	if *w.debug {
+		log.Printf("Current tides: %+v\n", tides)
+	}
+
+	w.tidesMutex.Lock()
+	w.tides = tides
+	w.tidesMutex.Unlock()
+
+	w.redraw()
+}
+
+// currentTides returns the latest tides
+func (w *WeatherBar) currentTides() Tides {
+	w.tidesMutex.RLock()
+	defer w.tidesMutex.RUnlock()
+	return w.tides
+}
+
+// nearest returns the ID of the station of the given type nearest to the point,
+// or the configured station if there is one.  It returns "" if there are no
+// stations near enough.
+func (s *tideStations) nearest(ctx context.Context, stationType string, point noaa.Point) (string, error) {
+	if s.station != "" {
+		return s.station, nil
+	}
+
+	list, ok := s.lists[stationType]
+	if !ok {
+		var stations COOPSStationList
+		err := coopsGet(ctx, s.client, s.metadataURL+"/stations.json?type="+url.QueryEscape(stationType), &stations)
+		if err != nil {
+			return "", err
+		}
+		list = stations.Stations
+		s.lists[stationType] = list
+	}
+
+	var nearest string
+	nearestDistance := 0.0
+	for _, st := range list {
+		p := &noaa.Point{Latitude: st.Latitude, Longitude: st.Longitude}
+		distance := point.HaversineDistance(p)
+		if distance < nearestDistance || nearest == "" {
+			nearest = st.ID
+			nearestDistance = distance
+		}
+	}
+
+	if nearestDistance > tideStationMaxDistance {
+		return "", nil
+	}
+	return nearest, nil
+}
+
+// fetchPredictions fetches the high and low tides predicted from the start of
+// today until the end of tomorrow, in loc's time zone
+func (s *tideStations) fetchPredictions(ctx context.Context, station string, loc *time.Location) ([]TidePrediction, error) {
+	y, m, d := time.Now().In(loc).Date()
+	start := time.Date(y, m, d, 0, 0, 0, 0, loc).UTC()
+
+	q := url.Values{}
+	q.Set("product", "predictions")
+	q.Set("interval", "hilo")
+	q.Set("datum", "MLLW")
+	q.Set("begin_date", start.Format("20060102 15:04"))
+	q.Set("range", "48")
+
+	data, err := s.fetchData(ctx, station, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var predictions []TidePrediction
+	for _, v := range data.Predictions {
+		t, err := time.Parse(coopsTimeFormat, v.Time)
+		if err != nil {
+			continue
+		}
+		height, _ := strconv.ParseFloat(v.Value, 64)
+		predictions = append(predictions, TidePrediction{
+			Time:   t.In(loc),
+			High:   v.Type == "H",
+			Height: height,
+		})
+	}
+	return predictions, nil
+}
+
+// fetchTrend works out whether the water is rising or falling from the water
+// levels measured over the last hour.  It returns false if there aren't enough
+// measurements to tell.
+func (s *tideStations) fetchTrend(ctx context.Context, station string) (int, bool, error) {
+	q := url.Values{}
+	q.Set("product", "water_level")
+	q.Set("datum", "MLLW")
+	q.Set("range", "1")
+
+	data, err := s.fetchData(ctx, station, q)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var levels []float64
+	for _, v := range data.Data {
+		level, err := strconv.ParseFloat(v.Value, 64)
+		if err == nil {
+			levels = append(levels, level)
+		}
+	}
+	if len(levels) < 2 {
+		return 0, false, nil
+	}
+
+	change := levels[len(levels)-1] - levels[0]
+	switch {
+	case change >= tideSlackThreshold:
+		return 1, true, nil
+	case change <= -tideSlackThreshold:
+		return -1, true, nil
+	}
+	return 0, true, nil
+}
+
+// fetchWaterTemp fetches the latest water temperature in degrees Fahrenheit
+func (s *tideStations) fetchWaterTemp(ctx context.Context, station string) (float64, bool, error) {
+	q := url.Values{}
+	q.Set("product", "water_temperature")
+	q.Set("date", "latest")
+
+	data, err := s.fetchData(ctx, station, q)
+	if err != nil {
+		return 0, false, err
+	}
+	if len(data.Data) == 0 {
+		return 0, false, nil
+	}
+	temp, err := strconv.ParseFloat(data.Data[len(data.Data)-1].Value, 64)
+	if err != nil {
+		return 0, false, nil
+	}
+	return temp, true, nil
+}
+
+// fetchData fetches a product for a station from the CO-OPS data API, in English
+// units and GMT
+func (s *tideStations) fetchData(ctx context.Context, station string, q url.Values) (COOPSData, error) {
+	q.Set("station", station)
+	q.Set("units", "english")
+	q.Set("time_zone", "gmt")
+	q.Set("format", "json")
+	q.Set("application", "weather-bar")
+
+	var data COOPSData
+	err := coopsGet(ctx, s.client, s.dataURL+"?"+q.Encode(), &data)
+	if err != nil {
+		return COOPSData{}, err
+	}
+	if data.Error != nil {
+		return COOPSData{}, fmt.Errorf("CO-OPS API error: %v", data.Error.Message)
+	}
+	return data, nil
+}
+
+// coopsGet fetches a CO-OPS API URL and decodes the JSON response into v
+func coopsGet(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	r, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("CO-OPS API error: %v", r.Status)
+	}
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+// next returns the next high or low tide after now
+func (t Tides) next(now time.Time) (TidePrediction, bool) {
+	for _, p := range t.Predictions {
+		if p.Time.After(now) {
+			return p, true
+		}
+	}
+	return TidePrediction{}, false
+}
+
+// NextTide describes the next high or low tide, e.g. "High 14:32"
+func (t Tides) NextTide(now time.Time, layout string) string {
+	p, ok := t.next(now)
+	if !ok {
+		return ""
+	}
+	if p.High {
+		return "High " + p.Time.Format(layout)
+	}
+	return "Low " + p.Time.Format(layout)
+}
+
+// TrendText describes whether the tide is coming in or going out.  Without water
+// levels from a nearby station, we go by whether the next tide is high or low.
+func (t Tides) TrendText(now time.Time) string {
+	trend, ok := t.Trend, t.HasTrend
+	if !ok {
+		p, found := t.next(now)
+		if !found {
+			return ""
+		}
+		trend = -1
+		if p.High {
+			trend = 1
+		}
+	}
+
+	switch trend {
+	case 1:
+		return "Rising ↑"
+	case -1:
+		return "Falling ↓"
+	}
+	return "Slack"
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTides(t *testing.T) {
+	pdt := time.FixedZone("", -7*60*60)
+	at := func(hour, min int) time.Time { return time.Date(2024, 3, 14, hour, min, 0, 0, pdt) }
+	predictions := []TidePrediction{
+		{Time: at(3, 12), High: false, Height: -0.4},
+		{Time: at(9, 41), High: true, Height: 6.1},
+		{Time: at(15, 58), High: false, Height: 1.2},
+		{Time: at(21, 47), High: true, Height: 5.3},
+	}
+
+	tests := []struct {
+		name      string
+		tides     Tides
+		now       time.Time
+		wantNext  string
+		wantTrend string
+	}{
+		{"before the first tide", Tides{Predictions: predictions}, at(1, 0), "Low 03:12", "Falling ↓"},
+		{"coming in", Tides{Predictions: predictions}, at(5, 0), "High 09:41", "Rising ↑"},
+		// A tide that's happening now has passed
+		{"at a tide", Tides{Predictions: predictions}, at(9, 41), "Low 15:58", "Falling ↓"},
+		// The water levels win over the predictions
+		{"measured slack", Tides{Predictions: predictions, HasTrend: true}, at(5, 0), "High 09:41", "Slack"},
+		{"measured falling", Tides{Predictions: predictions, Trend: -1, HasTrend: true}, at(5, 0), "High 09:41", "Falling ↓"},
+		{"no upcoming tide", Tides{Predictions: predictions}, at(23, 0), "", ""},
+		{"no upcoming tide, measured rising", Tides{Predictions: predictions, Trend: 1, HasTrend: true}, at(23, 0), "", "Rising ↑"},
+		{"no predictions", Tides{}, at(5, 0), "", ""},
+	}
+
+	for _, tt := range tests {
+		if got := tt.tides.NextTide(tt.now, "15:04"); got != tt.wantNext {
+			t.Errorf("%v: next tide = %q, want %q", tt.name, got, tt.wantNext)
+		}
+		if got := tt.tides.TrendText(tt.now); got != tt.wantTrend {
+			t.Errorf("%v: trend = %q, want %q", tt.name, got, tt.wantTrend)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import "math"
+
+// Conversion factors between the units used by our various weather sources
+const (
+	metersPerMile          = 1609.344
+	kphPerMph              = 1.609344
+	kphPerKnot             = 1.852
+	mphPerKnot             = 1.150779
+	knotsPerMeterPerSecond = 1.943844
+	millibarsPerInchHg     = 33.863886
+	millimetersPerInch     = 25.4
+	metersPerFoot          = 0.3048
+	feetPerMeter           = 3.28084
+	milesPerNauticalMile   = 1.150779
+	metersPerSecondPerMph  = 0.44704
+)
+
+// celsiusToFahrenheit converts a temperature from degrees Celsius to Fahrenheit
+func celsiusToFahrenheit(c float64) float64 {
+	return c*9/5 + 32
+}
+
+// fahrenheitToCelsius converts a temperature from degrees Fahrenheit to Celsius
+func fahrenheitToCelsius(f float64) float64 {
+	return (f - 32) * 5 / 9
+}
+
+// roundTenth rounds unit conversions to a sensible precision for display
+func roundTenth(v float64) float64 {
+	return math.Round(v*10) / 10
+}
@@ -9,11 +9,10 @@ import (
 	"os/user"
 	"path/filepath"
 	"regexp"
-	"strconv"
 	"sync"
 	"time"
 
-	"github.com/jasonwinn/noaa"
+	"github.com/chrissnell/weather-bar/internal/noaa"
 )
 
 const freeGeoIPURL = "https://freegeoip.net/json/"
@@ -30,9 +29,77 @@ const noaaUpdateInterval = 1 * time.Hour
 // Use a 5-minute interval for Weather Underground updates.
 const wuUpdateInterval = 5 * time.Minute
 
+// Open-Meteo refreshes its current conditions every 15 minutes.
+const openMeteoUpdateInterval = 15 * time.Minute
+
+// METARs are issued hourly, with special reports whenever conditions change
+// significantly, so we check every 10 minutes to catch the specials.
+const metarUpdateInterval = 10 * time.Minute
+
+// Station gateways typically upload every minute or so.  We don't poll them, but
+// this is how old their data can get before something is probably wrong.
+const pushUpdateInterval = 5 * time.Minute
+
+// A WeatherLink Live is on our own network and updates constantly, so we can poll
+// it as often as we like.
+const weatherLinkUpdateInterval = 1 * time.Minute
+
+// Use a 10-minute interval for the NWS API.  Many stations report more often than
+// NOAA's hourly XML feed, but the API asks clients not to poll aggressively.
+const nwsUpdateInterval = 10 * time.Minute
+
+// rtl_433 sensors transmit every minute or less.  A reading older than this is
+// stale, since the sensor has probably dropped out of range.
+const mqttUpdateInterval = 10 * time.Minute
+
+// By default, run exec provider commands as often as we poll WU.  Users can change
+// this with exec-interval to suit whatever their command talks to.
+const execUpdateInterval = 5 * time.Minute
+
+// WeeWX writes an archive record every 5 minutes by default, but reading the latest
+// one from a local database is cheap, so we check every minute.
+const weewxUpdateInterval = 1 * time.Minute
+
+// APRS reports arrive in the background whenever stations send them, so we can
+// check for a new one as often as we like.
+const aprsUpdateInterval = 1 * time.Minute
+
+// Most NDBC stations report every 10 minutes, and some only hourly
+const ndbcUpdateInterval = 10 * time.Minute
+
+// NOAA updates its forecasts hourly
+const forecastUpdateInterval = 1 * time.Hour
+
+// Open-Meteo's models update every hour or so, but we fetch the hourly forecast
+// twice as often so that the tokens don't lag behind
+const hourlyForecastUpdateInterval = 30 * time.Minute
+
+// Open-Meteo's 15-minutely forecast is only useful if it's fresh, so we check
+// it often
+const nowcastUpdateInterval = 5 * time.Minute
+
+// Warnings are issued at short notice, so we check for them every few minutes
+const alertsUpdateInterval = 5 * time.Minute
+
+// AirNow and Open-Meteo both update the air quality hourly, so we check twice an
+// hour to keep up
+const airQualityUpdateInterval = 30 * time.Minute
+
+// CO-OPS stations measure the water every 6 minutes.  Tide predictions are only
+// fetched once a day.
+const tidesUpdateInterval = 10 * time.Minute
+
+// Providers that hold a connection open wait this long before reconnecting after
+// it fails, doubling the wait after each failure up to the maximum.
+const (
+	minReconnectDelay = 5 * time.Second
+	maxReconnectDelay = 5 * time.Minute
+)
+
 // WeatherBar holds our state and useful channels
 type WeatherBar struct {
 	cfg                 *Config
+	provider            WeatherProvider
 	loc                 GeoLocation
 	locMutex            sync.RWMutex
 	prevLoc             GeoLocation
@@ -46,18 +113,28 @@ type WeatherBar struct {
 	wxUpdateChan        chan struct{}
 	geoUpdateTickerChan <-chan time.Time
 	geoUpdateChan       chan struct{}
+	redrawChan          chan struct{}
+	moved               notifier
+	woke                notifier
+	forecast            ForecastSummary
+	forecastMutex       sync.RWMutex
+	hourly              HourlyForecast
+	hourlyMutex         sync.RWMutex
+	nowcast             Nowcast
+	nowcastMutex        sync.RWMutex
+	alerts              []Alert
+	alertsMutex         sync.RWMutex
+	sun                 SunTimes
+	sunMutex            sync.RWMutex
+	airQuality          AirQuality
+	airQualityMutex     sync.RWMutex
+	tides               Tides
+	tidesMutex          sync.RWMutex
+	elevation           float64
+	history             *ObservationHistory
 	debug               *bool
 }
 
-// WeatherObservation holds our current weather observation
-type WeatherObservation struct {
-	StationID   string
-	Temperature float64
-	BarometerMb float64
-	WindSpeed   float64
-	WindDir     float64
-}
-
 // GeoLocation holds information returned from the freegeoip.net
 // service API
 type GeoLocation struct {
@@ -112,18 +189,84 @@ func main() {
 	w.wxUpdateChan = make(chan struct{}, 1)
 	w.geoUpdateChan = make(chan struct{}, 1)
 	w.wxObsChan = make(chan CurrentObservation, 1)
+	w.redrawChan = make(chan struct{}, 1)
 
-	// If a WU API key was provided, use a shorter update interval
-	if w.cfg.Weather.WUAPIKey != "" {
-		w.wxUpdateTickerChan = time.NewTicker(wuUpdateInterval).C
-	} else {
-		w.wxUpdateTickerChan = time.NewTicker(noaaUpdateInterval).C
+	w.elevation, err = parseElevation(w.cfg)
+	if err != nil {
+		log.Fatalln("Error reading config file:", err)
+	}
+
+	if usesHistory(w.cfg.Format.WxFormat) {
+		historyFile := w.cfg.Weather.HistoryFile
+		if historyFile == "" {
+			historyFile, err = defaultHistoryFile()
+			if err != nil {
+				// Without a file, the history only lasts until we exit
+				log.Println("Error finding a place for the observation history:", err)
+			}
+		}
+		w.history, err = newObservationHistory(historyFile)
+		if err != nil {
+			// We can still build up a new history as we go
+			log.Println("Error loading observation history:", err)
+		}
+	}
+
+	w.provider, err = newConfiguredProvider(w)
+	if err != nil {
+		log.Fatalln("Error configuring weather provider:", err)
 	}
+	warnUnsupportedTokens(w.cfg.Format.WxFormat, w.provider)
+
+	// Poll the provider as often as it recommends
+	w.wxUpdateTickerChan = time.NewTicker(w.provider.UpdateInterval()).C
 
 	w.geoUpdateTickerChan = time.NewTicker(geoUpdateInterval).C
 
-	go w.weatherWatcher(ctx)
-	go w.locationWatcher(ctx)
+	if pp, ok := w.provider.(PushProvider); ok {
+		// Push providers tell us when the weather changes and don't care where we
+		// are, so we bypass polling and geolocation entirely.  Anything else that
+		// needs a location gets it from the config file.
+		_, err = w.getLocationFromConfig()
+		if err != nil {
+			log.Fatalln("invalid location in config file:", err)
+		}
+		go w.pushWatcher(ctx, pp)
+	} else if !needsSite(w.provider) {
+		// Likewise, providers that need neither a station nor a location are polled
+		// without geolocating
+		_, err = w.getLocationFromConfig()
+		if err != nil {
+			log.Fatalln("invalid location in config file:", err)
+		}
+		go w.weatherWatcher(ctx)
+		// Since we're just starting up, force a weather update.
+		w.wxUpdateChan <- struct{}{}
+	} else {
+		go w.weatherWatcher(ctx)
+		go w.locationWatcher(ctx)
+	}
+	if usesForecast(w.cfg.Format.WxFormat) {
+		go w.forecastWatcher(ctx, w.moved.subscribe())
+	}
+	if usesHourlyForecast(w.cfg.Format.WxFormat) {
+		go w.hourlyForecastWatcher(ctx, w.moved.subscribe())
+	}
+	if usesNowcast(w.cfg.Format.WxFormat) {
+		go w.nowcastWatcher(ctx, w.moved.subscribe())
+	}
+	if usesAlerts(w.cfg.Format.WxFormat) {
+		go w.alertsWatcher(ctx, w.moved.subscribe(), w.woke.subscribe())
+	}
+	if usesAirQuality(w.cfg.Format.WxFormat) {
+		go w.airQualityWatcher(ctx, w.moved.subscribe())
+	}
+	if usesTides(w.cfg.Format.WxFormat) {
+		go w.tidesWatcher(ctx, w.moved.subscribe())
+	}
+	if usesAstronomy(w.cfg.Format.WxFormat) {
+		go w.astronomyWatcher(ctx, w.moved.subscribe())
+	}
 	go w.sleepDetector(ctx)
 	go w.weatherReporter(ctx)
 
@@ -134,6 +277,8 @@ func main() {
 func (w *WeatherBar) weatherReporter(ctx context.Context) {
 	var output string
 	var cardIndex int
+	var obs CurrentObservation
+	var haveObs bool
 
 	cardDirections := []string{"  N", "NNE", " NE", "ENE",
 		"  E", "ESE", " SE", "SSE",
@@ -155,57 +300,259 @@ func (w *WeatherBar) weatherReporter(ctx context.Context) {
 	regHeatIndexF := regexp.MustCompile("%heat-index-fahrenheit%")
 	regWindChillC := regexp.MustCompile("%wind-chill-celcius%")
 	regHeatIndexC := regexp.MustCompile("%heat-index-celcius%")
+	regFeelsLikeF := regexp.MustCompile("%feels-like-fahrenheit%")
+	regFeelsLikeC := regexp.MustCompile("%feels-like-celcius%")
+	regDewpointF := regexp.MustCompile("%dewpoint-fahrenheit%")
+	regDewpointC := regexp.MustCompile("%dewpoint-celcius%")
+	regHumidex := regexp.MustCompile("%humidex%")
+	regWetBulbF := regexp.MustCompile("%wet-bulb-fahrenheit%")
+	regWetBulbC := regexp.MustCompile("%wet-bulb-celcius%")
 	regStationID := regexp.MustCompile("%station-id%")
 	regRainTodayInches := regexp.MustCompile("%rain-today-inches%")
 	regRain1HourInches := regexp.MustCompile("%rain-last-hour-inches%")
+	regRainRateInches := regexp.MustCompile("%rain-rate-inches%")
+	regIndoorTempF := regexp.MustCompile("%indoor-temperature-fahrenheit%")
+	regIndoorTempC := regexp.MustCompile("%indoor-temperature-celcius%")
+	regIndoorHumidity := regexp.MustCompile("%indoor-humidity%")
+	regRawMETAR := regexp.MustCompile("%metar-raw%")
+	regVisibility := regexp.MustCompile("%visibility%")
+	regCeiling := regexp.MustCompile("%ceiling%")
+	regPresentWeather := regexp.MustCompile("%present-weather%")
+	regWaveHeightFt := regexp.MustCompile("%wave-height-feet%")
+	regWaveHeightM := regexp.MustCompile("%wave-height-meters%")
+	regWavePeriod := regexp.MustCompile("%wave-period%")
+	regWaterTempF := regexp.MustCompile("%water-temperature-fahrenheit%")
+	regWaterTempC := regexp.MustCompile("%water-temperature-celcius%")
+	regPressureTrend := regexp.MustCompile("%pressure-trend%")
+	regPressureChange := regexp.MustCompile("%pressure-change%")
+	regTempChangeF := regexp.MustCompile("%temperature-change-fahrenheit%")
+	regTempChangeC := regexp.MustCompile("%temperature-change-celcius%")
+	regPeakGustMph := regexp.MustCompile("%peak-gust-mph%")
+	regPeakGustKph := regexp.MustCompile("%peak-gust-kph%")
+	regProvider := regexp.MustCompile("%provider%")
+	regTodayHigh := regexp.MustCompile("%today-high%")
+	regTodayLow := regexp.MustCompile("%today-low%")
+	regTonightPOP := regexp.MustCompile("%tonight-pop%")
+	regTomorrowSummary := regexp.MustCompile("%tomorrow-summary%")
+	regPrecipNowcast := regexp.MustCompile("%precip-nowcast%")
+	regClasses := regexp.MustCompile("%classes%")
+	regAlertCount := regexp.MustCompile("%alert-count%")
+	regAlertHeadline := regexp.MustCompile("%alert-headline%")
+	regAlertSeverity := regexp.MustCompile("%alert-severity%")
+	regAlertEvent := regexp.MustCompile("%alert-event%")
+	regAQI := regexp.MustCompile("%aqi%")
+	regAQICategory := regexp.MustCompile("%aqi-category%")
+	regPM25 := regexp.MustCompile("%pm25%")
+	regOzone := regexp.MustCompile("%ozone%")
+	regUVIndex := regexp.MustCompile("%uv-index%")
+	regNextTide := regexp.MustCompile("%next-tide%")
+	regTideTrend := regexp.MustCompile("%tide-trend%")
+	regWaterTemp := regexp.MustCompile("%water-temp%")
+	regSunrise := regexp.MustCompile("%sunrise%")
+	regSunset := regexp.MustCompile("%sunset%")
+	regDaylightRemaining := regexp.MustCompile("%daylight-remaining%")
+	regCivilDusk := regexp.MustCompile("%civil-dusk%")
+	regGoldenHour := regexp.MustCompile("%golden-hour%")
+	regMoonPhase := regexp.MustCompile("%moon-phase%")
+	regMoonIllumination := regexp.MustCompile("%moon-illumination%")
+
+	timeFormat := w.cfg.Format.TimeFormat
+	if timeFormat == "" {
+		timeFormat = defaultTimeFormat
+	}
 
 	for {
 		select {
-		case obs := <-w.wxObsChan:
-			cardIndex = int((float32(obs.WindDir) + float32(11.25)) / float32(22.5))
-			cardDirection := cardDirections[cardIndex%16]
-
-			tempC := (obs.Temperature - 32) * (5 / 9)
-			windChillC := (obs.WindChill - 32) * (5 / 9)
-			heatIndexC := (obs.HeatIndex - 32) * (5 / 9)
-			windSpeedKph := obs.WindSpeed * 1.60934
-			windGustFloat, _ := strconv.ParseFloat(obs.WindGust, 64)
-			windGustKph := windGustFloat * 1.60934
-
-			output = w.cfg.Format.WxFormat
-
-			output = regWeather.ReplaceAllLiteralString(output, obs.Weather)
-			output = regTempF.ReplaceAllLiteralString(output, fmt.Sprintf("%.1f", obs.Temperature))
-			output = regTempC.ReplaceAllLiteralString(output, fmt.Sprintf("%.1f", tempC))
-			output = regHumidity.ReplaceAllLiteralString(output, fmt.Sprintf("%v", obs.Humidity))
-			output = regBar.ReplaceAllLiteralString(output, fmt.Sprintf("%.2f", obs.Barometer))
-			output = regWindSpeedMph.ReplaceAllLiteralString(output, fmt.Sprintf("%v", obs.WindSpeed))
-			output = regWindSpeedKph.ReplaceAllLiteralString(output, fmt.Sprintf("%.0f", windSpeedKph))
-			output = regWindDirection.ReplaceAllLiteralString(output, fmt.Sprintf("%v", obs.WindDir))
-			output = regWindCardinal.ReplaceAllLiteralString(output, cardDirection)
-			output = regWindGustMph.ReplaceAllLiteralString(output, fmt.Sprintf("%v", obs.WindGust))
-			output = regWindGustKph.ReplaceAllLiteralString(output, fmt.Sprintf("%.0f", windGustKph))
-			output = regWindChillF.ReplaceAllLiteralString(output, fmt.Sprintf("%.1f", obs.WindChill))
-			output = regHeatIndexF.ReplaceAllLiteralString(output, fmt.Sprintf("%.1f", obs.HeatIndex))
-			output = regWindChillC.ReplaceAllLiteralString(output, fmt.Sprintf("%.1f", windChillC))
-			output = regHeatIndexC.ReplaceAllLiteralString(output, fmt.Sprintf("%.1f", heatIndexC))
-			output = regRainTodayInches.ReplaceAllLiteralString(output, fmt.Sprintf("%.2f", obs.RainToday))
-			output = regRain1HourInches.ReplaceAllLiteralString(output, fmt.Sprintf("%.2f", obs.Rain1Hour))
-			output = regStationID.ReplaceAllLiteralString(output, fmt.Sprintf("%v", obs.StationID))
-
-			fmt.Println(output)
+		case obs = <-w.wxObsChan:
+			obs = deriveObservation(obs, w.elevation)
+			haveObs = true
 
+			if w.history != nil {
+				err := w.history.add(obs, time.Now())
+				if err != nil {
+					log.Println("Error saving observation history:", err)
+				}
+			}
+		case <-w.redrawChan:
+			// Something other than the conditions has changed.  There's nothing to
+			// draw until the first conditions arrive.
+			if !haveObs {
+				continue
+			}
 		case <-ctx.Done():
 			log.Println("Termination request recieved.  Cancelling weather watcher.")
 			return
+		}
+
+		// A variable wind shows as VRB, and a provider that doesn't report the
+		// direction leaves it blank
+		windDirection := ""
+		cardDirection := "   "
+		if obs.Has(FieldWindDir) {
+			cardIndex = int((float32(obs.WindDir) + float32(11.25)) / float32(22.5))
+			cardDirection = cardDirections[cardIndex%16]
+			windDirection = fmt.Sprintf("%v", obs.WindDir)
+		} else if obs.WindVariable {
+			cardDirection = "VRB"
+			windDirection = "VRB"
+		}
+
+		tempC := fahrenheitToCelsius(obs.Temperature)
+		windChillC := fahrenheitToCelsius(obs.WindChill)
+		heatIndexC := fahrenheitToCelsius(obs.HeatIndex)
+		feelsLikeC := fahrenheitToCelsius(obs.FeelsLike)
+		dewpointC := fahrenheitToCelsius(obs.Dewpoint)
+		wetBulbC := fahrenheitToCelsius(obs.WetBulb)
+		indoorTempC := fahrenheitToCelsius(obs.IndoorTemperature)
+		waterTempC := fahrenheitToCelsius(obs.WaterTemperature)
+		waveHeightM := obs.WaveHeight * metersPerFoot
+		windSpeedKph := obs.WindSpeed * kphPerMph
+		windGustKph := obs.WindGust * kphPerMph
+
+		// A provider chain tells us which of its providers answered
+		provider := obs.Provider
+		if provider == "" {
+			provider = w.provider.Name()
+		}
+
+		ceiling := "none"
+		if obs.HasCeiling {
+			ceiling = fmt.Sprintf("%.0f", obs.Ceiling)
+		}
 
+		now := time.Now()
+		nowcast := w.currentNowcast()
+		alerts := w.currentAlerts(now)
+
+		// The most severe alert is the one we show
+		var alert Alert
+		if len(alerts) > 0 {
+			alert = alerts[0]
+		}
+
+		// Classes are set by conditions that the format can show or hide sections for
+		classes := make(map[string]bool)
+		nowcast.Classes(now, classes)
+		alertClasses(alerts, classes)
+		aq := w.currentAirQuality()
+		aq.Classes(classes)
+
+		output = applyConditionals(w.cfg.Format.WxFormat, classes)
+
+		output = regWeather.ReplaceAllLiteralString(output, obs.Weather)
+		output = regTempF.ReplaceAllLiteralString(output, fmt.Sprintf("%.1f", obs.Temperature))
+		output = regTempC.ReplaceAllLiteralString(output, fmt.Sprintf("%.1f", tempC))
+		output = regHumidity.ReplaceAllLiteralString(output, fmt.Sprintf("%v", obs.Humidity))
+		output = regBar.ReplaceAllLiteralString(output, fmt.Sprintf("%.2f", obs.Barometer))
+		output = regWindSpeedMph.ReplaceAllLiteralString(output, fmt.Sprintf("%v", obs.WindSpeed))
+		output = regWindSpeedKph.ReplaceAllLiteralString(output, fmt.Sprintf("%.0f", windSpeedKph))
+		output = regWindDirection.ReplaceAllLiteralString(output, windDirection)
+		output = regWindCardinal.ReplaceAllLiteralString(output, cardDirection)
+		output = regWindGustMph.ReplaceAllLiteralString(output, fmt.Sprintf("%v", obs.WindGust))
+		output = regWindGustKph.ReplaceAllLiteralString(output, fmt.Sprintf("%.0f", windGustKph))
+		output = regWindChillF.ReplaceAllLiteralString(output, fmt.Sprintf("%.1f", obs.WindChill))
+		output = regHeatIndexF.ReplaceAllLiteralString(output, fmt.Sprintf("%.1f", obs.HeatIndex))
+		output = regWindChillC.ReplaceAllLiteralString(output, fmt.Sprintf("%.1f", windChillC))
+		output = regHeatIndexC.ReplaceAllLiteralString(output, fmt.Sprintf("%.1f", heatIndexC))
+		output = regFeelsLikeF.ReplaceAllLiteralString(output, fmt.Sprintf("%.1f", obs.FeelsLike))
+		output = regFeelsLikeC.ReplaceAllLiteralString(output, fmt.Sprintf("%.1f", feelsLikeC))
+		output = regDewpointF.ReplaceAllLiteralString(output, fmt.Sprintf("%.1f", obs.Dewpoint))
+		output = regDewpointC.ReplaceAllLiteralString(output, fmt.Sprintf("%.1f", dewpointC))
+		output = regHumidex.ReplaceAllLiteralString(output, fmt.Sprintf("%.0f", obs.Humidex))
+		output = regWetBulbF.ReplaceAllLiteralString(output, fmt.Sprintf("%.1f", obs.WetBulb))
+		output = regWetBulbC.ReplaceAllLiteralString(output, fmt.Sprintf("%.1f", wetBulbC))
+		output = regRainTodayInches.ReplaceAllLiteralString(output, fmt.Sprintf("%.2f", obs.RainToday))
+		output = regRain1HourInches.ReplaceAllLiteralString(output, fmt.Sprintf("%.2f", obs.Rain1Hour))
+		output = regStationID.ReplaceAllLiteralString(output, fmt.Sprintf("%v", obs.StationID))
+		output = regRainRateInches.ReplaceAllLiteralString(output, fmt.Sprintf("%.2f", obs.RainRate))
+		output = regIndoorTempF.ReplaceAllLiteralString(output, fmt.Sprintf("%.1f", obs.IndoorTemperature))
+		output = regIndoorTempC.ReplaceAllLiteralString(output, fmt.Sprintf("%.1f", indoorTempC))
+		output = regIndoorHumidity.ReplaceAllLiteralString(output, fmt.Sprintf("%v", obs.IndoorHumidity))
+		output = regRawMETAR.ReplaceAllLiteralString(output, obs.RawMETAR)
+		output = regVisibility.ReplaceAllLiteralString(output, fmt.Sprintf("%v", obs.Visibility))
+		output = regCeiling.ReplaceAllLiteralString(output, ceiling)
+		output = regPresentWeather.ReplaceAllLiteralString(output, obs.PresentWeather)
+		output = regWaveHeightFt.ReplaceAllLiteralString(output, fmt.Sprintf("%.1f", obs.WaveHeight))
+		output = regWaveHeightM.ReplaceAllLiteralString(output, fmt.Sprintf("%.1f", waveHeightM))
+		output = regWavePeriod.ReplaceAllLiteralString(output, fmt.Sprintf("%.0f", obs.WavePeriod))
+		output = regWaterTempF.ReplaceAllLiteralString(output, fmt.Sprintf("%.1f", obs.WaterTemperature))
+		output = regWaterTempC.ReplaceAllLiteralString(output, fmt.Sprintf("%.1f", waterTempC))
+		output = regProvider.ReplaceAllLiteralString(output, provider)
+
+		if w.history != nil {
+			pressureChange, hasPressureChange := w.history.PressureChange()
+			tempChange, hasTempChange := w.history.TemperatureChange()
+			peakGust, hasPeakGust := w.history.PeakGust()
+			output = regPressureTrend.ReplaceAllLiteralString(output, w.history.PressureTrend())
+			output = regPressureChange.ReplaceAllLiteralString(output, formatOptional("%+.1f", pressureChange, hasPressureChange))
+			output = regTempChangeF.ReplaceAllLiteralString(output, formatOptional("%+.1f", tempChange, hasTempChange))
+			output = regTempChangeC.ReplaceAllLiteralString(output, formatOptional("%+.1f", tempChange*5/9, hasTempChange))
+			output = regPeakGustMph.ReplaceAllLiteralString(output, formatOptional("%.0f", peakGust, hasPeakGust))
+			output = regPeakGustKph.ReplaceAllLiteralString(output, formatOptional("%.0f", peakGust*kphPerMph, hasPeakGust))
 		}
+
+		fc := w.currentForecast()
+		output = regTodayHigh.ReplaceAllLiteralString(output, formatOptional("%.0f", fc.TodayHigh, fc.HasTodayHigh))
+		output = regTodayLow.ReplaceAllLiteralString(output, formatOptional("%.0f", fc.TodayLow, fc.HasTodayLow))
+		output = regTonightPOP.ReplaceAllLiteralString(output, formatOptional("%.0f", fc.TonightPOP, fc.HasTonightPOP))
+		output = regTomorrowSummary.ReplaceAllLiteralString(output, fc.TomorrowSummary)
+
+		hourly := w.currentHourlyForecast()
+		output = hourlyTokenRegexp.ReplaceAllStringFunc(output, func(token string) string {
+			return hourly.token(token, now)
+		})
+
+		output = regPrecipNowcast.ReplaceAllLiteralString(output, nowcast.Text(now))
+		output = regAlertCount.ReplaceAllLiteralString(output, fmt.Sprintf("%v", len(alerts)))
+		output = regAlertHeadline.ReplaceAllLiteralString(output, alert.Headline)
+		output = regAlertSeverity.ReplaceAllLiteralString(output, alert.Severity)
+		output = regAlertEvent.ReplaceAllLiteralString(output, alert.Event)
+
+		output = regAQI.ReplaceAllLiteralString(output, formatOptional("%.0f", aq.AQI, aq.HasAQI))
+		output = regAQICategory.ReplaceAllLiteralString(output, aq.Category())
+		output = regPM25.ReplaceAllLiteralString(output, formatOptional("%.1f", aq.PM25, aq.HasPM25))
+		output = regOzone.ReplaceAllLiteralString(output, formatOptional("%.0f", aq.Ozone, aq.HasOzone))
+		output = regUVIndex.ReplaceAllLiteralString(output, formatOptional("%.0f", aq.UVIndex, aq.HasUVIndex))
+
+		tides := w.currentTides()
+		output = regNextTide.ReplaceAllLiteralString(output, tides.NextTide(now, timeFormat))
+		output = regTideTrend.ReplaceAllLiteralString(output, tides.TrendText(now))
+		output = regWaterTemp.ReplaceAllLiteralString(output, formatOptional("%.1f", tides.WaterTemp, tides.HasWaterTemp))
+
+		sun := w.currentSunTimes()
+		moon := moonPhase(now)
+		output = regSunrise.ReplaceAllLiteralString(output, formatTime(sun.Sunrise, timeFormat))
+		output = regSunset.ReplaceAllLiteralString(output, formatTime(sun.Sunset, timeFormat))
+		output = regDaylightRemaining.ReplaceAllLiteralString(output, sun.DaylightRemaining(now))
+		output = regCivilDusk.ReplaceAllLiteralString(output, formatTime(sun.CivilDusk, timeFormat))
+		output = regGoldenHour.ReplaceAllLiteralString(output, formatTime(sun.GoldenHour, timeFormat))
+		output = regMoonPhase.ReplaceAllLiteralString(output, moon.Glyph+" "+moon.Name)
+		output = regMoonIllumination.ReplaceAllLiteralString(output, fmt.Sprintf("%.0f", moon.Illumination))
+
+		output = regClasses.ReplaceAllLiteralString(output, classList(classes))
+
+		fmt.Println(output)
 	}
 }
 
-func (w *WeatherBar) weatherWatcher(ctx context.Context) {
-	var err error
+// formatOptional formats a value that we may not have, leaving it blank if we don't
+func formatOptional(format string, v float64, ok bool) string {
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf(format, v)
+}
 
+// redraw asks the reporter to print the bar again, for when something other than
+// the conditions has changed
+func (w *WeatherBar) redraw() {
+	select {
+	case w.redrawChan <- struct{}{}:
+	default:
+	}
+}
+
+func (w *WeatherBar) weatherWatcher(ctx context.Context) {
 	for {
 		select {
 		case <-w.wxUpdateTickerChan:
@@ -213,49 +560,28 @@ func (w *WeatherBar) weatherWatcher(ctx context.Context) {
 			w.wxUpdateChan <- struct{}{}
 		case <-w.wxUpdateChan:
 
-			// If our station ID is not yet set, that's probably because the geolocation hasn't
-			// finished.  Sleep until it has.
-			for {
-				w.stationMutex.RLock()
-				if w.station.Id == "" {
-					w.stationMutex.RUnlock()
-					if *w.debug {
-						log.Println("Station ID not yet determined.  Sleeping 1s")
-					}
-					time.Sleep(time.Second)
-				} else {
-					w.stationMutex.RUnlock()
-					break
+			// If our station ID or location is not yet set, that's probably because the
+			// geolocation hasn't finished.  Sleep until it has.
+			site := w.currentSite()
+			for !w.siteReady(site) {
+				if *w.debug {
+					log.Println("Weather site not yet determined.  Sleeping 1s")
 				}
+				time.Sleep(time.Second)
+				site = w.currentSite()
 			}
 
-			w.stationMutex.RLock()
-			// If a Weather Underground API key has been set in the config, use that
-			// service to fetch the weather conditions.  Otherwise, use NOAA.
-			if w.cfg.Weather.WUAPIKey != "" {
-
-				// WU supports two types of stations: ICAO (official government-run stations)
-				// and PWS (personal weather stations, typically run by individuals, businesses, etc.)
-				// If our station ID is 4 bytes long, it's almost certainly an ICAO station, so
-				// we pass it to our getter as such.  Otherwise, we pass the station ID as a PWS
-				// ID.
-				if len(w.station.Id) == 4 {
-					err = w.getCurrentConditionsFromWU(w.station.Id, "")
-				} else {
-					err = w.getCurrentConditionsFromWU("", w.station.Id)
-				}
-				if err != nil {
-					log.Println(err)
-					continue
-				}
-			} else {
-				err := w.getCurrentConditionsFromNOAA(w.station.Id)
-				if err != nil {
-					log.Println(err)
-					continue
-				}
+			obs, err := w.provider.FetchObservation(ctx, site)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+
+			if *w.debug {
+				log.Printf("Current observation: %+v\n", obs)
 			}
-			w.stationMutex.RUnlock()
+
+			w.wxObsChan <- obs
 
 		case <-ctx.Done():
 			log.Println("Termination request recieved.  Cancelling weather watcher.")
@@ -266,8 +592,47 @@ func (w *WeatherBar) weatherWatcher(ctx context.Context) {
 
 }
 
+// pushWatcher runs a push provider, which sends observations as they arrive
+func (w *WeatherBar) pushWatcher(ctx context.Context, p PushProvider) {
+	err := p.Run(ctx, w.wxObsChan)
+	if err != nil {
+		log.Fatalln("Error running", p.Name(), "weather provider:", err)
+	}
+}
+
+// currentSite returns the station and location that we're reporting weather for
+func (w *WeatherBar) currentSite() WeatherSite {
+	var site WeatherSite
+
+	w.stationMutex.RLock()
+	if w.station != nil {
+		site.StationID = w.station.Id
+	}
+	w.stationMutex.RUnlock()
+
+	w.pointMutex.RLock()
+	site.Point = w.point
+	w.pointMutex.RUnlock()
+
+	return site
+}
+
+// siteReady returns true once the site has what our provider needs to fetch conditions
+func (w *WeatherBar) siteReady(site WeatherSite) bool {
+	if !needsSite(w.provider) {
+		return true
+	}
+	if needsStation(w.provider) {
+		return site.StationID != ""
+	}
+	return site.Point.Latitude != 0 || site.Point.Longitude != 0
+}
+
 func (w *WeatherBar) locationWatcher(ctx context.Context) {
-	if w.cfg.Weather.Station != "" {
+	// Providers that look up conditions by latitude/longitude have no use for a station
+	usesStation := needsStation(w.provider)
+
+	if w.cfg.Weather.Station != "" && usesStation {
 		// We were given a NOAA station ID in our config file so we will use that and
 		// forego any further geolocation activities by exiting this goroutine
 		if *w.debug {
@@ -277,17 +642,32 @@ func (w *WeatherBar) locationWatcher(ctx context.Context) {
 		w.station = &noaa.Station{Id: w.cfg.Weather.Station}
 		w.stationMutex.Unlock()
 
+		// Providers further down a chain may still want a location
+		_, err := w.getLocationFromConfig()
+		if err != nil {
+			log.Fatalln("invalid location in config file:", err)
+		}
+
 		// Since we're just starting up, force a weather update.
 		w.wxUpdateChan <- struct{}{}
 
 		return
 	}
 
-	// Force a geolocation update.  We'll need a starting point in order to monitor
-	// for location changes.
-	err := w.getLocationFromFreeGEOIP()
+	// If the user hard-coded a lat/lon in the config file, we'll use that instead of
+	// geolocating.
+	pointFromConfig, err := w.getLocationFromConfig()
 	if err != nil {
-		log.Fatalln("could not get location:", err)
+		log.Fatalln("invalid location in config file:", err)
+	}
+
+	if !pointFromConfig {
+		// Force a geolocation update.  We'll need a starting point in order to monitor
+		// for location changes.
+		err = w.getLocationFromFreeGEOIP()
+		if err != nil {
+			log.Fatalln("could not get location:", err)
+		}
 	}
 
 	// Update our previous location with our current location
@@ -298,17 +678,19 @@ func (w *WeatherBar) locationWatcher(ctx context.Context) {
 	}
 	w.locMutex.RUnlock()
 
-	// Find our nearest weather station and update our station object
-	w.pointMutex.RLock()
-	w.stationMutex.Lock()
+	if usesStation {
+		// Find our nearest weather station and update our station object
+		w.pointMutex.RLock()
+		w.stationMutex.Lock()
 
-	w.station = w.point.NearestStation()
-	if *w.debug {
-		log.Println("Nearest ICAO station:", w.station.Id)
-	}
+		w.station = w.point.NearestStation()
+		if *w.debug {
+			log.Println("Nearest ICAO station:", w.station.Id)
+		}
 
-	w.stationMutex.Unlock()
-	w.pointMutex.RUnlock()
+		w.stationMutex.Unlock()
+		w.pointMutex.RUnlock()
+	}
 	// Since we're just starting up, force a weather update.
 	w.wxUpdateChan <- struct{}{}
 
@@ -320,7 +702,7 @@ func (w *WeatherBar) locationWatcher(ctx context.Context) {
 		case <-w.geoUpdateChan:
 			// Check to see if the user hard-coded a lat/lon in the config file.
 			// If the user provided a lat/lon, we don't need to geolocate.
-			if (w.point.Latitude == 0) || (w.point.Longitude == 0) {
+			if !pointFromConfig {
 				// Our geolocation update timer has ticked, so we'll run a geolocation
 				// update and see if the location has changed.
 				// Kick off a geolocation update...
@@ -343,20 +725,23 @@ func (w *WeatherBar) locationWatcher(ctx context.Context) {
 				}
 			}
 
-			// Find our nearest weather station and update our station object
-			w.pointMutex.RLock()
-			w.stationMutex.Lock()
-			station := w.point.NearestStation()
-			w.stationMutex.Unlock()
-			w.pointMutex.RUnlock()
-			if *w.debug {
-				log.Println("STATION:", station.Id)
+			if usesStation {
+				// Find our nearest weather station and update our station object
+				w.pointMutex.RLock()
+				w.stationMutex.Lock()
+				w.station = w.point.NearestStation()
+				if *w.debug {
+					log.Println("STATION:", w.station.Id)
+				}
+				w.stationMutex.Unlock()
+				w.pointMutex.RUnlock()
 			}
 
 			w.locMutex.RLock()
 			if (w.loc.Latitude != w.prevLoc.Latitude) || (w.loc.Longitude != w.prevLoc.Longitude) {
 				// We've moved, so let's kick off a weather update.
 				w.wxUpdateChan <- struct{}{}
+				w.moved.announce()
 			}
 
 			// Set our previous location to our current location
@@ -386,8 +771,14 @@ func (w *WeatherBar) sleepDetector(ctx context.Context) {
 			dur := t.Sub(prevTime)
 			if dur > 30*time.Second {
 				log.Println("WAKEUP DETECTED!!!")
-				// We've been sleeping so check our location and update weather if necessary
-				w.geoUpdateChan <- struct{}{}
+				w.woke.announce()
+				// We've been sleeping so check our location and update weather if necessary.
+				// Don't block if the location watcher isn't running or already has an
+				// update queued.
+				select {
+				case w.geoUpdateChan <- struct{}{}:
+				default:
+				}
 			}
 			prevTime = t
 
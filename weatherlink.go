@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The WeatherLink Live broadcasts real-time wind and rain on UDP for as long as we
+// ask it to, so we ask for 20 minutes at a time and renew well before that runs out.
+const (
+	wllRealTimeDuration = 20 * time.Minute
+	wllRealTimeRenewal  = 10 * time.Minute
+)
+
+// WeatherLink Live data structure types, which tell us which sensor a block of
+// conditions came from
+const (
+	wllISS           = 1 // Integrated sensor suite (outdoor temperature, wind, rain)
+	wllLeafSoil      = 2
+	wllBarometer     = 3 // Barometer in the WLL base station
+	wllIndoorTempHum = 4 // Temperature/humidity sensor in the WLL base station
+)
+
+func init() {
+	registerProvider("weatherlink", newWeatherLinkLiveProvider)
+}
+
+// WeatherLinkLiveProvider polls a Davis WeatherLink Live on the local network and,
+// optionally, listens for the wind and rain updates that it broadcasts every 2.5 seconds
+type WeatherLinkLiveProvider struct {
+	host      string
+	realTime  bool
+	stationID string
+	client    *http.Client
+	debug     bool
+
+	latestMutex sync.Mutex
+	latest      CurrentObservation
+}
+
+// WLLResponse encapsulates the WeatherLink Live's HTTP API response object
+type WLLResponse struct {
+	Data  json.RawMessage `json:"data"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// WLLCurrentConditions holds the conditions reported by every sensor attached to a
+// WeatherLink Live.  UDP broadcasts use the same layout with fewer fields.
+type WLLCurrentConditions struct {
+	DID        string          `json:"did"`
+	Timestamp  int64           `json:"ts"`
+	Conditions []WLLConditions `json:"conditions"`
+}
+
+// WLLConditions is one sensor's block of conditions.  Which fields are present
+// depends on DataStructureType.  Rain is reported as a count of rain collector
+// tips, whose size is given by RainSize.
+type WLLConditions struct {
+	LSID              int64    `json:"lsid"`
+	DataStructureType int      `json:"data_structure_type"`
+	TxID              int      `json:"txid"`
+	Temp              *float64 `json:"temp"`
+	Hum               *float64 `json:"hum"`
+	DewPoint          *float64 `json:"dew_point"`
+	HeatIndex         *float64 `json:"heat_index"`
+	WindChill         *float64 `json:"wind_chill"`
+	THWIndex          *float64 `json:"thw_index"`
+	WindSpeedLast     *float64 `json:"wind_speed_last"`
+	WindDirLast       *float64 `json:"wind_dir_last"`
+	WindSpeedAvg1Min  *float64 `json:"wind_speed_avg_last_1_min"`
+	WindDirAvg1Min    *float64 `json:"wind_dir_scalar_avg_last_1_min"`
+	WindSpeedHi10Min  *float64 `json:"wind_speed_hi_last_10_min"`
+	RainSize          *int     `json:"rain_size"`
+	RainRateLast      *float64 `json:"rain_rate_last"`
+	RainfallLast60Min *float64 `json:"rainfall_last_60_min"`
+	Rain60Min         *float64 `json:"rain_60_min"`
+	RainfallDaily     *float64 `json:"rainfall_daily"`
+	TempIn            *float64 `json:"temp_in"`
+	HumIn             *float64 `json:"hum_in"`
+	BarSeaLevel       *float64 `json:"bar_sea_level"`
+	BarAbsolute       *float64 `json:"bar_absolute"`
+}
+
+// WLLRealTime encapsulates the response to a request for UDP broadcasts
+type WLLRealTime struct {
+	BroadcastPort int `json:"broadcast_port"`
+	Duration      int `json:"duration"`
+}
+
+func newWeatherLinkLiveProvider(w *WeatherBar) (WeatherProvider, error) {
+	if w.cfg.Weather.WeatherLinkHost == "" {
+		return nil, fmt.Errorf("the weatherlink provider requires weatherlink-host to be set")
+	}
+
+	p := &WeatherLinkLiveProvider{
+		host:      w.cfg.Weather.WeatherLinkHost,
+		stationID: w.cfg.Weather.Station,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		debug:     *w.debug,
+	}
+	if p.stationID == "" {
+		p.stationID = "WLL"
+	}
+
+	if w.cfg.Weather.WeatherLinkRealTime != "" {
+		var err error
+		p.realTime, err = strconv.ParseBool(w.cfg.Weather.WeatherLinkRealTime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for weatherlink-realtime: %v", w.cfg.Weather.WeatherLinkRealTime)
+		}
+	}
+
+	return p, nil
+}
+
+// Name returns the name of this provider
+func (p *WeatherLinkLiveProvider) Name() string {
+	return "weatherlink"
+}
+
+// UpdateInterval returns the polling interval for the WeatherLink Live's HTTP API
+func (p *WeatherLinkLiveProvider) UpdateInterval() time.Duration {
+	return weatherLinkUpdateInterval
+}
+
+// SupportedFields returns the fields reported by a WeatherLink Live
+func (p *WeatherLinkLiveProvider) SupportedFields() []ObservationField {
+	return []ObservationField{
+		FieldStationID,
+		FieldTemperature,
+		FieldHumidity,
+		FieldDewpoint,
+		FieldWindChill,
+		FieldHeatIndex,
+		FieldFeelsLike,
+		FieldWindDir,
+		FieldWindSpeed,
+		FieldWindGust,
+		FieldBarometer,
+		FieldStationPressure,
+		FieldRainToday,
+		FieldRain1Hour,
+		FieldRainRate,
+		FieldIndoorTemperature,
+		FieldIndoorHumidity,
+	}
+}
+
+// FetchObservation polls the WeatherLink Live for its current conditions
+func (p *WeatherLinkLiveProvider) FetchObservation(ctx context.Context, site WeatherSite) (CurrentObservation, error) {
+	if p.debug {
+		log.Println("Fetching conditions from WeatherLink Live at", p.host)
+	}
+
+	var cur WLLCurrentConditions
+	err := p.get(ctx, "/v1/current_conditions", &cur)
+	if err != nil {
+		return CurrentObservation{}, err
+	}
+
+	var obs CurrentObservation
+	obs.setText(FieldStationID, p.stationID)
+	cur.applyTo(&obs)
+
+	p.latestMutex.Lock()
+	p.latest = obs
+	p.latestMutex.Unlock()
+
+	return obs, nil
+}
+
+// Run polls the WeatherLink Live and, if real-time updates are enabled, listens
+// for its UDP broadcasts.  Every poll and every broadcast produces an observation.
+func (p *WeatherLinkLiveProvider) Run(ctx context.Context, obsChan chan<- CurrentObservation) error {
+	var packets <-chan WLLCurrentConditions
+	var renewals <-chan time.Time
+
+	if p.realTime {
+		port, err := p.requestRealTime(ctx)
+		if err != nil {
+			return err
+		}
+
+		conn, err := net.ListenPacket("udp", ":"+strconv.Itoa(port))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		packets = p.readBroadcasts(ctx, conn)
+
+		renewTicker := time.NewTicker(wllRealTimeRenewal)
+		defer renewTicker.Stop()
+		renewals = renewTicker.C
+	}
+
+	pollTicker := time.NewTicker(p.UpdateInterval())
+	defer pollTicker.Stop()
+
+	p.poll(ctx, obsChan)
+
+	for {
+		select {
+		case <-pollTicker.C:
+			p.poll(ctx, obsChan)
+
+		case <-renewals:
+			_, err := p.requestRealTime(ctx)
+			if err != nil {
+				log.Println("error renewing WeatherLink Live real-time broadcasts:", err)
+			}
+
+		case packet := <-packets:
+			// Broadcasts only carry wind and rain, so we update those fields on top
+			// of the last full set of conditions
+			p.latestMutex.Lock()
+			packet.applyTo(&p.latest)
+			obs := p.latest
+			p.latestMutex.Unlock()
+
+			select {
+			case obsChan <- obs:
+			case <-ctx.Done():
+				return nil
+			}
+
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// poll fetches the current conditions and sends them to obsChan
+func (p *WeatherLinkLiveProvider) poll(ctx context.Context, obsChan chan<- CurrentObservation) {
+	obs, err := p.FetchObservation(ctx, WeatherSite{})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	select {
+	case obsChan <- obs:
+	case <-ctx.Done():
+	}
+}
+
+// requestRealTime asks the WeatherLink Live to broadcast real-time updates and
+// returns the UDP port that it will broadcast on
+func (p *WeatherLinkLiveProvider) requestRealTime(ctx context.Context) (int, error) {
+	var rt WLLRealTime
+	err := p.get(ctx, "/v1/real_time?duration="+strconv.Itoa(int(wllRealTimeDuration.Seconds())), &rt)
+	if err != nil {
+		return 0, err
+	}
+	if rt.BroadcastPort == 0 {
+		return 0, fmt.Errorf("WeatherLink Live at %v did not return a broadcast port", p.host)
+	}
+	return rt.BroadcastPort, nil
+}
+
+// readBroadcasts decodes UDP broadcasts until ctx is cancelled
+func (p *WeatherLinkLiveProvider) readBroadcasts(ctx context.Context, conn net.PacketConn) <-chan WLLCurrentConditions {
+	packets := make(chan WLLCurrentConditions)
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	go func() {
+		buf := make([]byte, 8192)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Println("error reading WeatherLink Live broadcast:", err)
+				}
+				return
+			}
+
+			var packet WLLCurrentConditions
+			err = json.Unmarshal(buf[:n], &packet)
+			if err != nil {
+				if p.debug {
+					log.Println("error decoding WeatherLink Live broadcast:", err)
+				}
+				continue
+			}
+
+			select {
+			case packets <- packet:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return packets
+}
+
+// get fetches a WeatherLink Live API path and decodes its data object into v
+func (p *WeatherLinkLiveProvider) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequest("GET", "http://"+p.host+path, nil)
+	if err != nil {
+		return err
+	}
+
+	r, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	var resp WLLResponse
+	err = json.NewDecoder(r.Body).Decode(&resp)
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("WeatherLink Live error: %v (%v)", resp.Error.Message, resp.Error.Code)
+	}
+
+	return json.Unmarshal(resp.Data, v)
+}
+
+// applyTo copies every reported condition into obs, leaving fields that weren't
+// reported alone.  When there are several sensors of one type, the first wins.
+func (c WLLCurrentConditions) applyTo(obs *CurrentObservation) {
+	var sawISS, sawIndoor, sawBarometer bool
+
+	if c.Timestamp != 0 {
+		obs.ObservedAt = time.Unix(c.Timestamp, 0)
+	}
+
+	for _, cond := range c.Conditions {
+		switch cond.DataStructureType {
+		case wllISS:
+			if sawISS {
+				continue
+			}
+			sawISS = true
+			cond.applyISS(obs)
+
+		case wllIndoorTempHum:
+			if sawIndoor {
+				continue
+			}
+			sawIndoor = true
+			obs.setIfPresent(FieldIndoorTemperature, cond.TempIn)
+			obs.setIfPresent(FieldIndoorHumidity, cond.HumIn)
+
+		case wllBarometer:
+			if sawBarometer {
+				continue
+			}
+			sawBarometer = true
+			if cond.BarSeaLevel != nil {
+				obs.set(FieldBarometer, roundTenth(*cond.BarSeaLevel*millibarsPerInchHg))
+			}
+			if cond.BarAbsolute != nil {
+				obs.set(FieldStationPressure, roundTenth(*cond.BarAbsolute*millibarsPerInchHg))
+			}
+		}
+	}
+}
+
+// applyISS copies the conditions from an integrated sensor suite into obs
+func (cond WLLConditions) applyISS(obs *CurrentObservation) {
+	obs.setIfPresent(FieldTemperature, cond.Temp)
+	obs.setIfPresent(FieldHumidity, cond.Hum)
+	obs.setIfPresent(FieldDewpoint, cond.DewPoint)
+	obs.setIfPresent(FieldHeatIndex, cond.HeatIndex)
+	obs.setIfPresent(FieldWindChill, cond.WindChill)
+	obs.setIfPresent(FieldFeelsLike, cond.THWIndex)
+
+	// HTTP polls give us a one-minute average, while UDP broadcasts only give us
+	// the latest reading
+	if cond.WindSpeedAvg1Min != nil {
+		obs.set(FieldWindSpeed, *cond.WindSpeedAvg1Min)
+	} else {
+		obs.setIfPresent(FieldWindSpeed, cond.WindSpeedLast)
+	}
+	if cond.WindDirAvg1Min != nil {
+		obs.set(FieldWindDir, *cond.WindDirAvg1Min)
+	} else {
+		obs.setIfPresent(FieldWindDir, cond.WindDirLast)
+	}
+	obs.setIfPresent(FieldWindGust, cond.WindSpeedHi10Min)
+
+	if cond.RainSize == nil {
+		return
+	}
+	if cond.RainRateLast != nil {
+		obs.set(FieldRainRate, wllRainInches(*cond.RainRateLast, *cond.RainSize))
+	}
+	if cond.RainfallDaily != nil {
+		obs.set(FieldRainToday, wllRainInches(*cond.RainfallDaily, *cond.RainSize))
+	}
+	if cond.RainfallLast60Min != nil {
+		obs.set(FieldRain1Hour, wllRainInches(*cond.RainfallLast60Min, *cond.RainSize))
+	} else if cond.Rain60Min != nil {
+		obs.set(FieldRain1Hour, wllRainInches(*cond.Rain60Min, *cond.RainSize))
+	}
+}
+
+// wllRainInches converts a count of rain collector tips into inches
+func wllRainInches(counts float64, rainSize int) float64 {
+	switch rainSize {
+	case 1:
+		return counts * 0.01
+	case 2:
+		return counts * 0.2 / millimetersPerInch
+	case 3:
+		return counts * 0.1 / millimetersPerInch
+	case 4:
+		return counts * 0.001
+	}
+	return 0
+}
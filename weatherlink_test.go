@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wllTestConditions has a second ISS and a leaf/soil station, which we ignore,
+// and rain counted in 0.01" tips
+const wllTestConditions = `{"data": {"did": "001D0A700002", "ts": 1710442380, "conditions": [
+  {"lsid": 48308, "data_structure_type": 1, "txid": 1, "temp": 62.7, "hum": 41.4, "dew_point": 39.0,
+    "heat_index": 61.2, "wind_chill": 62.7, "thw_index": 61.2,
+    "wind_speed_last": 6.0, "wind_dir_last": 210, "wind_speed_avg_last_1_min": 4.8, "wind_dir_scalar_avg_last_1_min": 205,
+    "wind_speed_hi_last_10_min": 12.0, "rain_size": 1, "rain_rate_last": 0, "rainfall_last_60_min": 3, "rainfall_daily": 12},
+  {"lsid": 48309, "data_structure_type": 2, "txid": 3, "temp_1": 55.1, "moist_soil_1": 31},
+  {"lsid": 48310, "data_structure_type": 1, "txid": 2, "temp": 99.9, "hum": 99.9, "rain_size": 1, "rainfall_daily": 99},
+  {"lsid": 48311, "data_structure_type": 4, "temp_in": 70.3, "hum_in": 36.0, "dew_point_in": 42.0},
+  {"lsid": 48312, "data_structure_type": 3, "bar_sea_level": 29.92, "bar_trend": -0.012, "bar_absolute": 28.85}
+]}, "error": null}`
+
+// wllTestBroadcast is a UDP broadcast, with just the latest wind and rain counted
+// in 0.2 mm tips
+const wllTestBroadcast = `{"did": "001D0A700002", "ts": 1710442400, "conditions": [
+  {"lsid": 48308, "data_structure_type": 1, "txid": 1, "wind_speed_last": 9.0, "wind_dir_last": 230,
+    "wind_speed_hi_last_10_min": 15.0, "rain_size": 2, "rain_rate_last": 10, "rain_15_min": 2, "rain_60_min": 5,
+    "rain_24_hr": 70, "rain_storm": 70, "rainfall_daily": 63, "rainfall_monthly": 120, "rainfall_year": 400}
+]}`
+
+func TestWeatherLinkLiveFetchObservation(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		switch r.URL.Path {
+		case "/v1/current_conditions":
+			fmt.Fprint(w, wllTestConditions)
+		default:
+			fmt.Fprint(w, `{"data": null, "error": {"code": 404, "message": "Not Found"}}`)
+		}
+	}))
+	defer server.Close()
+
+	p := &WeatherLinkLiveProvider{
+		host:      strings.TrimPrefix(server.URL, "http://"),
+		stationID: "WLL",
+		client:    server.Client(),
+	}
+
+	obs, err := p.FetchObservation(context.Background(), WeatherSite{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(requests) != "[/v1/current_conditions]" {
+		t.Errorf("requests = %v, want just the current conditions", requests)
+	}
+
+	if obs.StationID != "WLL" {
+		t.Errorf("station = %q, want WLL", obs.StationID)
+	}
+	if want := time.Date(2024, 3, 14, 18, 53, 0, 0, time.UTC); !obs.ObservedAt.Equal(want) {
+		t.Errorf("observed at %v, want %v", obs.ObservedAt, want)
+	}
+
+	tests := []struct {
+		field ObservationField
+		want  float64
+	}{
+		{FieldTemperature, 62.7},
+		{FieldHumidity, 41.4},
+		{FieldDewpoint, 39},
+		{FieldHeatIndex, 61.2},
+		{FieldWindChill, 62.7},
+		{FieldFeelsLike, 61.2},
+		// Polls give the one-minute average wind
+		{FieldWindSpeed, 4.8},
+		{FieldWindDir, 205},
+		{FieldWindGust, 12},
+		{FieldRainRate, 0},
+		{FieldRain1Hour, 0.03},
+		{FieldRainToday, 0.12},
+		{FieldIndoorTemperature, 70.3},
+		{FieldIndoorHumidity, 36},
+		{FieldBarometer, 1013.2},
+		{FieldStationPressure, 977},
+	}
+	for _, tt := range tests {
+		if got := *numericFields[tt.field](&obs); !obs.Has(tt.field) || math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("%v = %v (filled %v), want %v", tt.field, got, obs.Has(tt.field), tt.want)
+		}
+	}
+
+	// Errors carry the message from the WeatherLink Live
+	err = p.get(context.Background(), "/v1/unknown", &WLLCurrentConditions{})
+	if err == nil || err.Error() != "WeatherLink Live error: Not Found (404)" {
+		t.Errorf("fetching an unknown path returned %v", err)
+	}
+}
+
+func TestWeatherLinkLiveBroadcasts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &WeatherLinkLiveProvider{}
+	packets := p.readBroadcasts(ctx, conn)
+
+	sender, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	// A broadcast that we can't decode is skipped
+	for _, b := range []string{"not json", wllTestBroadcast} {
+		_, err = sender.Write([]byte(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var packet WLLCurrentConditions
+	select {
+	case packet = <-packets:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a broadcast")
+	}
+
+	// Broadcasts update the wind and rain of the last poll and leave the rest
+	var obs CurrentObservation
+	obs.set(FieldTemperature, 62.7)
+	obs.set(FieldWindSpeed, 4.8)
+	packet.applyTo(&obs)
+
+	if want := time.Date(2024, 3, 14, 18, 53, 20, 0, time.UTC); !obs.ObservedAt.Equal(want) {
+		t.Errorf("observed at %v, want %v", obs.ObservedAt, want)
+	}
+
+	tests := []struct {
+		field ObservationField
+		want  float64
+	}{
+		{FieldTemperature, 62.7},
+		// Broadcasts only give the latest wind
+		{FieldWindSpeed, 9},
+		{FieldWindDir, 230},
+		{FieldWindGust, 15},
+		{FieldRainRate, 0.079},
+		{FieldRain1Hour, 0.039},
+		{FieldRainToday, 0.496},
+	}
+	for _, tt := range tests {
+		if got := *numericFields[tt.field](&obs); !obs.Has(tt.field) || math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("%v = %v (filled %v), want %v", tt.field, got, obs.Has(tt.field), tt.want)
+		}
+	}
+}
+
+func TestWLLRainInches(t *testing.T) {
+	tests := []struct {
+		counts   float64
+		rainSize int
+		want     float64
+	}{
+		{12, 1, 0.12},
+		{127, 2, 1},
+		{127, 3, 0.5},
+		{120, 4, 0.12},
+		{0, 2, 0},
+		// An unknown collector size
+		{12, 5, 0},
+		{12, 0, 0},
+	}
+
+	for _, tt := range tests {
+		if got := wllRainInches(tt.counts, tt.rainSize); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%v tips of size %v = %v\", want %v\"", tt.counts, tt.rainSize, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// This is where the Debian and RPM packages of WeeWX keep the archive database
+const defaultWeeWXDatabase = "/var/lib/weewx/weewx.sdb"
+
+// We check the loop packet file for changes this often.  Most stations send a loop
+// packet every few seconds.
+const weewxLoopCheckInterval = 2 * time.Second
+
+// Loop packets older than this are ignored, since WeeWX has probably stopped
+const weewxLoopMaxAge = 5 * time.Minute
+
+// WeeWX unit systems, as found in the usUnits field of every record
+const (
+	weewxUS       = 1
+	weewxMetric   = 16
+	weewxMetricWX = 17
+)
+
+// weewxUnitGroup says what a field measures, which decides the units that it's in
+type weewxUnitGroup int
+
+const (
+	weewxNoUnits weewxUnitGroup = iota
+	weewxTemperature
+	weewxSpeed
+	weewxPressure
+	weewxRain
+)
+
+func init() {
+	registerProvider("weewx", newWeeWXProvider)
+}
+
+// WeeWXProvider reads the conditions recorded by a WeeWX installation.  The latest
+// archive record comes from WeeWX's SQLite database.  If WeeWX is also writing its
+// loop packets to a JSON file, those update the conditions between archive records.
+type WeeWXProvider struct {
+	database  string
+	loopFile  string
+	stationID string
+	debug     bool
+
+	latestMutex sync.Mutex
+	archive     CurrentObservation
+	archiveTime time.Time
+	loop        map[string]interface{}
+	loopTime    time.Time
+}
+
+func newWeeWXProvider(w *WeatherBar) (WeatherProvider, error) {
+	p := &WeeWXProvider{
+		database:  w.cfg.Weather.WeeWXDatabase,
+		loopFile:  w.cfg.Weather.WeeWXLoopFile,
+		stationID: w.cfg.Weather.Station,
+		debug:     *w.debug,
+	}
+	if p.database == "" {
+		p.database = defaultWeeWXDatabase
+	}
+	if p.stationID == "" {
+		p.stationID = "weewx"
+	}
+	return p, nil
+}
+
+// Name returns the name of this provider
+func (p *WeeWXProvider) Name() string {
+	return "weewx"
+}
+
+// UpdateInterval returns how often we check the archive for a new record
+func (p *WeeWXProvider) UpdateInterval() time.Duration {
+	return weewxUpdateInterval
+}
+
+// SupportedFields returns the fields found in the WeeWX archive
+func (p *WeeWXProvider) SupportedFields() []ObservationField {
+	return []ObservationField{
+		FieldStationID,
+		FieldTemperature,
+		FieldHumidity,
+		FieldDewpoint,
+		FieldWindChill,
+		FieldHeatIndex,
+		FieldFeelsLike,
+		FieldWindDir,
+		FieldWindSpeed,
+		FieldWindGust,
+		FieldBarometer,
+		FieldRainToday,
+		FieldRain1Hour,
+		FieldRainRate,
+		FieldIndoorTemperature,
+		FieldIndoorHumidity,
+	}
+}
+
+// FetchObservation reads the latest archive record and brings it up to date with
+// the latest loop packet, if we have one
+func (p *WeeWXProvider) FetchObservation(ctx context.Context, site WeatherSite) (CurrentObservation, error) {
+	obs, recordTime, err := p.readArchive(ctx)
+	if err != nil {
+		return CurrentObservation{}, fmt.Errorf("error reading WeeWX archive: %v", err)
+	}
+
+	p.latestMutex.Lock()
+	defer p.latestMutex.Unlock()
+
+	if p.debug && !recordTime.Equal(p.archiveTime) {
+		log.Println("Read WeeWX archive record from", recordTime)
+	}
+	p.archive = obs
+	p.archiveTime = recordTime
+
+	return p.current(), nil
+}
+
+// Run polls the archive and, if we have a loop packet file, watches it for new
+// packets.  Every new record and packet produces an observation.
+func (p *WeeWXProvider) Run(ctx context.Context, obsChan chan<- CurrentObservation) error {
+	var loopChecks <-chan time.Time
+	if p.loopFile != "" {
+		loopTicker := time.NewTicker(weewxLoopCheckInterval)
+		defer loopTicker.Stop()
+		loopChecks = loopTicker.C
+	}
+
+	pollTicker := time.NewTicker(p.UpdateInterval())
+	defer pollTicker.Stop()
+
+	var loopModTime time.Time
+	send := func(obs CurrentObservation) bool {
+		select {
+		case obsChan <- obs:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	p.poll(ctx, send)
+
+	for {
+		select {
+		case <-pollTicker.C:
+			p.poll(ctx, send)
+
+		case <-loopChecks:
+			fi, err := os.Stat(p.loopFile)
+			if err != nil || fi.ModTime().Equal(loopModTime) {
+				continue
+			}
+			loopModTime = fi.ModTime()
+
+			packet, err := readWeeWXLoopPacket(p.loopFile)
+			if err != nil {
+				if p.debug {
+					log.Println("error reading WeeWX loop packet:", err)
+				}
+				continue
+			}
+
+			obs, ok := p.updateLoop(packet)
+			if ok && !send(obs) {
+				return nil
+			}
+
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// poll reads the archive and sends the result
+func (p *WeeWXProvider) poll(ctx context.Context, send func(CurrentObservation) bool) {
+	obs, err := p.FetchObservation(ctx, WeatherSite{})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	send(obs)
+}
+
+// updateLoop records a new loop packet and returns the updated conditions.  It
+// returns false if the packet is too old to use.
+func (p *WeeWXProvider) updateLoop(packet map[string]interface{}) (CurrentObservation, bool) {
+	ts, ok := weewxNumber(packet["dateTime"])
+	if !ok {
+		return CurrentObservation{}, false
+	}
+	packetTime := time.Unix(int64(ts), 0)
+	if time.Since(packetTime) > weewxLoopMaxAge {
+		return CurrentObservation{}, false
+	}
+
+	p.latestMutex.Lock()
+	defer p.latestMutex.Unlock()
+
+	p.loop = packet
+	p.loopTime = packetTime
+	return p.current(), true
+}
+
+// current lays the latest loop packet over the latest archive record.  The caller
+// must hold latestMutex.
+func (p *WeeWXProvider) current() CurrentObservation {
+	obs := p.archive
+	if p.loop != nil && p.loopTime.After(p.archiveTime) && time.Since(p.loopTime) <= weewxLoopMaxAge {
+		err := applyWeeWXRecord(p.loop, &obs)
+		if err != nil && p.debug {
+			log.Println("error applying WeeWX loop packet:", err)
+		}
+		obs.ObservedAt = p.loopTime
+	}
+	obs.setText(FieldStationID, p.stationID)
+	return obs
+}
+
+// readArchive reads the newest archive record along with the rain totals for the
+// hour and the day leading up to it.  WeeWX stamps each record with the end of its
+// archive interval, in the dateTime column.  We open the database read-only so that
+// SQLite's locking keeps us from seeing a record that WeeWX is half way through
+// writing.
+func (p *WeeWXProvider) readArchive(ctx context.Context) (CurrentObservation, time.Time, error) {
+	db, err := sql.Open("sqlite3", "file:"+p.database+"?mode=ro")
+	if err != nil {
+		return CurrentObservation{}, time.Time{}, err
+	}
+	defer db.Close()
+
+	record, err := weewxNewestRecord(ctx, db)
+	if err != nil {
+		return CurrentObservation{}, time.Time{}, err
+	}
+	if record == nil {
+		return CurrentObservation{}, time.Time{}, fmt.Errorf("%v has no archive records", p.database)
+	}
+
+	ts, ok := weewxNumber(record["dateTime"])
+	if !ok {
+		return CurrentObservation{}, time.Time{}, fmt.Errorf("newest record in %v has no dateTime", p.database)
+	}
+	recordTime := time.Unix(int64(ts), 0)
+
+	var obs CurrentObservation
+	err = applyWeeWXRecord(record, &obs)
+	if err != nil {
+		return CurrentObservation{}, time.Time{}, err
+	}
+
+	// A record stamped midnight covers the last minutes of yesterday
+	midnight := time.Date(recordTime.Year(), recordTime.Month(), recordTime.Day(), 0, 0, 0, 0, recordTime.Location())
+	rainToday, sawRain, err := weewxRainSince(ctx, db, midnight)
+	if err != nil {
+		return CurrentObservation{}, time.Time{}, err
+	}
+	rainHour, _, err := weewxRainSince(ctx, db, recordTime.Add(-time.Hour))
+	if err != nil {
+		return CurrentObservation{}, time.Time{}, err
+	}
+
+	// Adding up many small amounts leaves floating point noise behind
+	if sawRain {
+		obs.set(FieldRainToday, math.Round(rainToday*100)/100)
+		obs.set(FieldRain1Hour, math.Round(rainHour*100)/100)
+	}
+	obs.ObservedAt = recordTime
+
+	return obs, recordTime, nil
+}
+
+// weewxNewestRecord reads the newest archive record as a map from column names to
+// values.  It returns nil if the archive is empty.
+func weewxNewestRecord(ctx context.Context, db *sql.DB) (map[string]interface{}, error) {
+	rows, err := db.QueryContext(ctx, "SELECT * FROM archive ORDER BY dateTime DESC LIMIT 1")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	err = rows.Scan(pointers...)
+	if err != nil {
+		return nil, err
+	}
+
+	record := make(map[string]interface{})
+	for i, column := range columns {
+		record[column] = values[i]
+	}
+	return record, nil
+}
+
+// weewxRainSince adds up the rain in the archive records stamped after a time.  It
+// returns false if none of them recorded rain.
+func weewxRainSince(ctx context.Context, db *sql.DB, since time.Time) (float64, bool, error) {
+	rows, err := db.QueryContext(ctx, "SELECT usUnits, SUM(rain) FROM archive WHERE dateTime > ? AND rain IS NOT NULL GROUP BY usUnits", since.Unix())
+	if err != nil {
+		return 0, false, err
+	}
+	defer rows.Close()
+
+	var total float64
+	var sawRain bool
+	for rows.Next() {
+		var units int
+		var rain float64
+		err = rows.Scan(&units, &rain)
+		if err != nil {
+			return 0, false, err
+		}
+		rain, err = weewxConvert(rain, weewxRain, units)
+		if err != nil {
+			return 0, false, err
+		}
+		total += rain
+		sawRain = true
+	}
+	return total, sawRain, rows.Err()
+}
+
+// applyWeeWXRecord copies the fields of an archive record or loop packet into an
+// observation, converting them from the record's unit system
+func applyWeeWXRecord(rec map[string]interface{}, obs *CurrentObservation) error {
+	units, ok := weewxNumber(rec["usUnits"])
+	if !ok {
+		return fmt.Errorf("WeeWX record has no usUnits")
+	}
+
+	fields := []struct {
+		field   ObservationField
+		columns []string
+		group   weewxUnitGroup
+	}{
+		{FieldTemperature, []string{"outTemp"}, weewxTemperature},
+		{FieldHumidity, []string{"outHumidity"}, weewxNoUnits},
+		{FieldDewpoint, []string{"dewpoint"}, weewxTemperature},
+		{FieldWindChill, []string{"windchill"}, weewxTemperature},
+		{FieldHeatIndex, []string{"heatindex"}, weewxTemperature},
+		{FieldFeelsLike, []string{"appTemp"}, weewxTemperature},
+		{FieldWindDir, []string{"windDir"}, weewxNoUnits},
+		{FieldWindSpeed, []string{"windSpeed"}, weewxSpeed},
+		{FieldWindGust, []string{"windGust"}, weewxSpeed},
+		{FieldBarometer, []string{"barometer", "altimeter"}, weewxPressure},
+		{FieldRainRate, []string{"rainRate"}, weewxRain},
+		{FieldIndoorTemperature, []string{"inTemp"}, weewxTemperature},
+		{FieldIndoorHumidity, []string{"inHumidity"}, weewxNoUnits},
+
+		// Some station drivers put running rain totals in their loop packets
+		{FieldRainToday, []string{"dayRain"}, weewxRain},
+		{FieldRain1Hour, []string{"hourRain"}, weewxRain},
+	}
+
+	for _, f := range fields {
+		for _, column := range f.columns {
+			v, ok := weewxNumber(rec[column])
+			if !ok {
+				continue
+			}
+			v, err := weewxConvert(v, f.group, int(units))
+			if err != nil {
+				return err
+			}
+			obs.set(f.field, v)
+			break
+		}
+	}
+
+	return nil
+}
+
+// weewxConvert converts a value in one of WeeWX's unit systems into our units.
+// METRIC and METRICWX differ only in their wind speed and rain units.
+func weewxConvert(v float64, group weewxUnitGroup, units int) (float64, error) {
+	if units != weewxUS && units != weewxMetric && units != weewxMetricWX {
+		return 0, fmt.Errorf("unknown WeeWX unit system %v", units)
+	}
+	if group == weewxNoUnits {
+		return v, nil
+	}
+	if units == weewxUS {
+		// US pressures are in inches of mercury, and everything else is in our units
+		if group == weewxPressure {
+			return roundTenth(v * millibarsPerInchHg), nil
+		}
+		return v, nil
+	}
+
+	switch group {
+	case weewxTemperature:
+		return roundTenth(celsiusToFahrenheit(v)), nil
+	case weewxSpeed:
+		if units == weewxMetricWX {
+			// meters/second
+			return roundTenth(v * knotsPerMeterPerSecond * mphPerKnot), nil
+		}
+		return roundTenth(v / kphPerMph), nil
+	case weewxPressure:
+		// WeeWX's metric pressures are already in millibars
+		return v, nil
+	case weewxRain:
+		if units == weewxMetricWX {
+			return v / millimetersPerInch, nil
+		}
+		// METRIC measures rain in centimeters
+		return v * 10 / millimetersPerInch, nil
+	}
+
+	return v, nil
+}
+
+// weewxNumber converts a database or JSON value into a number
+func weewxNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// readWeeWXLoopPacket reads the newest loop packet from a file.  The file can hold
+// a single JSON object that's rewritten with every packet, or one packet per line
+// with new packets appended, in which case we only read the end of it.
+func readWeeWXLoopPacket(path string) (map[string]interface{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	const tailSize = 64 * 1024
+	offset := fi.Size() - tailSize
+	if offset < 0 {
+		offset = 0
+	}
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	tail, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var packet map[string]interface{}
+	lines := bytes.Split(bytes.TrimSpace(tail), []byte("\n"))
+	for i := len(lines) - 1; i >= 0; i-- {
+		if json.Unmarshal(lines[i], &packet) == nil {
+			return packet, nil
+		}
+	}
+
+	err = json.Unmarshal(tail, &packet)
+	if err != nil {
+		return nil, fmt.Errorf("%v does not contain a JSON loop packet", path)
+	}
+	return packet, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// makeWeeWXArchive creates an archive with a day of five-minute records in US
+// units, ending at newest.  Every hour's rain falls in the record at the top of
+// the hour.
+func makeWeeWXArchive(t *testing.T, newest time.Time) (string, *sql.DB) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "weewx.sdb")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`CREATE TABLE archive (dateTime INTEGER NOT NULL UNIQUE PRIMARY KEY, usUnits INTEGER NOT NULL,
+		interval INTEGER NOT NULL, barometer REAL, outTemp REAL, outHumidity REAL, dewpoint REAL, windSpeed REAL,
+		windGust REAL, windDir REAL, rain REAL)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 287; i >= 0; i-- {
+		ts := newest.Add(-time.Duration(i) * 5 * time.Minute)
+		var rain interface{}
+		if ts.Minute() == 0 {
+			rain = 0.01
+		}
+		_, err = db.Exec("INSERT INTO archive VALUES (?, 1, 5, 30.287, ?, 57, NULL, 4, 12, 220, ?)", ts.Unix(), 68.7-float64(i)/10, rain)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path, db
+}
+
+func TestWeeWXReadArchive(t *testing.T) {
+	newest := time.Date(2024, 3, 14, 13, 35, 0, 0, time.Local)
+	path, db := makeWeeWXArchive(t, newest)
+
+	p := &WeeWXProvider{database: path}
+	obs, recordTime, err := p.readArchive(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !recordTime.Equal(newest) || !obs.ObservedAt.Equal(newest) {
+		t.Errorf("record time = %v, want %v", recordTime, newest)
+	}
+	if obs.Temperature != 68.7 || obs.Humidity != 57 || obs.WindGust != 12 || obs.WindDir != 220 {
+		t.Errorf("temperature, humidity, gust, direction = %v, %v, %v, %v, want 68.7, 57, 12, 220",
+			obs.Temperature, obs.Humidity, obs.WindGust, obs.WindDir)
+	}
+	if math.Abs(obs.Barometer-1025.6) > 0.05 {
+		t.Errorf("barometer = %v, want 1025.6", obs.Barometer)
+	}
+	// Rain fell at 1:00 through 13:00 today, and the record at midnight belongs to
+	// yesterday
+	if obs.Rain1Hour != 0.01 || obs.RainToday != 0.13 {
+		t.Errorf("rain last hour, today = %v, %v, want 0.01, 0.13", obs.Rain1Hour, obs.RainToday)
+	}
+	if obs.Has(FieldDewpoint) || !obs.Has(FieldRainToday) {
+		t.Errorf("filled fields are wrong: dewpoint %v, rain today %v", obs.Has(FieldDewpoint), obs.Has(FieldRainToday))
+	}
+
+	// A record that WeeWX adds later shows up on the next read
+	next := newest.Add(5 * time.Minute)
+	_, err = db.Exec("INSERT INTO archive (dateTime, usUnits, interval, outTemp, rain) VALUES (?, 16, 5, 21, 0.1)", next.Unix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	obs, recordTime, err = p.readArchive(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !recordTime.Equal(next) || obs.Temperature != 69.8 || obs.Has(FieldHumidity) {
+		t.Errorf("after a new record, time, temperature = %v, %v (humidity filled %v), want %v, 69.8", recordTime, obs.Temperature, obs.Has(FieldHumidity), next)
+	}
+	// The new record is in METRIC units, which measure rain in centimeters
+	if math.Abs(obs.Rain1Hour-0.05) > 0.001 || math.Abs(obs.RainToday-0.17) > 0.001 {
+		t.Errorf("after a new record, rain last hour, today = %v, %v, want 0.05, 0.17", obs.Rain1Hour, obs.RainToday)
+	}
+
+	_, err = db.Exec("DELETE FROM archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = p.readArchive(context.Background())
+	if err == nil || err.Error() != path+" has no archive records" {
+		t.Errorf("reading an empty archive returned %v", err)
+	}
+
+	// The archive is opened read-only, so a missing one isn't created
+	p.database = filepath.Join(t.TempDir(), "missing.sdb")
+	_, _, err = p.readArchive(context.Background())
+	if err == nil {
+		t.Error("reading a missing archive succeeded")
+	}
+	if _, err := os.Stat(p.database); !os.IsNotExist(err) {
+		t.Error("reading a missing archive created it")
+	}
+}
@@ -1,198 +1,339 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"strconv"
+	"net/url"
 	"time"
 )
 
-const wgAPIBaseURL = "https://api.wunderground.com/api/"
-
-// GeoLookup encapsulates WU's geolocation API response object
-type GeoLookup struct {
-	Location Location `json:"location"`
-}
-
-// Location contains info related to the geolocation request
-type Location struct {
-	City           string         `json:"city"`
-	State          string         `json:"state"`
-	Zip            string         `json:"zip"`
-	Latitude       string         `json:"lat"`
-	Longitude      string         `json:"lon"`
-	NearbyStations NearbyStations `json:"nearby_weather_stations"`
-}
-
-// NearbyStations contains info about nearby weather stations
-type NearbyStations struct {
-	Airport AirportStations `json:"airport"`
-	PWS     PWStations      `json:"pws"`
-}
-
-// AirportStations contains a slice of AirportStation objects
-type AirportStations struct {
-	Station []AirportStation `json:"station"`
-}
-
-// AirportStation represents a weather station at an airport
-type AirportStation struct {
-	City      string `json:"city"`
-	State     string `json:"state"`
-	Country   string `json:"country"`
-	ICAO      string `json:"icao"`
-	Latitude  string `json:"lat"`
-	Longitude string `json:"lon"`
-}
-
-// PWStations contains a slice of PWStation objects
-type PWStations struct {
-	Station []PWStation `json:"station"`
-}
-
-// PWStation represents an unofficial, local weather station
-type PWStation struct {
-	ID           string  `json:"id"`
-	Neighborhood string  `json:"neighborhood"`
-	City         string  `json:"city"`
-	State        string  `json:"state"`
-	Country      string  `json:"country"`
-	Latitude     float64 `json:"lat"`
-	Longitude    float64 `json:"lon"`
-	Distance     int32   `json:"distance_mi"`
-}
-
-// Conditions encapsulates WU's conditions API response object
-type Conditions struct {
-	CurrentObservation CurrentObservation `json:"current_observation"`
-}
-
-// CurrentObservation represents the weather conditions right now for a given station
-type CurrentObservation struct {
-	StationID    string
-	Weather      string  `json:"weather"`
-	Temperature  float64 `json:"temp_f"`
-	HumidityStr  string  `json:"relative_humidity"`
-	Humidity     float64
-	Dewpoint     float64 `json:"dewpoint_f"`
-	WindChillStr string  `json:"windchill_f"`
-	WindChill    float64
-	HeatIndexStr string `json:"heat_index_f"`
-	HeatIndex    float64
-	WindDir      float64 `json:"wind_degrees"`
-	WindSpeed    float64 `json:"wind_mph"`
-	WindGust     string  `json:"wind_gust_mph"`
-	BarometerStr string  `json:"pressure_mb"`
-	Barometer    float64
-	RainTodayStr string `json:"precip_today_in"`
-	RainToday    float64
-	Rain1HourStr string `json:"precip_1hr_in"`
-	Rain1Hour    float64
-}
-
-func (w *WeatherBar) getCurrentConditionsFromWU(icao string, pws string) error {
-	var cond Conditions
-	var wuURL string
-
-	if icao != "" {
-		cond.CurrentObservation.StationID = icao
-		wuURL = wgAPIBaseURL + w.cfg.Weather.WUAPIKey + "/conditions/q/icao:" + icao + ".json"
-
-		if *w.debug {
-			log.Println("Fetching conditions for ICAO station", icao, "from Weather Underground...")
-		}
-	} else if pws != "" {
-		cond.CurrentObservation.StationID = pws
-		wuURL = wgAPIBaseURL + w.cfg.Weather.WUAPIKey + "/conditions/q/pws:" + pws + ".json"
+// Weather Underground's original API has been shut down.  Its successor lives at
+// weather.com: PWS data comes from the v2 PWS API and ICAO station data from the
+// v3 observations API.  Both accept the key that WU issues to PWS owners.
+const weatherComAPIBaseURL = "https://api.weather.com"
+
+// These errors let users tell a configuration problem apart from a temporary outage
+var (
+	ErrWUInvalidAPIKey   = errors.New("Weather Underground rejected the API key")
+	ErrWUUnknownStation  = errors.New("Weather Underground has no current data for the station")
+	ErrWUQuotaExhausted  = errors.New("Weather Underground API quota exhausted")
+	ErrWUUnexpectedReply = errors.New("unexpected response from Weather Underground")
+)
+
+func init() {
+	registerProvider("wu", newWUProvider)
+}
+
+// WUProvider fetches current conditions from Weather Underground
+type WUProvider struct {
+	baseURL string
+	client  *http.Client
+	apiKey  string
+	station string
+	debug   bool
+}
+
+// PWSObservations encapsulates the PWS v2 observations/current API response object
+type PWSObservations struct {
+	Observations []PWSObservation `json:"observations"`
+}
+
+// PWSObservation represents the conditions reported by a personal weather station.
+// Depending on the units requested, the measurements are found in either the
+// Imperial or the Metric block.
+type PWSObservation struct {
+	StationID      string     `json:"stationID"`
+	ObsTimeUtc     time.Time  `json:"obsTimeUtc"`
+	Neighborhood   string     `json:"neighborhood"`
+	Humidity       *float64   `json:"humidity"`
+	WindDir        *float64   `json:"winddir"`
+	SolarRadiation *float64   `json:"solarRadiation"`
+	UV             *float64   `json:"uv"`
+	Imperial       *PWSValues `json:"imperial"`
+	Metric         *PWSValues `json:"metric"`
+}
+
+// PWSValues holds the unit-dependent measurements from a personal weather station
+type PWSValues struct {
+	Temp        *float64 `json:"temp"`
+	HeatIndex   *float64 `json:"heatIndex"`
+	Dewpt       *float64 `json:"dewpt"`
+	WindChill   *float64 `json:"windChill"`
+	WindSpeed   *float64 `json:"windSpeed"`
+	WindGust    *float64 `json:"windGust"`
+	Pressure    *float64 `json:"pressure"`
+	PrecipRate  *float64 `json:"precipRate"`
+	PrecipTotal *float64 `json:"precipTotal"`
+}
+
+// ICAOObservation encapsulates the v3 observations/current API response object,
+// requested in imperial units.  Sea-level pressure is always reported in millibars.
+type ICAOObservation struct {
+	ValidTimeUtc         int64    `json:"validTimeUtc"`
+	WxPhraseLong         string   `json:"wxPhraseLong"`
+	Temperature          *float64 `json:"temperature"`
+	TemperatureDewPoint  *float64 `json:"temperatureDewPoint"`
+	TemperatureHeatIndex *float64 `json:"temperatureHeatIndex"`
+	TemperatureWindChill *float64 `json:"temperatureWindChill"`
+	TemperatureFeelsLike *float64 `json:"temperatureFeelsLike"`
+	RelativeHumidity     *float64 `json:"relativeHumidity"`
+	WindDirection        *float64 `json:"windDirection"`
+	WindSpeed            *float64 `json:"windSpeed"`
+	WindGust             *float64 `json:"windGust"`
+	PressureMeanSeaLevel *float64 `json:"pressureMeanSeaLevel"`
+	PressureAltimeter    *float64 `json:"pressureAltimeter"`
+	Precip1Hour          *float64 `json:"precip1Hour"`
+}
+
+// WUErrorResponse is the error body returned by the weather.com APIs
+type WUErrorResponse struct {
+	Errors []struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	} `json:"errors"`
+}
 
-		if *w.debug {
-			log.Println("Fetching conditions for PWS station", pws, "from Weather Underground...")
+func newWUProvider(w *WeatherBar) (WeatherProvider, error) {
+	if w.cfg.Weather.WUAPIKey == "" {
+		return nil, fmt.Errorf("the wu provider requires weather-underground-api-key to be set")
+	}
+	return &WUProvider{
+		baseURL: weatherComAPIBaseURL,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		apiKey:  w.cfg.Weather.WUAPIKey,
+		station: w.cfg.Weather.Station,
+		debug:   *w.debug,
+	}, nil
+}
+
+// Name returns the name of this provider
+func (p *WUProvider) Name() string {
+	return "wu"
+}
+
+// UpdateInterval returns the polling interval for Weather Underground
+func (p *WUProvider) UpdateInterval() time.Duration {
+	return wuUpdateInterval
+}
+
+// SupportedFields returns the fields populated by the API that our station uses.
+// Without a station in the config file, we use the nearest ICAO station.
+func (p *WUProvider) SupportedFields() []ObservationField {
+	if p.station != "" && !isICAOStation(p.station) {
+		return []ObservationField{
+			FieldStationID,
+			FieldTemperature,
+			FieldHumidity,
+			FieldDewpoint,
+			FieldWindChill,
+			FieldHeatIndex,
+			FieldWindDir,
+			FieldWindSpeed,
+			FieldWindGust,
+			FieldBarometer,
+			FieldRainToday,
 		}
-	} else {
-		return fmt.Errorf("Must provide either an ICAO station ID or a PWS ID")
 	}
+	return []ObservationField{
+		FieldStationID,
+		FieldWeather,
+		FieldTemperature,
+		FieldHumidity,
+		FieldDewpoint,
+		FieldWindChill,
+		FieldHeatIndex,
+		FieldFeelsLike,
+		FieldWindDir,
+		FieldWindSpeed,
+		FieldWindGust,
+		FieldBarometer,
+		FieldRain1Hour,
+	}
+}
 
-	// Fetch the conditions from WU over HTTPS
-	var c = &http.Client{Timeout: 10 * time.Second}
-	r, err := c.Get(wuURL)
-	if err != nil {
-		return err
+// FetchObservation fetches the current conditions for the site's station from WU
+func (p *WUProvider) FetchObservation(ctx context.Context, site WeatherSite) (CurrentObservation, error) {
+	// WU supports two types of stations: ICAO (official government-run stations)
+	// and PWS (personal weather stations, typically run by individuals, businesses, etc.)
+	if isICAOStation(site.StationID) {
+		return p.getICAOConditions(ctx, site.StationID)
 	}
-	defer r.Body.Close()
+	return p.getPWSConditions(ctx, site.StationID)
+}
 
-	// Decode the JSON we recieved
-	err = json.NewDecoder(r.Body).Decode(&cond)
-	if err != nil {
-		return err
+// isICAOStation returns true for a station ID that's 4 bytes long, which is almost
+// certainly an ICAO station.  Anything else we take to be a PWS ID.
+func isICAOStation(id string) bool {
+	return len(id) == 4
+}
+
+func (p *WUProvider) getPWSConditions(ctx context.Context, pws string) (CurrentObservation, error) {
+	if p.debug {
+		log.Println("Fetching conditions for PWS station", pws, "from Weather Underground...")
 	}
 
-	// WU sends some numeric conditions as strings, so we'll parse them into the proper types
-	// and check for obvious errors.
-	cond, err = parseConditions(cond)
+	q := url.Values{}
+	q.Set("stationId", pws)
+	q.Set("format", "json")
+	q.Set("units", "e")
+	q.Set("numericPrecision", "decimal")
+
+	var pwsObs PWSObservations
+	err := p.get(ctx, "/v2/pws/observations/current", q, pws, &pwsObs)
 	if err != nil {
-		log.Println("error parsing current conditions:", err)
+		return CurrentObservation{}, err
+	}
+	if len(pwsObs.Observations) == 0 {
+		return CurrentObservation{}, fmt.Errorf("%w: %v", ErrWUUnknownStation, pws)
 	}
 
-	if *w.debug {
-		log.Printf("Current observation: %+v\n", cond.CurrentObservation)
+	return pwsObs.Observations[0].toCurrentObservation(), nil
+}
+
+func (p *WUProvider) getICAOConditions(ctx context.Context, icao string) (CurrentObservation, error) {
+	if p.debug {
+		log.Println("Fetching conditions for ICAO station", icao, "from Weather Underground...")
 	}
 
-	w.wxObsChan <- cond.CurrentObservation
-	return nil
+	q := url.Values{}
+	q.Set("icaoCode", icao)
+	q.Set("format", "json")
+	q.Set("units", "e")
+	q.Set("language", "en-US")
+
+	var icaoObs ICAOObservation
+	err := p.get(ctx, "/v3/wx/observations/current", q, icao, &icaoObs)
+	if err != nil {
+		return CurrentObservation{}, err
+	}
+
+	return icaoObs.toCurrentObservation(icao), nil
 }
 
-func parseConditions(raw Conditions) (parsed Conditions, err error) {
-	// Set all of the parsed data to be the raw data.  We'll fill in the parsed
-	// data on top of the raw data as we go along.
-	parsed = raw
+// get fetches a weather.com API path and decodes the JSON response into v, turning
+// the API's status codes into errors that say what went wrong
+func (p *WUProvider) get(ctx context.Context, path string, q url.Values, stationID string, v interface{}) error {
+	q.Set("apiKey", p.apiKey)
+	req, err := http.NewRequest("GET", p.baseURL+path+"?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
 
-	parsed.CurrentObservation.Barometer, err = strconv.ParseFloat(raw.CurrentObservation.BarometerStr, 64)
+	r, err := p.client.Do(req.WithContext(ctx))
 	if err != nil {
-		return raw, err
+		return err
 	}
+	defer r.Body.Close()
 
-	if raw.CurrentObservation.HeatIndexStr == "NA" {
-		parsed.CurrentObservation.HeatIndex = 0
-	} else {
-		parsed.CurrentObservation.HeatIndex, err = strconv.ParseFloat(raw.CurrentObservation.HeatIndexStr, 64)
-		if err != nil {
-			return raw, err
-		}
+	switch r.StatusCode {
+	case http.StatusOK:
+		return json.NewDecoder(r.Body).Decode(v)
+	case http.StatusNoContent, http.StatusNotFound:
+		// The PWS API answers with no content for stations that don't exist or
+		// haven't reported recently
+		return fmt.Errorf("%w: %v", ErrWUUnknownStation, stationID)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w%v", ErrWUInvalidAPIKey, wuErrorDetail(r))
+	case http.StatusTooManyRequests:
+		return fmt.Errorf("%w%v", ErrWUQuotaExhausted, wuErrorDetail(r))
 	}
 
-	if raw.CurrentObservation.WindChillStr == "NA" {
-		parsed.CurrentObservation.WindChill = 0
-	} else {
-		parsed.CurrentObservation.WindChill, err = strconv.ParseFloat(raw.CurrentObservation.WindChillStr, 64)
-		if err != nil {
-			return raw, err
-		}
+	return fmt.Errorf("%w: %v%v", ErrWUUnexpectedReply, r.Status, wuErrorDetail(r))
+}
+
+// wuErrorDetail extracts the message from a weather.com error response, if there is one
+func wuErrorDetail(r *http.Response) string {
+	var e WUErrorResponse
+	if json.NewDecoder(r.Body).Decode(&e) != nil || len(e.Errors) == 0 {
+		return ""
 	}
+	return fmt.Sprintf(" (%v: %v)", e.Errors[0].Error.Code, e.Errors[0].Error.Message)
+}
 
-	parsed.CurrentObservation.Humidity, err = strconv.ParseFloat(raw.CurrentObservation.HumidityStr[:(len(raw.CurrentObservation.HumidityStr)-1)], 64)
-	if err != nil {
-		return raw, err
+// toCurrentObservation maps a PWS observation into a CurrentObservation, converting
+// metric values if that's all the station sent
+func (o PWSObservation) toCurrentObservation() CurrentObservation {
+	obs := CurrentObservation{ObservedAt: o.ObsTimeUtc}
+	obs.setText(FieldStationID, o.StationID)
+
+	if o.Humidity != nil {
+		obs.set(FieldHumidity, *o.Humidity)
+	}
+	if o.WindDir != nil {
+		obs.set(FieldWindDir, *o.WindDir)
 	}
 
-	if raw.CurrentObservation.Rain1HourStr[0] == '-' {
-		parsed.CurrentObservation.Rain1Hour = 0
-	} else {
-		parsed.CurrentObservation.Rain1Hour, err = strconv.ParseFloat(raw.CurrentObservation.Rain1HourStr, 64)
-		if err != nil {
-			return raw, err
+	if o.Imperial != nil {
+		v := o.Imperial
+		obs.setIfPresent(FieldTemperature, v.Temp)
+		obs.setIfPresent(FieldHeatIndex, v.HeatIndex)
+		obs.setIfPresent(FieldDewpoint, v.Dewpt)
+		obs.setIfPresent(FieldWindChill, v.WindChill)
+		obs.setIfPresent(FieldWindSpeed, v.WindSpeed)
+		obs.setIfPresent(FieldWindGust, v.WindGust)
+		obs.setIfPresent(FieldRainToday, v.PrecipTotal)
+		if v.Pressure != nil {
+			obs.set(FieldBarometer, roundTenth(*v.Pressure*millibarsPerInchHg))
+		}
+	} else if o.Metric != nil {
+		v := o.Metric
+		if v.Temp != nil {
+			obs.set(FieldTemperature, roundTenth(celsiusToFahrenheit(*v.Temp)))
+		}
+		if v.HeatIndex != nil {
+			obs.set(FieldHeatIndex, roundTenth(celsiusToFahrenheit(*v.HeatIndex)))
+		}
+		if v.Dewpt != nil {
+			obs.set(FieldDewpoint, roundTenth(celsiusToFahrenheit(*v.Dewpt)))
+		}
+		if v.WindChill != nil {
+			obs.set(FieldWindChill, roundTenth(celsiusToFahrenheit(*v.WindChill)))
 		}
+		if v.WindSpeed != nil {
+			obs.set(FieldWindSpeed, roundTenth(*v.WindSpeed/kphPerMph))
+		}
+		if v.WindGust != nil {
+			obs.set(FieldWindGust, roundTenth(*v.WindGust/kphPerMph))
+		}
+		if v.PrecipTotal != nil {
+			obs.set(FieldRainToday, *v.PrecipTotal/millimetersPerInch)
+		}
+		obs.setIfPresent(FieldBarometer, v.Pressure)
 	}
 
-	if raw.CurrentObservation.RainTodayStr[0] == '-' {
-		parsed.CurrentObservation.RainToday = 0
-	} else {
-		parsed.CurrentObservation.RainToday, err = strconv.ParseFloat(raw.CurrentObservation.RainTodayStr, 64)
-		if err != nil {
-			return raw, err
-		}
+	return obs
+}
+
+// toCurrentObservation maps an ICAO station observation into a CurrentObservation
+func (o ICAOObservation) toCurrentObservation(icao string) CurrentObservation {
+	var obs CurrentObservation
+	obs.setText(FieldStationID, icao)
+	obs.setText(FieldWeather, o.WxPhraseLong)
+	if o.ValidTimeUtc != 0 {
+		obs.ObservedAt = time.Unix(o.ValidTimeUtc, 0)
+	}
+
+	obs.setIfPresent(FieldTemperature, o.Temperature)
+	obs.setIfPresent(FieldDewpoint, o.TemperatureDewPoint)
+	obs.setIfPresent(FieldHeatIndex, o.TemperatureHeatIndex)
+	obs.setIfPresent(FieldWindChill, o.TemperatureWindChill)
+	obs.setIfPresent(FieldFeelsLike, o.TemperatureFeelsLike)
+	obs.setIfPresent(FieldHumidity, o.RelativeHumidity)
+	obs.setIfPresent(FieldWindDir, o.WindDirection)
+	obs.setIfPresent(FieldWindSpeed, o.WindSpeed)
+	obs.setIfPresent(FieldWindGust, o.WindGust)
+	if o.PressureMeanSeaLevel != nil {
+		obs.set(FieldBarometer, *o.PressureMeanSeaLevel)
+	} else if o.PressureAltimeter != nil {
+		obs.set(FieldBarometer, roundTenth(*o.PressureAltimeter*millibarsPerInchHg))
 	}
+	obs.setIfPresent(FieldRain1Hour, o.Precip1Hour)
 
-	return parsed, nil
+	return obs
 }
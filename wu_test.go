@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// filledFields lists the fields that an observation has filled in, sorted
+func filledFields(obs CurrentObservation) []string {
+	var fields []string
+	for _, f := range observationFields {
+		if obs.Has(f) {
+			fields = append(fields, string(f))
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// sortedFields sorts a list of fields for comparison
+func sortedFields(list []ObservationField) []string {
+	var fields []string
+	for _, f := range list {
+		fields = append(fields, string(f))
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func TestWUSupportedFields(t *testing.T) {
+	const pwsJSON = `{"stationID": "KKSMANHA42", "obsTimeUtc": "2024-03-14T18:53:00Z", "humidity": 50, "winddir": 220,
+		"imperial": {"temp": 68.2, "heatIndex": 68.2, "dewpt": 49.1, "windChill": 68.2, "windSpeed": 4, "windGust": 9,
+			"pressure": 29.92, "precipRate": 0, "precipTotal": 0.12}}`
+	const icaoJSON = `{"validTimeUtc": 1710442380, "wxPhraseLong": "Partly Cloudy", "temperature": 68, "temperatureDewPoint": 49,
+		"temperatureHeatIndex": 68, "temperatureWindChill": 68, "temperatureFeelsLike": 68, "relativeHumidity": 50,
+		"windDirection": 220, "windSpeed": 4, "windGust": 9, "pressureMeanSeaLevel": 1013.2, "pressureAltimeter": 29.92,
+		"precip1Hour": 0}`
+
+	var pws PWSObservation
+	err := json.Unmarshal([]byte(pwsJSON), &pws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var icao ICAOObservation
+	err = json.Unmarshal([]byte(icaoJSON), &icao)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		station string
+		obs     CurrentObservation
+	}{
+		{"KKSMANHA42", pws.toCurrentObservation()},
+		{"KMHK", icao.toCurrentObservation("KMHK")},
+		// Without a station, we use the nearest ICAO station
+		{"", icao.toCurrentObservation("KMHK")},
+	}
+
+	for _, tt := range tests {
+		p := &WUProvider{station: tt.station}
+		got, want := sortedFields(p.SupportedFields()), filledFields(tt.obs)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("station %q: supported fields are %v, but a full observation fills %v", tt.station, got, want)
+		}
+	}
+}